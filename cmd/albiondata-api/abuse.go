@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+func init() {
+	viper.SetDefault("abuse.volumeFactor", 100)
+	viper.SetDefault("abuse.minRequests", 120)
+	viper.SetDefault("abuse.wildcardPatterns", 20)
+	viper.SetDefault("abuse.wildcardWindow", 600)
+	viper.SetDefault("abuse.throttle.duration", 900)
+}
+
+// Minutes of history kept per client, the volume baseline is their average
+const abuseMinutes = 60
+
+// Most flags kept per client, older ones make room for new ones
+const maxAbuseFlags = 50
+
+// abuseFlag is one anomaly seen for a client.
+type abuseFlag struct {
+	reason   string
+	detected time.Time
+}
+
+// clientPattern is the recent traffic of one key or IP.
+type clientPattern struct {
+	minutes        [abuseMinutes]int
+	minute         int64 // unix minute of minutes[minute % abuseMinutes]
+	seen           int64 // first unix minute with traffic
+	wildcards      map[string]time.Time
+	flags          []abuseFlag
+	throttledUntil time.Time
+}
+
+// advance moves the window to minute, clearing the minutes skipped.
+func (cp *clientPattern) advance(minute int64) {
+	if minute-cp.minute >= abuseMinutes {
+		cp.minutes = [abuseMinutes]int{}
+	} else {
+		for m := cp.minute + 1; m <= minute; m++ {
+			cp.minutes[m%abuseMinutes] = 0
+		}
+	}
+	cp.minute = minute
+}
+
+// baseline is the average requests per minute before the current one, over
+// the minutes the client has been seen.
+func (cp *clientPattern) baseline() float64 {
+	n := cp.minute - cp.seen
+	if n > abuseMinutes-1 {
+		n = abuseMinutes - 1
+	}
+	if n <= 0 {
+		return 0
+	}
+	sum := 0
+	for m := cp.minute - n; m < cp.minute; m++ {
+		sum += cp.minutes[m%abuseMinutes]
+	}
+	return float64(sum) / float64(n)
+}
+
+func (cp *clientPattern) hourTotal() int {
+	sum := 0
+	for _, n := range cp.minutes {
+		sum += n
+	}
+	return sum
+}
+
+func (cp *clientPattern) flag(reason string, now time.Time) {
+	// One flag per reason and minute is enough
+	if len(cp.flags) > 0 {
+		last := cp.flags[len(cp.flags)-1]
+		if last.reason == reason && now.Sub(last.detected) < time.Minute {
+			return
+		}
+	}
+	cp.flags = append(cp.flags, abuseFlag{reason: reason, detected: now})
+	if len(cp.flags) > maxAbuseFlags {
+		cp.flags = append([]abuseFlag(nil), cp.flags[len(cp.flags)-maxAbuseFlags:]...)
+	}
+	if viper.GetBool("abuse.throttle.enabled") {
+		cp.throttledUntil = now.Add(time.Duration(viper.GetInt("abuse.throttle.duration")) * time.Second)
+	}
+}
+
+// abuseDetector watches the request patterns of every key and IP, flags
+// sudden volume jumps of abuse.volumeFactor over the client's baseline and
+// wildcard scraping (more than abuse.wildcardPatterns different patterns in
+// abuse.wildcardWindow seconds), and optionally throttles flagged clients.
+// Patterns are per instance and kept for an hour after the last request.
+type abuseDetector struct {
+	sync.Mutex
+	clients map[string]*clientPattern
+	pruned  int64
+}
+
+var abuse = &abuseDetector{clients: map[string]*clientPattern{}}
+
+// Record counts a request and returns until when the client is throttled.
+func (ad *abuseDetector) Record(client string, wildcard string) time.Time {
+	now := time.Now()
+	minute := now.Unix() / 60
+
+	ad.Lock()
+	defer ad.Unlock()
+	if minute != ad.pruned {
+		ad.prune(minute)
+	}
+
+	cp, ok := ad.clients[client]
+	if !ok {
+		cp = &clientPattern{minute: minute, seen: minute, wildcards: map[string]time.Time{}}
+		ad.clients[client] = cp
+	}
+	cp.advance(minute)
+	cp.minutes[minute%abuseMinutes]++
+
+	current := cp.minutes[minute%abuseMinutes]
+	if base := cp.baseline(); base > 0 && current >= viper.GetInt("abuse.minRequests") &&
+		float64(current) >= base*viper.GetFloat64("abuse.volumeFactor") {
+		cp.flag(fmt.Sprintf("volume: %d requests this minute, %.1f per minute before", current, base), now)
+	}
+
+	if wildcard != "" {
+		window := time.Duration(viper.GetInt("abuse.wildcardWindow")) * time.Second
+		cp.wildcards[wildcard] = now
+		for pattern, t := range cp.wildcards {
+			if now.Sub(t) > window {
+				delete(cp.wildcards, pattern)
+			}
+		}
+		// One pattern past the limit is enough to flag the client, a scraper
+		// with endless patterns keeps replacing the oldest one
+		limit := viper.GetInt("abuse.wildcardPatterns")
+		for len(cp.wildcards) > limit+1 {
+			oldest := ""
+			for pattern, t := range cp.wildcards {
+				if oldest == "" || t.Before(cp.wildcards[oldest]) {
+					oldest = pattern
+				}
+			}
+			delete(cp.wildcards, oldest)
+		}
+		if len(cp.wildcards) > limit {
+			cp.flag(fmt.Sprintf("wildcards: more than %d patterns in %s", limit, window), now)
+		}
+	}
+	return cp.throttledUntil
+}
+
+// prune drops clients without requests for an hour.
+func (ad *abuseDetector) prune(minute int64) {
+	ad.pruned = minute
+	for client, cp := range ad.clients {
+		if minute-cp.minute >= abuseMinutes && time.Now().After(cp.throttledUntil) {
+			delete(ad.clients, client)
+		}
+	}
+}
+
+// Report lists the flagged and throttled clients, the most recently flagged
+// first.
+func (ad *abuseDetector) Report() []lib.APIAbuseClient {
+	ad.Lock()
+	defer ad.Unlock()
+
+	minute := time.Now().Unix() / 60
+	result := []lib.APIAbuseClient{}
+	for client, cp := range ad.clients {
+		if len(cp.flags) == 0 && time.Now().After(cp.throttledUntil) {
+			continue
+		}
+		cp.advance(minute)
+		entry := lib.APIAbuseClient{
+			Client:           client,
+			Requests:         cp.hourTotal(),
+			CurrentMinute:    cp.minutes[minute%abuseMinutes],
+			Baseline:         cp.baseline(),
+			WildcardPatterns: len(cp.wildcards),
+			Flags:            []lib.APIAbuseFlag{},
+		}
+		for _, f := range cp.flags {
+			entry.Flags = append(entry.Flags, lib.APIAbuseFlag{Reason: f.reason, Detected: lib.Timestamp(f.detected)})
+		}
+		if time.Now().Before(cp.throttledUntil) {
+			until := lib.Timestamp(cp.throttledUntil)
+			entry.ThrottledUntil = &until
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return lastFlagged(result[i]).After(lastFlagged(result[j]))
+	})
+	return result
+}
+
+func lastFlagged(c lib.APIAbuseClient) time.Time {
+	if len(c.Flags) == 0 {
+		return time.Time{}
+	}
+	return time.Time(c.Flags[len(c.Flags)-1].Detected)
+}
+
+// Clear forgets the flags of a client and lifts its throttle.
+func (ad *abuseDetector) Clear(client string) bool {
+	ad.Lock()
+	defer ad.Unlock()
+	cp, ok := ad.clients[client]
+	if !ok {
+		return false
+	}
+	cp.flags = nil
+	cp.throttledUntil = time.Time{}
+	return true
+}
+
+// abuseClient names the client like rateLimitClient, with the key name in
+// place of the key so reports don't show secrets.
+func abuseClient(c echo.Context) string {
+	prefix := ""
+	if name := tenantName(c); name != "" {
+		prefix = "tenant:" + name + "|"
+	}
+	if k, ok := c.Get("apiKey").(apiKey); ok {
+		return prefix + "key:" + k.Name
+	}
+	return prefix + "ip:" + c.RealIP()
+}
+
+// detectAbuse records every /api request and answers 429 to throttled
+// clients.
+func detectAbuse(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !strings.HasPrefix(c.Request().URL.Path, "/api/") || c.Path() == usagePath || isInternal(c) {
+			return next(c)
+		}
+
+		wildcard := ""
+		for _, items := range []string{c.Param("item"), c.QueryParam("items")} {
+			if strings.Contains(items, "*") {
+				wildcard = items
+			}
+		}
+		if until := abuse.Record(abuseClient(c), wildcard); time.Now().Before(until) {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			return c.String(http.StatusTooManyRequests, "Unusual request pattern, temporarily throttled")
+		}
+		return next(c)
+	}
+}
+
+func adminHandleAbuse(c echo.Context) error {
+	return renderJSON(c, http.StatusOK, abuse.Report())
+}
+
+// adminHandleAbuseClear clears the client of ?client=, as listed by
+// /admin/abuse.
+func adminHandleAbuseClear(c echo.Context) error {
+	client := c.QueryParam("client")
+	if client == "" {
+		return c.String(http.StatusBadRequest, "client is required")
+	}
+	if !abuse.Clear(client) {
+		return c.String(http.StatusNotFound, "No such client")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
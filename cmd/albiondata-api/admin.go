@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// adminAuth requires the configured adminKey as a bearer token, or a JWT
+// with the admin claim, and with adminClientCA a client certificate.
+func adminAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if adminCertRequired() && !hasClientCert(c) {
+			return c.String(http.StatusUnauthorized, "A client certificate is required")
+		}
+		if !isAdmin(c) {
+			return c.String(http.StatusUnauthorized, "Invalid admin key")
+		}
+		return next(c)
+	}
+}
+
+func adminHandleCacheStats(c echo.Context) error {
+	entries, hits, misses := respCache.Stats()
+	return renderJSON(c, http.StatusOK, lib.APIAdminCacheStats{
+		Entries:    entries,
+		Hits:       hits,
+		Misses:     misses,
+		TTLSeconds: int(cacheTTL().Seconds()),
+	})
+}
+
+// adminHandleCachePurge purges by ?item= or ?pattern=, or everything when
+// neither is given.
+func adminHandleCachePurge(c echo.Context) error {
+	pattern := c.QueryParam("pattern")
+	if item := c.QueryParam("item"); len(item) > 0 {
+		pattern = item
+	}
+	purged := respCache.Purge(pattern)
+	cluster.Broadcast(invalidateCache, pattern)
+	return renderJSON(c, http.StatusOK, lib.APIAdminCachePurge{
+		Purged: purged,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("admission.lowPriorityShare", 0.75)
+	viper.SetDefault("admission.queueTimeout", 2000)
+	viper.SetDefault("admission.retryAfter", 5)
+}
+
+// Admission priorities, higher ones are let in first
+const (
+	priorityLow    = iota // anonymous expensive requests
+	priorityMedium        // anonymous cheap or authenticated expensive requests
+	priorityHigh          // authenticated cheap requests
+	numPriorities
+)
+
+// admissionController keeps the requests in progress under
+// admission.maxInFlight. Once full, requests queue per priority and a
+// finished request hands its slot to the oldest request of the highest
+// priority waiting. Low priority requests never queue, they are only let
+// in while less than admission.lowPriorityShare of the slots are taken.
+type admissionController struct {
+	sync.Mutex
+	inFlight int
+	waiting  [numPriorities][]chan struct{}
+}
+
+var admission = &admissionController{}
+
+func (ac *admissionController) limit(priority int) int {
+	max := viper.GetInt("admission.maxInFlight")
+	if priority == priorityLow {
+		return int(float64(max) * viper.GetFloat64("admission.lowPriorityShare"))
+	}
+	return max
+}
+
+// queuedAhead reports whether requests of priority or higher are waiting.
+func (ac *admissionController) queuedAhead(priority int) bool {
+	for p := priority; p < numPriorities; p++ {
+		if len(ac.waiting[p]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Acquire takes a slot, waiting up to timeout for one. It returns false
+// when the request has to be turned away.
+func (ac *admissionController) Acquire(priority int, timeout time.Duration) bool {
+	ac.Lock()
+	if ac.inFlight < ac.limit(priority) && !ac.queuedAhead(priority) {
+		ac.inFlight++
+		ac.Unlock()
+		return true
+	}
+	if priority == priorityLow {
+		ac.Unlock()
+		return false
+	}
+	ch := make(chan struct{}, 1)
+	ac.waiting[priority] = append(ac.waiting[priority], ch)
+	ac.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	}
+
+	ac.Lock()
+	defer ac.Unlock()
+	for i, w := range ac.waiting[priority] {
+		if w == ch {
+			ac.waiting[priority] = append(ac.waiting[priority][:i], ac.waiting[priority][i+1:]...)
+			return false
+		}
+	}
+	// Release handed the slot over just as the timer fired
+	return true
+}
+
+// Release gives the slot to the next waiting request, or frees it.
+func (ac *admissionController) Release() {
+	ac.Lock()
+	defer ac.Unlock()
+	for p := numPriorities - 1; p > priorityLow; p-- {
+		if len(ac.waiting[p]) > 0 {
+			ch := ac.waiting[p][0]
+			ac.waiting[p] = ac.waiting[p][1:]
+			ch <- struct{}{}
+			return
+		}
+	}
+	ac.inFlight--
+}
+
+// requestPriority ranks a request by whether it has an API key (or JWT)
+// and its request class.
+func requestPriority(c echo.Context) int {
+	_, authenticated := c.Get("apiKey").(apiKey)
+	cheap := requestClass(c) == classCheap
+	switch {
+	case authenticated && cheap:
+		return priorityHigh
+	case authenticated || cheap:
+		return priorityMedium
+	default:
+		return priorityLow
+	}
+}
+
+// admitRequest is the admission controller middleware, it does nothing
+// without admission.maxInFlight. Admin requests are always let in, long
+// polls too as they would hold a slot for up to longPollTimeout while
+// waiting, requestClasses.longpoll bounds them instead.
+func admitRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if viper.GetInt("admission.maxInFlight") <= 0 || strings.HasPrefix(c.Path(), "/admin") || isInternal(c) ||
+			requestClass(c) == classLongPoll {
+			return next(c)
+		}
+
+		timeout := time.Duration(viper.GetInt("admission.queueTimeout")) * time.Millisecond
+		if !admission.Acquire(requestPriority(c), timeout) {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(viper.GetInt("admission.retryAfter")))
+			return c.String(http.StatusServiceUnavailable, fmt.Sprintf("The server is busy, try again in %d seconds", viper.GetInt("admission.retryAfter")))
+		}
+		defer admission.Release()
+		return next(c)
+	}
+}
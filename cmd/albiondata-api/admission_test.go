@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// waitQueued waits until n requests of priority are queued.
+func waitQueued(t *testing.T, ac *admissionController, priority int, n int) {
+	for i := 0; i < 1000; i++ {
+		ac.Lock()
+		queued := len(ac.waiting[priority])
+		ac.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d requests of priority %d never queued", n, priority)
+}
+
+func TestAdmissionHandoff(t *testing.T) {
+	viper.Set("admission.maxInFlight", 1)
+	defer viper.Set("admission.maxInFlight", 0)
+	ac := &admissionController{}
+
+	if !ac.Acquire(priorityMedium, 0) {
+		t.Fatal("the first request didn't get the free slot")
+	}
+	if ac.Acquire(priorityLow, time.Second) {
+		t.Fatal("a low priority request got in while full")
+	}
+
+	order := make(chan int, 2)
+	go func() {
+		if ac.Acquire(priorityMedium, time.Second) {
+			order <- priorityMedium
+		}
+	}()
+	waitQueued(t, ac, priorityMedium, 1)
+	go func() {
+		if ac.Acquire(priorityHigh, time.Second) {
+			order <- priorityHigh
+		}
+	}()
+	waitQueued(t, ac, priorityHigh, 1)
+
+	// Each release hands the slot to the highest priority waiting
+	for _, want := range []int{priorityHigh, priorityMedium} {
+		ac.Release()
+		if got := <-order; got != want {
+			t.Fatalf("slot went to priority %d, want %d", got, want)
+		}
+		ac.Lock()
+		inFlight := ac.inFlight
+		ac.Unlock()
+		if inFlight != 1 {
+			t.Fatalf("%d in flight after a handoff, want 1", inFlight)
+		}
+	}
+
+	ac.Release()
+	if ac.inFlight != 0 {
+		t.Fatalf("%d in flight after the last release, want 0", ac.inFlight)
+	}
+}
+
+func TestAdmissionTimeout(t *testing.T) {
+	viper.Set("admission.maxInFlight", 1)
+	defer viper.Set("admission.maxInFlight", 0)
+	ac := &admissionController{}
+
+	ac.Acquire(priorityHigh, 0)
+	if ac.Acquire(priorityHigh, 10*time.Millisecond) {
+		t.Fatal("a request got in while full")
+	}
+	if len(ac.waiting[priorityHigh]) != 0 {
+		t.Fatal("a timed out request stayed queued")
+	}
+	ac.Release()
+	if ac.inFlight != 0 {
+		t.Fatalf("%d in flight after the release, want 0", ac.inFlight)
+	}
+}
+
+func TestAdmissionLowPriorityShare(t *testing.T) {
+	viper.Set("admission.maxInFlight", 4)
+	defer viper.Set("admission.maxInFlight", 0)
+	ac := &admissionController{}
+
+	tests := []struct {
+		priority int
+		want     bool
+	}{
+		{priorityLow, true},
+		{priorityLow, true},
+		{priorityLow, true},
+		{priorityLow, false},
+		{priorityMedium, true},
+	}
+	for i, tt := range tests {
+		if got := ac.Acquire(tt.priority, 0); got != tt.want {
+			t.Errorf("request %d of priority %d: admitted = %v, want %v", i, tt.priority, got, tt.want)
+		}
+	}
+}
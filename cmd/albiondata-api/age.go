@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// ageSetting reads ages.<endpoint>.<key>, falling back to the global key and
+// then to minUpdatedAt.
+func ageSetting(endpoint string, key string) int {
+	if v := viper.GetInt("ages." + endpoint + "." + key); v > 0 {
+		return v
+	}
+	if v := viper.GetInt(key); v > 0 {
+		return v
+	}
+	return viper.GetInt("minUpdatedAt")
+}
+
+// ageWindow returns how many seconds back from now an endpoint looks at
+// orders: ?age= if given, the default age otherwise. Asking for more than
+// the endpoint's or the API key tier's maximum is an error.
+func ageWindow(c echo.Context, endpoint string) (int, error) {
+	maxAge := ageSetting(endpoint, "maxAge")
+	if t := tierFor(c); t.MaxAge > 0 && t.MaxAge < maxAge {
+		maxAge = t.MaxAge
+	}
+
+	age := ageSetting(endpoint, "defaultAge")
+	if len(c.QueryParam("age")) > 0 {
+		var err error
+		age, err = strconv.Atoi(c.QueryParam("age"))
+		if err != nil || age < 0 {
+			return 0, fmt.Errorf("age must be a positive number of seconds")
+		}
+		if age > maxAge {
+			return 0, fmt.Errorf("age can't be more than %d seconds", maxAge)
+		}
+	}
+
+	if age > maxAge {
+		age = maxAge
+	}
+	return age, nil
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -16,7 +16,7 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 
-	"github.com/broderickhyman/albiondata-api/lib"
+	"github.com/tikz/albiondata-api/lib"
 	adslib "github.com/tikz/albiondata-sql/lib"
 
 	"github.com/labstack/echo"
@@ -47,18 +47,120 @@ func init() {
 	rootCmd.PersistentFlags().StringP("listen", "l", "[::1]:3080", "Host and port to listen on")
 	rootCmd.PersistentFlags().StringP("dbType", "t", "mysql", "Database type must be one of mysql, postgresql, sqlite3")
 	rootCmd.PersistentFlags().StringP("dbURI", "u", "", "Databse URI to connect to, see: http://jinzhu.me/gorm/database.html#connecting-to-a-database")
-	rootCmd.PersistentFlags().IntP("minUpdatedAt", "m", 172800, "UpdatedAt must be >= now - this seconds")
+	rootCmd.PersistentFlags().IntP("minUpdatedAt", "m", 172800, "Used for defaultAge and maxAge when they aren't set")
+	rootCmd.PersistentFlags().Int("defaultAge", 0, "Seconds back from now orders are looked at when the request has no age param")
+	rootCmd.PersistentFlags().Int("maxAge", 0, "Largest age param in seconds a request may ask for, larger ones get 400")
 	rootCmd.PersistentFlags().Bool("useHttps", false, "useHttps enables or disables AutoTLS")
 	rootCmd.PersistentFlags().String("autoCertCacheDirectory", "", "Used when useHttps is true. Stores the TLS files in specified directory. https://echo.labstack.com/cookbook/auto-tls")
-	rootCmd.PersistentFlags().String("staticFolderPath", "", "--DANGER-- Path to folder where static files reside for web server. https://echo.labstack.com/guide/static-files")
-	rootCmd.PersistentFlags().String("staticFilePrefix", "", "--DANGER-- Prefix for static files to be served as, like example.com/prefix/index.html. https://echo.labstack.com/guide/static-files")
+	rootCmd.PersistentFlags().String("itemsFile", "", "Path to the ao-bin-dumps formatted/items.json, enables item names in queries")
+	rootCmd.PersistentFlags().Int("qualityChartsDays", 30, "Days of orders charts filtered by quality reach back")
+	rootCmd.PersistentFlags().Int("cacheTTL", 60, "Seconds to cache API responses in memory, 0 disables the cache")
+	rootCmd.PersistentFlags().Int("cacheMaxEntries", 10000, "Most API responses kept in memory, the least recently used ones make room for new ones")
+	rootCmd.PersistentFlags().Int("longPollTimeout", 30, "Maximum seconds a /api/v1/changes request waits for new data")
+	rootCmd.PersistentFlags().String("leaderElection", "none", "How replicas pick the one running background jobs, one of none, db")
+	rootCmd.PersistentFlags().String("trustedProxies", "", "Comma separated IPs/CIDRs allowed to set X-Forwarded-For and X-Real-IP, when empty the headers are ignored")
+	rootCmd.PersistentFlags().Int("rateLimit", 0, "Requests per client allowed in each rateLimitWindow on /api, 0 disables rate limiting")
+	rootCmd.PersistentFlags().Int("rateLimitWindow", 60, "Length of the rate limit window in seconds")
+	rootCmd.PersistentFlags().Int("usageWindow", 86400, "Seconds of requests the usage endpoint counts, a day starts at midnight UTC, 0 counts since start")
+	rootCmd.PersistentFlags().String("rateLimitBackend", "memory", "Where rate limit counters are kept, one of memory, redis")
+	rootCmd.PersistentFlags().String("redisURL", "redis://localhost:6379/0", "Redis to connect to when a redis backend is used")
+	rootCmd.PersistentFlags().Int("wildcardCacheTTL", 600, "Seconds to remember what an item wildcard expanded to, 0 disables")
+	rootCmd.PersistentFlags().Int("wildcardCacheMaxEntries", 1000, "Most wildcard expansions remembered, the least recently used ones make room for new ones")
+	rootCmd.PersistentFlags().String("adminKey", "", "Bearer token for the /admin endpoints, they are disabled when empty")
+	rootCmd.PersistentFlags().Bool("includeExpired", false, "Count orders past their expiry in prices, requests can override it with ?includeExpired=")
+	rootCmd.PersistentFlags().Int("maxURLLength", 4096, "Longest URL accepted, longer ones get 414, 0 accepts any")
+	rootCmd.PersistentFlags().String("bodyLimit", "1M", "Largest request body accepted, like 512K or 1M, longer ones get 413")
+	rootCmd.PersistentFlags().Float64("requestLogSampleRate", 0, "Share of requests from 0 to 1 logged with their params, status and response size")
+	rootCmd.PersistentFlags().String("metricsPath", "", "Path Prometheus metrics are served at, like /metrics, disabled when empty")
+	rootCmd.PersistentFlags().String("iconRenderURL", "https://render.albiononline.com/v1/item", "Render service item icons are fetched from")
+	rootCmd.PersistentFlags().String("iconCacheDir", "", "Folder item icons are cached in, a temporary folder when empty")
+	rootCmd.PersistentFlags().Int("iconCacheMaxFiles", 5000, "Most icons kept in iconCacheDir, the least recently served ones are removed first, 0 keeps all")
+	rootCmd.PersistentFlags().Float64("refiningReturnRate", 15.2, "Percentage of materials given back when refining, when the request has no returnRate param")
+	rootCmd.PersistentFlags().String("itemWeightsFile", "", "Path to a JSON file mapping item ids to their weight in kg, enables /api/v1/stats/transport")
+	rootCmd.PersistentFlags().String("checkIndexes", "log", "Look for indexes the API needs at startup, one of off, log, create")
+	rootCmd.PersistentFlags().Int("maxChartPoints", 0, "Longest chart series returned, longer ones are downsampled with LTTB, 0 never downsamples")
+	rootCmd.PersistentFlags().Bool("jsonp", false, "Wrap JSON responses in the function of ?callback= for clients that can't use CORS")
+	rootCmd.PersistentFlags().String("cacheGzipRoutes", "/api/v1/stats/prices/:item,/api/v1/stats/charts/:item", "Comma separated routes whose cached responses are kept gzip-compressed and served so to clients accepting gzip")
+	rootCmd.PersistentFlags().String("clusterInvalidation", "none", "How admin cache purges and data changes reach the other replicas, one of none, redis")
+	rootCmd.PersistentFlags().String("clusterChannel", "albiondata-api:invalidate", "Redis pub/sub channel of cluster invalidations")
+	rootCmd.PersistentFlags().String("upstreamURL", "", "Instance asked for the prices missing from the database, like https://www.albion-online-data.com, empty disables")
+	rootCmd.PersistentFlags().Int("upstreamTimeout", 5, "Seconds to wait for upstreamURL")
+	rootCmd.PersistentFlags().Int("upstreamCacheTTL", 300, "Seconds to keep answers of upstreamURL")
+	rootCmd.PersistentFlags().String("adminClientCA", "", "PEM file of the CA admin client certificates have to be signed by, admin requests need one when set")
+	rootCmd.PersistentFlags().String("adminListen", "", "Second TLS listener verifying client certificates, the only place admin requests get through with adminClientCA")
+	rootCmd.PersistentFlags().String("adminTLSCert", "", "Certificate of adminListen")
+	rootCmd.PersistentFlags().String("adminTLSKey", "", "Private key of adminListen")
+	rootCmd.PersistentFlags().String("dbTLS", "disable", "TLS to the database (mysql, postgres), one of disable, require, verify-ca, verify-full")
+	rootCmd.PersistentFlags().String("dbTLSCA", "", "PEM file of the CA the database certificate is verified against")
+	rootCmd.PersistentFlags().String("dbTLSServerName", "", "Name the database certificate has to be issued to when it differs from the host (mysql only)")
+	rootCmd.PersistentFlags().Int("sparklineCacheTTL", 900, "Seconds to keep the sparkline of an item in memory, 0 disables it")
+	rootCmd.PersistentFlags().Int("sparklineCacheMaxEntries", 50000, "Most item sparklines kept in memory, the least recently used ones make room for new ones")
+	rootCmd.PersistentFlags().Int("staleAfter", 86400, "Seconds after which prices are marked stale, 0 never marks them")
+	rootCmd.PersistentFlags().Int("slowQueryThreshold", 500, "Milliseconds after which a query is kept in the slow query log, 0 disables it")
+	rootCmd.PersistentFlags().Int("slowQueryLogSize", 100, "Number of slow queries kept for /admin/slow-queries")
+	rootCmd.PersistentFlags().Bool("staticEmbedded", false, "Serve the frontend embedded at build time under staticFilePrefix")
+	rootCmd.PersistentFlags().String("staticFolderPath", "", "Path to folder where static files reside for web server, overrides staticEmbedded. https://echo.labstack.com/guide/static-files")
+	rootCmd.PersistentFlags().Bool("staticHardened", true, "Refuse dotfiles, symlinks out of staticFolderPath and extensions missing from staticExtensions, --DANGER-- when off")
+	rootCmd.PersistentFlags().String("staticExtensions", ".html,.css,.js,.json,.map,.txt,.png,.jpg,.jpeg,.gif,.svg,.ico,.webp,.woff,.woff2", "File extensions staticHardened serves")
+	rootCmd.PersistentFlags().String("staticFilePrefix", "", "Prefix for static files to be served as, like example.com/prefix/index.html. https://echo.labstack.com/guide/static-files")
 	viper.BindPFlag("listen", rootCmd.PersistentFlags().Lookup("listen"))
 	viper.BindPFlag("dbType", rootCmd.PersistentFlags().Lookup("dbType"))
 	viper.BindPFlag("dbURI", rootCmd.PersistentFlags().Lookup("dbURI"))
 	viper.BindPFlag("minUpdatedAt", rootCmd.PersistentFlags().Lookup("minUpdatedAt"))
+	viper.BindPFlag("defaultAge", rootCmd.PersistentFlags().Lookup("defaultAge"))
+	viper.BindPFlag("maxAge", rootCmd.PersistentFlags().Lookup("maxAge"))
 	viper.BindPFlag("useHttps", rootCmd.PersistentFlags().Lookup("useHttps"))
 	viper.BindPFlag("autoCertCacheDirectory", rootCmd.PersistentFlags().Lookup("autoCertCacheDirectory"))
+	viper.BindPFlag("itemsFile", rootCmd.PersistentFlags().Lookup("itemsFile"))
+	viper.BindPFlag("qualityChartsDays", rootCmd.PersistentFlags().Lookup("qualityChartsDays"))
+	viper.BindPFlag("cacheTTL", rootCmd.PersistentFlags().Lookup("cacheTTL"))
+	viper.BindPFlag("cacheMaxEntries", rootCmd.PersistentFlags().Lookup("cacheMaxEntries"))
+	viper.BindPFlag("longPollTimeout", rootCmd.PersistentFlags().Lookup("longPollTimeout"))
+	viper.BindPFlag("leaderElection", rootCmd.PersistentFlags().Lookup("leaderElection"))
+	viper.BindPFlag("trustedProxies", rootCmd.PersistentFlags().Lookup("trustedProxies"))
+	viper.BindPFlag("rateLimit", rootCmd.PersistentFlags().Lookup("rateLimit"))
+	viper.BindPFlag("rateLimitWindow", rootCmd.PersistentFlags().Lookup("rateLimitWindow"))
+	viper.BindPFlag("usageWindow", rootCmd.PersistentFlags().Lookup("usageWindow"))
+	viper.BindPFlag("rateLimitBackend", rootCmd.PersistentFlags().Lookup("rateLimitBackend"))
+	viper.BindPFlag("redisURL", rootCmd.PersistentFlags().Lookup("redisURL"))
+	viper.BindPFlag("wildcardCacheTTL", rootCmd.PersistentFlags().Lookup("wildcardCacheTTL"))
+	viper.BindPFlag("wildcardCacheMaxEntries", rootCmd.PersistentFlags().Lookup("wildcardCacheMaxEntries"))
+	viper.BindPFlag("adminKey", rootCmd.PersistentFlags().Lookup("adminKey"))
+	viper.BindPFlag("includeExpired", rootCmd.PersistentFlags().Lookup("includeExpired"))
+	viper.BindPFlag("maxURLLength", rootCmd.PersistentFlags().Lookup("maxURLLength"))
+	viper.BindPFlag("bodyLimit", rootCmd.PersistentFlags().Lookup("bodyLimit"))
+	viper.BindPFlag("requestLogSampleRate", rootCmd.PersistentFlags().Lookup("requestLogSampleRate"))
+	viper.BindPFlag("metricsPath", rootCmd.PersistentFlags().Lookup("metricsPath"))
+	viper.BindPFlag("iconRenderURL", rootCmd.PersistentFlags().Lookup("iconRenderURL"))
+	viper.BindPFlag("iconCacheDir", rootCmd.PersistentFlags().Lookup("iconCacheDir"))
+	viper.BindPFlag("iconCacheMaxFiles", rootCmd.PersistentFlags().Lookup("iconCacheMaxFiles"))
+	viper.BindPFlag("refiningReturnRate", rootCmd.PersistentFlags().Lookup("refiningReturnRate"))
+	viper.BindPFlag("itemWeightsFile", rootCmd.PersistentFlags().Lookup("itemWeightsFile"))
+	viper.BindPFlag("checkIndexes", rootCmd.PersistentFlags().Lookup("checkIndexes"))
+	viper.BindPFlag("maxChartPoints", rootCmd.PersistentFlags().Lookup("maxChartPoints"))
+	viper.BindPFlag("jsonp", rootCmd.PersistentFlags().Lookup("jsonp"))
+	viper.BindPFlag("cacheGzipRoutes", rootCmd.PersistentFlags().Lookup("cacheGzipRoutes"))
+	viper.BindPFlag("clusterInvalidation", rootCmd.PersistentFlags().Lookup("clusterInvalidation"))
+	viper.BindPFlag("clusterChannel", rootCmd.PersistentFlags().Lookup("clusterChannel"))
+	viper.BindPFlag("upstreamURL", rootCmd.PersistentFlags().Lookup("upstreamURL"))
+	viper.BindPFlag("upstreamTimeout", rootCmd.PersistentFlags().Lookup("upstreamTimeout"))
+	viper.BindPFlag("upstreamCacheTTL", rootCmd.PersistentFlags().Lookup("upstreamCacheTTL"))
+	viper.BindPFlag("adminClientCA", rootCmd.PersistentFlags().Lookup("adminClientCA"))
+	viper.BindPFlag("adminListen", rootCmd.PersistentFlags().Lookup("adminListen"))
+	viper.BindPFlag("adminTLSCert", rootCmd.PersistentFlags().Lookup("adminTLSCert"))
+	viper.BindPFlag("adminTLSKey", rootCmd.PersistentFlags().Lookup("adminTLSKey"))
+	viper.BindPFlag("dbTLS", rootCmd.PersistentFlags().Lookup("dbTLS"))
+	viper.BindPFlag("dbTLSCA", rootCmd.PersistentFlags().Lookup("dbTLSCA"))
+	viper.BindPFlag("dbTLSServerName", rootCmd.PersistentFlags().Lookup("dbTLSServerName"))
+	viper.BindPFlag("sparklineCacheTTL", rootCmd.PersistentFlags().Lookup("sparklineCacheTTL"))
+	viper.BindPFlag("sparklineCacheMaxEntries", rootCmd.PersistentFlags().Lookup("sparklineCacheMaxEntries"))
+	viper.BindPFlag("staleAfter", rootCmd.PersistentFlags().Lookup("staleAfter"))
+	viper.BindPFlag("slowQueryThreshold", rootCmd.PersistentFlags().Lookup("slowQueryThreshold"))
+	viper.BindPFlag("slowQueryLogSize", rootCmd.PersistentFlags().Lookup("slowQueryLogSize"))
+	viper.BindPFlag("staticEmbedded", rootCmd.PersistentFlags().Lookup("staticEmbedded"))
 	viper.BindPFlag("staticFolderPath", rootCmd.PersistentFlags().Lookup("staticFolderPath"))
+	viper.BindPFlag("staticHardened", rootCmd.PersistentFlags().Lookup("staticHardened"))
+	viper.BindPFlag("staticExtensions", rootCmd.PersistentFlags().Lookup("staticExtensions"))
 	viper.BindPFlag("staticFilePrefix", rootCmd.PersistentFlags().Lookup("staticFilePrefix"))
 }
 
@@ -95,15 +197,76 @@ func initConfig() {
 	}
 
 	viper.SetEnvPrefix("ADA")
+	// Nested keys like endpoints.view are read from ADA_ENDPOINTS_VIEW
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 }
 
 func apiHandleStatsPricesItemJson(c echo.Context) error {
-	return c.JSON(http.StatusOK, getStatsPricesItem(c))
+	fields, err := parseFields(c, lib.APIStatsPricesItem{})
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	sortBy, err := parseSort(c, lib.APIStatsPricesItem{})
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	results, err := getStatsPricesItem(c, "prices", fields, 0)
+	if err != nil {
+		return paramError(c, err)
+	}
+	sortResults(results, sortBy)
+	if fields == nil {
+		return renderJSON(c, http.StatusOK, results)
+	}
+	return renderJSON(c, http.StatusOK, sparseFields(results, fields))
 }
 
 func apiHandleStatsPricesView(c echo.Context) error {
-	results := getStatsPricesItem(c)
+	loc, err := parseTZ(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	sortBy, err := parseSort(c, lib.APIStatsPricesItem{})
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	columns, err := parseViewColumns(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	quality, err := parseQuality(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	// A quality column breaks every row out per quality
+	qualities := []int{quality}
+	for _, col := range columns {
+		if col == "quality" && quality == 0 {
+			qualities = []int{}
+			for q := minQuality; q <= maxQuality; q++ {
+				qualities = append(qualities, q)
+			}
+		}
+	}
+
+	rows := []viewRow{}
+	for _, q := range qualities {
+		results, err := getStatsPricesItem(c, "view", nil, q)
+		if err != nil {
+			return paramError(c, err)
+		}
+		sortResults(results, sortBy)
+		for _, r := range results {
+			r.City = localizeCity(cityLang(c), r.City)
+			rows = append(rows, viewRow{APIStatsPricesItem: r, Quality: q})
+		}
+	}
 
 	html :=
 		`<html>
@@ -113,28 +276,29 @@ func apiHandleStatsPricesView(c echo.Context) error {
 				border: 1px solid black;
 				border-collapse: collapse;
 			}
+			td.number {
+				text-align: right;
+			}
 		</style>
 	</head>
 	<body>
 		<table style='width:100%'>
 			<tr>
-				<th>item_id</th>
-				<th>city</th>
-				<th>sell_price_min</th>
-				<th>sell_price_min_date</th>
-				<th>sell_price_max</th>
-				<th>sell_price_max_date</th>
-				<th>buy_price_min</th>
-				<th>buy_price_min_date</th>
-				<th>buy_price_max</th>
-				<th>buy_price_max_date</th>
-			</tr>`
-	for _, result := range results {
-		html += "<tr>"
-		v := reflect.ValueOf(result)
+`
+	for _, col := range columns {
+		html += fmt.Sprintf("\t\t\t\t<th>%s</th>\n", col)
+	}
+	html += "\t\t\t</tr>"
 
-		for i := 0; i < v.NumField(); i++ {
-			html += fmt.Sprintf("<td>%v</td>", v.Field(i).Interface())
+	for _, row := range rows {
+		html += "<tr>"
+		for _, col := range columns {
+			cell := viewCell(row, col, loc)
+			if strings.Contains(col, "price") && !strings.HasSuffix(col, "_date") {
+				html += fmt.Sprintf("<td class='number'>%s</td>", cell)
+			} else {
+				html += fmt.Sprintf("<td>%s</td>", cell)
+			}
 		}
 		html += "</tr>"
 	}
@@ -147,59 +311,95 @@ func apiHandleStatsPricesView(c echo.Context) error {
 	return c.HTML(http.StatusOK, html)
 }
 
-func getStatsPricesItem(c echo.Context) []lib.APIStatsPricesItem {
-	result := []lib.APIStatsPricesItem{}
+// pricesScope is what the prices of a request are made of, the orders of its
+// age window as they were at until.
+type pricesScope struct {
+	orders *gorm.DB
+	key    string // identifies orders for the wildcard cache
+	age    int
+	until  time.Time
+}
 
-	minimumAge := 172800
-	if viper.IsSet("minUpdatedAt") {
-		minimumAge = viper.GetInt("minUpdatedAt")
+// pricesOrders builds the pricesScope of the request from ?age=, ?at= and
+// ?includeExpired=.
+func pricesOrders(c echo.Context, endpoint string, quality int) (pricesScope, error) {
+	age, err := ageWindow(c, endpoint)
+	if err != nil {
+		return pricesScope{}, err
 	}
-	// age query param
-	ageInt, err := strconv.Atoi(c.QueryParam("age"))
-	if err == nil && ageInt < minimumAge {
-		minimumAge = ageInt
+
+	// at query param, look at the prices as they were at that time
+	until := time.Now()
+	if len(c.QueryParam("at")) > 0 {
+		until, err = time.Parse(time.RFC3339, c.QueryParam("at"))
+		if err != nil || until.After(time.Now()) {
+			return pricesScope{}, fmt.Errorf("at must be a RFC3339 timestamp in the past")
+		}
 	}
-	ageTime := time.Now().Add(-time.Duration(minimumAge) * time.Second)
+	ageTime := until.Add(-time.Duration(age) * time.Second)
 
-	// location query param
-	locs := adslib.Locations()
-	if len(c.QueryParam("locations")) > 0 {
-		queryLocs := strings.Split(c.QueryParam("locations"), ",")
-
-		locs = []adslib.Location{}
-		for _, queryLoc := range queryLocs {
-			for _, l := range adslib.Locations() {
-				if strings.Contains(l.String(), queryLoc) {
-					locs = append(locs, l)
-					break
-				}
-			}
+	// includeExpired query param, orders past their expiry are left out by default
+	includeExpired := viper.GetBool("includeExpired")
+	if len(c.QueryParam("includeExpired")) > 0 {
+		includeExpired, err = strconv.ParseBool(c.QueryParam("includeExpired"))
+		if err != nil {
+			return pricesScope{}, fmt.Errorf("includeExpired must be true or false")
 		}
 	}
+	table := ordersTable(c)
+	orders := plausibleOrders(dbFrom(c).Table(table).Where("updated_at >= ? and updated_at <= ?", ageTime, until))
+	if !includeExpired {
+		orders = orders.Where("expires > ?", until)
+	}
+	if quality > 0 {
+		orders = orders.Where("quality_level = ?", quality)
+	}
+	return pricesScope{
+		orders: orders,
+		key:    fmt.Sprintf("%s|%d|%t|%d", table, age, includeExpired, quality),
+		age:    age,
+		until:  until,
+	}, nil
+}
 
-	// item query param
-	queryItemIDs := strings.Split(c.Param("item"), ",")
-	itemIDs := []string{}
+// queryStatsPricesItem only queries the aggregates contained in fields,
+// pass nil to get all of them. quality 0 looks at all qualities together.
+// Handlers call it through getStatsPricesItem, which caches the results.
+func queryStatsPricesItem(c echo.Context, endpoint string, fields fieldSet, quality int) ([]lib.APIStatsPricesItem, error) {
+	result := []lib.APIStatsPricesItem{}
 
-	for _, qID := range queryItemIDs {
-		if qID == "*" {
-			continue
-		}
-		if strings.Contains(qID, "*") {
-			sqlID := strings.Replace(qID, "*", "%", -1)
+	// Without any aggregate requested we still need one query to know if
+	// there is data at all
+	if !fields.Has("sell_price_min", "sell_price_min_date", "sell_price_max", "sell_price_max_date",
+		"buy_price_min", "buy_price_min_date", "buy_price_max", "buy_price_max_date") {
+		fields = fields.With("sell_price_min")
+	}
 
-			foundIDs := []string{}
-			if err := db.Table(adslib.NewModelMarketOrder().TableName()).Select("item_id").Where("item_id LIKE ? and updated_at >= ?", sqlID, ageTime).Group("item_id").Pluck("item_id", &foundIDs).Error; err != nil {
-				fmt.Printf("%v\n", err)
-				continue
-			}
+	scope, err := pricesOrders(c, endpoint, quality)
+	if err != nil {
+		return nil, err
+	}
+	orders, age, until := scope.orders, scope.age, scope.until
 
-			itemIDs = append(itemIDs, foundIDs...)
+	// location query param
+	locs := queryLocations(c)
 
-		} else {
-			itemIDs = append(itemIDs, qID)
-		}
+	// item query param
+	itemIDs, err := expandItemIDs(c, c.Param("item"), orders, scope.key)
+	if err != nil {
+		return nil, err
 	}
+	// ?sort= ranks the items in SQL, the handlers' sortResults then orders
+	// the rows themselves
+	sortBy, _ := parseSort(c, lib.APIStatsPricesItem{})
+	if itemIDs, err = rankItemIDs(orders, itemIDs, sortBy); err != nil {
+		return nil, err
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+	meta.AgeApplied = age
 
 	for _, itemID := range itemIDs {
 		for _, l := range locs {
@@ -209,125 +409,390 @@ func getStatsPricesItem(c echo.Context) []lib.APIStatsPricesItem {
 			}
 
 			found := false
+			var m adslib.ModelMarketOrder
 
 			// Find lowest offer price
-			m := adslib.NewModelMarketOrder()
-			if err := db.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ? and updated_at >= ?", l, itemID, "offer", ageTime).Order("updated_at_no_seconds desc, price asc").First(&m).Error; err == nil {
-				found = true
-				lres.SellPriceMin = m.Price
-				lres.SellPriceMinDate = m.UpdatedAt
+			if fields.Has("sell_price_min", "sell_price_min_date") {
+				m = adslib.NewModelMarketOrder()
+				if err := orders.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ?", l, itemID, "offer").Order("updated_at_no_seconds desc, price asc").First(&m).Error; err == nil {
+					found = true
+					lres.SellPriceMin = int64(m.Price)
+					lres.SellPriceMinDate = lib.Timestamp(m.UpdatedAt)
+				}
 			}
 
 			// Find highest offer price
-			m = adslib.NewModelMarketOrder()
-			if err := db.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ? and updated_at >= ?", l, itemID, "offer", ageTime).Order("updated_at_no_seconds desc, price desc").First(&m).Error; err == nil {
-				found = true
-				lres.SellPriceMax = m.Price
-				lres.SellPriceMaxDate = m.UpdatedAt
+			if fields.Has("sell_price_max", "sell_price_max_date") {
+				m = adslib.NewModelMarketOrder()
+				if err := orders.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ?", l, itemID, "offer").Order("updated_at_no_seconds desc, price desc").First(&m).Error; err == nil {
+					found = true
+					lres.SellPriceMax = int64(m.Price)
+					lres.SellPriceMaxDate = lib.Timestamp(m.UpdatedAt)
+				}
 			}
 
 			// Find lowest request price
-			m = adslib.NewModelMarketOrder()
-			if err := db.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ? and updated_at >= ?", l, itemID, "request", ageTime).Order("updated_at_no_seconds desc, price asc").First(&m).Error; err == nil {
-				found = true
-				lres.BuyPriceMin = m.Price
-				lres.BuyPriceMinDate = m.UpdatedAt
+			if fields.Has("buy_price_min", "buy_price_min_date") {
+				m = adslib.NewModelMarketOrder()
+				if err := orders.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ?", l, itemID, "request").Order("updated_at_no_seconds desc, price asc").First(&m).Error; err == nil {
+					found = true
+					lres.BuyPriceMin = int64(m.Price)
+					lres.BuyPriceMinDate = lib.Timestamp(m.UpdatedAt)
+				}
 			}
 
 			// Find highest request price
-			m = adslib.NewModelMarketOrder()
-			if err := db.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ? and updated_at >= ?", l, itemID, "request", ageTime).Order("updated_at_no_seconds desc, price desc").First(&m).Error; err == nil {
-				found = true
-				lres.BuyPriceMax = m.Price
-				lres.BuyPriceMaxDate = m.UpdatedAt
+			if fields.Has("buy_price_max", "buy_price_max_date") {
+				m = adslib.NewModelMarketOrder()
+				if err := orders.Select("*, strftime('%Y-%m-%d %H:%i', `updated_at`) as updated_at_no_seconds").Where("location = ? and item_id = ? and auction_type = ?", l, itemID, "request").Order("updated_at_no_seconds desc, price desc").First(&m).Error; err == nil {
+					found = true
+					lres.BuyPriceMax = int64(m.Price)
+					lres.BuyPriceMaxDate = lib.Timestamp(m.UpdatedAt)
+				}
 			}
 
 			if found {
+				newest := time.Time{}
+				for _, d := range []lib.Timestamp{lres.SellPriceMinDate, lres.SellPriceMaxDate, lres.BuyPriceMinDate, lres.BuyPriceMaxDate} {
+					if time.Time(d).After(newest) {
+						newest = time.Time(d)
+					}
+				}
+				lres.DataAgeSeconds = int64(until.Sub(newest).Seconds())
+				if staleAfter := viper.GetInt("staleAfter"); staleAfter > 0 && lres.DataAgeSeconds > int64(staleAfter) {
+					lres.Stale = true
+				}
+				if fields.Has("order_count", "confidence") {
+					orders.Model(&adslib.ModelMarketOrder{}).Where("location = ? and item_id = ?", l, itemID).Count(&lres.OrderCount)
+					lres.Confidence = priceConfidence(lres, age)
+				}
 				result = append(result, lres)
 			}
 		}
 	}
-	return result
+	if useUpstream(c) {
+		result = fillFromUpstream(c, result, itemIDs, locs, quality)
+	}
+	return result, nil
 }
 
 func apiHandleStatsChartsItem(c echo.Context) error {
-	result := []lib.APIStatsChartsResponse{}
-
 	// location query param
-	locs := adslib.Locations()
-	if len(c.QueryParam("locations")) > 0 {
-		queryLocs := strings.Split(c.QueryParam("locations"), ",")
-
-		locs = []adslib.Location{}
-		for _, queryLoc := range queryLocs {
-			for _, l := range adslib.Locations() {
-				if strings.Contains(l.String(), queryLoc) {
-					locs = append(locs, l)
-					break
-				}
-			}
+	locs := queryLocations(c)
+
+	// item query param, a single item keeps the original response shape
+	raw := c.Param("item")
+	conn, statsConn := dbFrom(c), statsDBFrom(c)
+	itemIDs, err := expandItemIDs(c, raw, statsConn.Model(&adslib.ModelMarketStats{}), "stats")
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	opts, err := parseChartOptions(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	if !strings.ContainsAny(raw, ",*") && len(itemIDs) == 1 {
+		return renderJSON(c, http.StatusOK, getStatsCharts(conn, statsConn, itemIDs[0], locs, opts))
+	}
+
+	result := []lib.APIStatsChartsItemResponse{}
+	for _, itemID := range itemIDs {
+		result = append(result, lib.APIStatsChartsItemResponse{
+			ItemID: itemID,
+			Data:   getStatsCharts(conn, statsConn, itemID, locs, opts),
+		})
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// chartOptions are the params shaping chart series.
+type chartOptions struct {
+	quality      int
+	indicators   []indicator
+	maxPoints    int
+	downsample   string
+	splitQuality bool
+	// from and to bound the history, to ?patch=; zero values leave it open
+	from, to time.Time
+}
+
+// parseChartOptions reads the chart params. ?maxPoints= can only lower the
+// maxChartPoints config, ?downsample= picks lttb (the default) or avg.
+func parseChartOptions(c echo.Context) (chartOptions, error) {
+	opts := chartOptions{maxPoints: viper.GetInt("maxChartPoints"), downsample: downsampleLTTB}
+	var err error
+	if opts.indicators, err = parseIndicators(c); err != nil {
+		return opts, err
+	}
+	if opts.quality, err = parseQuality(c); err != nil {
+		return opts, err
+	}
+	if len(c.QueryParam("maxPoints")) > 0 {
+		maxPoints, err := strconv.Atoi(c.QueryParam("maxPoints"))
+		if err != nil || maxPoints < 3 {
+			return opts, fmt.Errorf("maxPoints must be a number of at least 3")
+		}
+		if opts.maxPoints == 0 || maxPoints < opts.maxPoints {
+			opts.maxPoints = maxPoints
 		}
 	}
+	if len(c.QueryParam("splitQuality")) > 0 {
+		if opts.splitQuality, err = strconv.ParseBool(c.QueryParam("splitQuality")); err != nil {
+			return opts, fmt.Errorf("splitQuality must be true or false")
+		}
+		if opts.splitQuality && opts.quality > 0 {
+			return opts, fmt.Errorf("quality and splitQuality can't be used together")
+		}
+	}
+	if opts.from, opts.to, err = parsePatch(c); err != nil {
+		return opts, err
+	}
+	switch c.QueryParam("downsample") {
+	case "", downsampleLTTB:
+	case downsampleAvg:
+		opts.downsample = downsampleAvg
+	default:
+		return opts, fmt.Errorf("downsample must be one of %s, %s", downsampleLTTB, downsampleAvg)
+	}
+	return opts, nil
+}
 
-	item := c.Param("item")
+// getStatsCharts returns the series of every location with data, quality 0
+// reads market_stats, a quality between 1 and 5 is built from the orders.
+// splitQuality returns a series per quality of each location instead.
+// Series longer than maxPoints are downsampled.
+func getStatsCharts(conn, statsConn *gorm.DB, item string, locs []adslib.Location, opts chartOptions) []lib.APIStatsChartsResponse {
+	result := []lib.APIStatsChartsResponse{}
 
-	dbResults := []adslib.ModelMarketStats{}
+	qualities := []int{opts.quality}
+	if opts.splitQuality {
+		qualities = []int{}
+		for q := minQuality; q <= maxQuality; q++ {
+			qualities = append(qualities, q)
+		}
+	}
 
 	for _, l := range locs {
-		lResult := lib.APIStatsChartsLocationResponse{}
+		for _, quality := range qualities {
+			var lResult lib.APIStatsChartsLocationResponse
+			var err error
+			if quality > 0 {
+				lResult, err = qualityChartSeries(conn, item, l, quality, opts.from, opts.to)
+			} else {
+				lResult, err = statsChartSeries(statsConn, item, l, opts.from, opts.to)
+			}
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
 
-		db.Where("item_id = ? AND location = ?", item, l).Find(&dbResults)
+			if len(lResult.Timestamps) > 0 {
+				// Indicator windows count buckets of the full series
+				addIndicators(&lResult, opts.indicators)
+				downsample(&lResult, opts.maxPoints, opts.downsample)
 
-		if len(dbResults) > 0 {
-			for _, dbResult := range dbResults {
-				lResult.Timestamps = append(lResult.Timestamps, dbResult.Timestamp.Unix()*1000) // *1000 For charts.js which wants milliseconds
-				lResult.PricesMin = append(lResult.PricesMin, dbResult.PriceMin)
-				lResult.PricesMax = append(lResult.PricesMax, dbResult.PriceMax)
-				lResult.PricesAvg = append(lResult.PricesAvg, dbResult.PriceAvg)
+				result = append(result, lib.APIStatsChartsResponse{
+					Location: l.String(),
+					Quality:  quality,
+					Data:     lResult,
+				})
 			}
+		}
+	}
+
+	return result
+}
+
+// statsChartSeries reads the market_stats history of one location between
+// from and to row by row into slices sized up front, long histories aren't
+// held twice. Days compacted into market_stats_daily are read from there,
+// a point per day.
+func statsChartSeries(statsConn *gorm.DB, item string, l adslib.Location, from, to time.Time) (lib.APIStatsChartsLocationResponse, error) {
+	res := lib.APIStatsChartsLocationResponse{}
+	history := statsConn.Model(&adslib.ModelMarketStats{}).Where("item_id = ? AND location = ?", item, l)
+	if !from.IsZero() {
+		history = history.Where("timestamp >= ?", from)
+	}
+	if !to.IsZero() {
+		history = history.Where("timestamp < ?", to)
+	}
+	parts := []*gorm.DB{history}
+	if boundary := rollupBoundary(statsConn); !boundary.IsZero() && (from.IsZero() || from.Before(boundary)) {
+		daily := statsConn.Model(&statsRollup{}).Where("item_id = ? AND location = ? AND timestamp < ?", item, l, boundary)
+		if !from.IsZero() {
+			daily = daily.Where("timestamp >= ?", from)
+		}
+		if !to.IsZero() {
+			daily = daily.Where("timestamp < ?", to)
+		}
+		parts = []*gorm.DB{daily, history.Where("timestamp >= ?", boundary)}
+	}
 
-			result = append(result, lib.APIStatsChartsResponse{
-				Location: l.String(),
-				Data:     lResult,
-			})
+	count := 0
+	for _, part := range parts {
+		n := 0
+		if err := part.Count(&n).Error; err != nil {
+			return res, err
 		}
+		count += n
 	}
+	res.Timestamps = make([]int64, 0, count)
+	res.PricesMin = make([]int64, 0, count)
+	res.PricesMax = make([]int64, 0, count)
+	res.PricesAvg = make([]float64, 0, count)
 
-	return c.JSON(http.StatusOK, result)
+	for _, part := range parts {
+		if err := appendStatsRows(statsConn, &res, part); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// appendStatsRows adds the stats rows of q to the series in timestamp order.
+func appendStatsRows(statsConn *gorm.DB, res *lib.APIStatsChartsLocationResponse, q *gorm.DB) error {
+	rows, err := q.Select("timestamp, price_min, price_max, price_avg").Order("timestamp asc").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s adslib.ModelMarketStats
+		if err := statsConn.ScanRows(rows, &s); err != nil {
+			return err
+		}
+		res.Timestamps = append(res.Timestamps, s.Timestamp.Unix()*1000) // *1000 For charts.js which wants milliseconds
+		res.PricesMin = append(res.PricesMin, int64(s.PriceMin))
+		res.PricesMax = append(res.PricesMax, int64(s.PriceMax))
+		res.PricesAvg = append(res.PricesAvg, s.PriceAvg)
+	}
+	return rows.Err()
 }
 
 func apiHandleStatsGold(c echo.Context) error {
 	result := lib.APIStatesChartsResponse{}
 
 	dbResults := []adslib.ModelGoldprices{}
-	db.Find(&dbResults)
+	goldDBFrom(c).Find(&dbResults)
 
 	for _, dbResult := range dbResults {
 		result.Timestamps = append(result.Timestamps, dbResult.Timestamp.Unix()*1000)
-		result.Prices = append(result.Prices, dbResult.Price)
+		result.Prices = append(result.Prices, int64(dbResult.Price))
 	}
 
-	return c.JSON(http.StatusOK, result)
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// openDB connects db to the database of dbType and dbURI, with the password
+// of the secrets provider in place of {password}.
+func openDB() error {
+	fmt.Printf("Connecting to database: %s\n", viper.GetString("dbType"))
+	if err := loadSecrets(); err != nil {
+		return err
+	}
+	dsn, err := primaryDSN()
+	if err != nil {
+		return err
+	}
+	db, err = gorm.Open(viper.GetString("dbType"), dsn)
+	return err
+}
+
+// primaryDSN is dbURI with the password and TLS options filled in.
+func primaryDSN() (string, error) {
+	return dsnWithTLS(viper.GetString("dbType"), secrets.withPassword(viper.GetString("dbType"), viper.GetString("dbURI")), globalDBTLS(), primaryDatabase)
 }
 
 func doCmd(cmd *cobra.Command, args []string) {
 	//******************************
 	// START DB
-	fmt.Printf("Connecting to database: %s\n", viper.GetString("dbType"))
-	var err error
-	db, err = gorm.Open(viper.GetString("dbType"), viper.GetString("dbURI"))
-	if err != nil {
+	if err := openDB(); err != nil {
 		fmt.Printf("%v\n", err)
 		return
 	}
 
 	// Debug
 	db.LogMode(true)
+	registerQueryHooks(db)
+
+	// The pools may be swapped by reconnectDatabases
+	defer func() { db.Close() }()
+
+	if err := openDatabases(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer closeDatabases()
+	if err := openClassPools(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer func() {
+		if expensiveDB != nil {
+			expensiveDB.Close()
+		}
+	}()
+	if err := loadTenants(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
 
-	defer db.Close()
+	switch viper.GetString("checkIndexes") {
+	case "log", "create":
+		if err := checkIndexes(db, viper.GetString("checkIndexes") == "create"); err != nil {
+			fmt.Printf("Can't check indexes: %v\n", err)
+		}
+	}
 	// END DB
 	//******************************
 
+	if err := loadPriceBounds(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if err := blacklist.Load(); err != nil {
+		fmt.Printf("Can't load the data blacklist: %v\n", err)
+	}
+	if err := aliases.Load(); err != nil {
+		fmt.Printf("Can't load item aliases: %v\n", err)
+	}
+	if err := features.Load(); err != nil {
+		fmt.Printf("Can't load feature flags: %v\n", err)
+	}
+	if err := migrateAudit(); err != nil {
+		fmt.Printf("Can't create the audit table: %v\n", err)
+	}
+	if err := migrateStatus(); err != nil {
+		fmt.Printf("Can't create the status table: %v\n", err)
+	}
+	if err := migrateRollups(); err != nil {
+		fmt.Printf("Can't create the stats rollup table: %v\n", err)
+	}
+	loadExecHook()
+	if err := webhooks.Load(); err != nil {
+		fmt.Printf("Can't load webhooks: %v\n", err)
+	}
+	startKafka()
+	defer stopKafka()
+
+	// Item metadata
+	if viper.GetString("itemsFile") != "" {
+		if err := items.Load(viper.GetString("itemsFile")); err != nil {
+			fmt.Printf("Can't load items: %v\n", err)
+		}
+	}
+	if viper.GetString("itemWeightsFile") != "" {
+		if err := weights.Load(viper.GetString("itemWeightsFile")); err != nil {
+			fmt.Printf("Can't load item weights: %v\n", err)
+		}
+	}
+
 	//******************************
 	// START ECHO
 	e := echo.New()
@@ -341,6 +806,23 @@ func doCmd(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	// Resolve client IPs behind proxies, without trusted ones the peer
+	// address is the client
+	trusted, err := parseTrustedProxies(viper.GetString("trustedProxies"))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	e.Pre(trustProxies(trusted))
+
+	// Reject oversized requests early
+	e.Pre(limitURLLength)
+	e.Pre(routeTenant)
+	e.Pre(normalizeRequest)
+	if viper.GetString("bodyLimit") != "" {
+		e.Pre(bodyLimitError, middleware.BodyLimit(viper.GetString("bodyLimit")))
+	}
+
 	// Recover from panics
 	e.Use(middleware.Recover())
 
@@ -348,20 +830,194 @@ func doCmd(cmd *cobra.Command, args []string) {
 	e.Use(middleware.Logger())
 
 	//Allow CORS
-	e.Use(middleware.CORS())
+	e.Use(middleware.CORSWithConfig(corsConfig()))
 
-	if viper.GetString("staticFilePrefix") != "" && viper.GetString("staticFolderPath") != "" {
-		e.Static(viper.GetString("staticFilePrefix"), viper.GetString("staticFolderPath"))
-	} else {
+	// API keys and rate limiting
+	if err := loadAPIKeys(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	e.Use(apiKeyAuth)
+	e.Use(requestLog)
+	e.Use(trackUsage)
+	e.Use(queryEvents)
+	e.Use(debugHeaders)
+	e.Use(explainQueries)
+	e.Use(selectDatabase)
+	if err := loadPatches(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if err := loadDeprecations(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	e.Use(deprecationHeaders)
+	e.Use(detectAbuse)
+	if rateLimitConfigured() {
+		var err error
+		if apiLimiter, err = newRateLimiter(); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		e.Use(rateLimit(apiLimiter))
+	}
+	e.Use(admitRequest)
+	e.Use(limitClassConcurrency)
+
+	if !serveStatic(e) {
 		e.GET("/", func(c echo.Context) error {
 			return c.Redirect(http.StatusTemporaryRedirect, "https://www.albion-online-data.com")
 		})
 	}
+	serveWellKnown(e)
 
-	e.GET("/api/v1/stats/prices/:item", apiHandleStatsPricesItemJson)
-	e.GET("/api/v1/stats/charts/:item", apiHandleStatsChartsItem)
-	e.GET("/api/v1/stats/view/:item", apiHandleStatsPricesView)
-	e.GET("/api/v1/stats/gold", apiHandleStatsGold)
+	if endpointEnabled("prices") {
+		e.GET("/api/v1/stats/prices/:item", apiHandleStatsPricesItemJson, cacheResponse)
+		e.POST(bulkPricesPath, apiHandleStatsPricesBulk)
+	}
+	if endpointEnabled("charts") {
+		e.GET("/api/v1/stats/charts/:item", apiHandleStatsChartsItem, cacheResponse)
+	}
+	if endpointEnabled("view") {
+		e.GET("/api/v1/stats/view/:item", apiHandleStatsPricesView, cacheResponse)
+		if endpointEnabled("charts") {
+			e.GET("/view/charts/:item", viewHandleCharts)
+			e.GET(assetsPath+"/:file", viewHandleAssets)
+		}
+	}
+	if endpointEnabled("gold") {
+		e.GET("/api/v1/stats/gold", apiHandleStatsGold, cacheResponse)
+		e.GET("/api/v1/stats/gold/summary", apiHandleStatsGoldSummary, cacheResponse)
+	}
+	if endpointEnabled("changes") {
+		e.GET("/api/v1/changes", apiHandleChanges)
+	}
+	if endpointEnabled("spread") {
+		e.GET("/api/v1/stats/spread/:item", apiHandleStatsSpread, cacheResponse)
+	}
+	if endpointEnabled("reports") {
+		e.GET("/api/v1/reports/:period", apiHandleReport, cacheResponse)
+		e.GET("/feeds/alerts.atom", apiHandleAlertsFeed, cacheResponse)
+	}
+	if endpointEnabled("matrix") {
+		e.GET("/api/v1/stats/matrix/:item", apiHandleStatsMatrix, matrixDownload, cacheResponse)
+	}
+	if endpointEnabled("sparkline") {
+		e.GET("/api/v1/stats/sparkline", apiHandleStatsSparkline, cacheResponse)
+	}
+	if endpointEnabled("priceChanges") {
+		e.GET("/api/v1/stats/changes/:item", apiHandleStatsPriceChanges, cacheResponse)
+	}
+	if endpointEnabled("metrics") {
+		e.GET("/api/v1/stats/metrics/:item", apiHandleStatsMetrics, cacheResponse)
+	}
+	if endpointEnabled("correlate") {
+		e.GET("/api/v1/stats/correlate", apiHandleStatsCorrelate, cacheResponse)
+	}
+	if endpointEnabled("calculators") {
+		e.GET("/api/v1/calc/refining/:item", apiHandleCalcRefining, cacheResponse)
+	}
+	if endpointEnabled("transport") {
+		e.GET("/api/v1/stats/transport", apiHandleStatsTransport, cacheResponse)
+	}
+	if endpointEnabled("localization") {
+		e.GET("/api/v1/meta/localization", apiHandleLocalization, cacheResponse)
+	}
+	if endpointEnabled("v2") {
+		v2 := e.Group("/api/v2", requireFeature("v2Responses"))
+		if endpointEnabled("prices") {
+			v2.GET("/stats/prices/:item", apiHandleV2StatsPrices, cacheResponse)
+		}
+		if endpointEnabled("charts") {
+			v2.GET("/stats/charts/:item", apiHandleV2StatsCharts, cacheResponse)
+		}
+	}
+	if endpointEnabled("grafana") {
+		grafana := e.Group("/api/grafana")
+		grafana.GET("", grafanaHandleTest)
+		grafana.GET("/", grafanaHandleTest)
+		grafana.POST("/search", grafanaHandleSearch)
+		grafana.POST("/query", grafanaHandleQuery)
+		grafana.POST("/annotations", grafanaHandleAnnotations)
+	}
+	if endpointEnabled("icons") {
+		e.GET("/api/v1/items/:id/icon", apiHandleItemIcon)
+	}
+	if endpointEnabled("market") {
+		e.GET("/api/v1/market/:item", apiHandleMarketItem, cacheResponse)
+	}
+	if endpointEnabled("contributions") {
+		e.GET("/api/v1/stats/contributions", apiHandleContributions, cacheResponse)
+	}
+	e.GET("/api/v1/schema/:name", apiHandleSchema)
+	e.GET("/api/v1/meta/patches", apiHandlePatches)
+	e.GET("/api/v1/status", apiHandleStatus, cacheResponse)
+	e.GET(usagePath, apiHandleUsage)
+	if endpointEnabled("export") {
+		e.GET("/api/v1/export/sqlite", apiHandleExportSQLite, requireAPIKey, requireExports)
+		e.GET("/api/v1/export/parquet", apiHandleExportParquet, requireAPIKey, requireExports)
+		e.GET("/api/v1/export/orders", apiHandleExportOrders, requireAPIKey, requireExports)
+	}
+
+	if viper.GetString("metricsPath") != "" {
+		e.GET(viper.GetString("metricsPath"), metricsHandler())
+	}
+
+	if viper.GetString("adminKey") != "" || jwtConfigured() || adminCertRequired() {
+		admin := e.Group("/admin", adminAuth, auditAdmin)
+		admin.GET("/audit", adminHandleAudit)
+		admin.GET("/telemetry", adminHandleTelemetry)
+		admin.GET("/abuse", adminHandleAbuse)
+		admin.DELETE("/abuse", adminHandleAbuseClear)
+		admin.GET("/cache", adminHandleCacheStats)
+		admin.DELETE("/cache", adminHandleCachePurge)
+		admin.GET("/jobs", adminHandleJobs)
+		admin.GET("/slow-queries", adminHandleSlowQueries)
+		admin.GET("/data/blacklist", adminHandleBlacklist)
+		admin.POST("/data/blacklist", adminHandleBlacklistAdd)
+		admin.DELETE("/data/blacklist/:id", adminHandleBlacklistDelete)
+		admin.GET("/data/implausible", adminHandleImplausibleOrders)
+		admin.GET("/items/aliases", adminHandleAliases)
+		admin.POST("/items/aliases", adminHandleAliasAdd)
+		admin.DELETE("/items/aliases/:id", adminHandleAliasDelete)
+		admin.GET("/features", adminHandleFeatures)
+		admin.PUT("/features/:name", adminHandleFeatureSet)
+		admin.DELETE("/features/:name", adminHandleFeatureReset)
+		admin.GET("/status", apiHandleStatus)
+		admin.PUT("/status", adminHandleStatusSet)
+		admin.GET("/webhooks", adminHandleWebhooks)
+		admin.POST("/webhooks", adminHandleWebhookAdd)
+		admin.DELETE("/webhooks/:id", adminHandleWebhookDelete)
+	}
+
+	// Background jobs
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leader.Start(ctx)
+	renewSecrets(ctx)
+	if err := cluster.Start(ctx); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+	wildcards.watchNewItems(ctx)
+	sweepCaches(ctx)
+	if err := publishGoldTicks(ctx); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+
+	sched, err := startScheduler(e)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if sched != nil {
+		defer sched.Stop()
+	}
+
+	if err := startAdminListener(e); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
 
 	// Start server
 	if viper.GetBool("useHttps") {
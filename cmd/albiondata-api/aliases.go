@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Longest chain of renames followed, guards against alias loops
+const maxAliasHops = 8
+
+// itemAlias maps an item id a patch renamed or retired to the id replacing it.
+type itemAlias struct {
+	OldID     string `gorm:"primary_key"`
+	NewID     string
+	Note      string
+	CreatedAt time.Time
+}
+
+func (itemAlias) TableName() string {
+	return "item_aliases"
+}
+
+func (a itemAlias) API() lib.APIItemAlias {
+	return lib.APIItemAlias{OldID: a.OldID, NewID: a.NewID, Note: a.Note, CreatedAt: lib.Timestamp(a.CreatedAt)}
+}
+
+// itemAliases keeps the item_aliases table in memory.
+type itemAliases struct {
+	sync.RWMutex
+	byOldID map[string]itemAlias
+}
+
+var aliases = &itemAliases{byOldID: map[string]itemAlias{}}
+
+// Load creates the item_aliases table if needed and reads it.
+func (ia *itemAliases) Load() error {
+	if err := db.AutoMigrate(&itemAlias{}).Error; err != nil {
+		return err
+	}
+	list := []itemAlias{}
+	if err := db.Order("old_id asc").Find(&list).Error; err != nil {
+		return err
+	}
+	byOldID := make(map[string]itemAlias, len(list))
+	for _, a := range list {
+		byOldID[a.OldID] = a
+	}
+
+	ia.Lock()
+	defer ia.Unlock()
+	ia.byOldID = byOldID
+	return nil
+}
+
+func (ia *itemAliases) Entries() []itemAlias {
+	ia.RLock()
+	defer ia.RUnlock()
+	list := make([]itemAlias, 0, len(ia.byOldID))
+	for _, a := range ia.byOldID {
+		list = append(list, a)
+	}
+	return list
+}
+
+// Resolve follows the renames of id to its current id.
+func (ia *itemAliases) Resolve(id string) (string, bool) {
+	ia.RLock()
+	defer ia.RUnlock()
+	resolved := id
+	for i := 0; i < maxAliasHops; i++ {
+		a, ok := ia.byOldID[resolved]
+		if !ok {
+			break
+		}
+		resolved = a.NewID
+	}
+	return resolved, resolved != id
+}
+
+// noteAlias tells the client an id it asked for was replaced, in the meta
+// block and the X-Item-Aliases header.
+func noteAlias(c echo.Context, oldID, newID string) {
+	m := requestMeta(c)
+	if m.Aliases == nil {
+		m.Aliases = map[string]string{}
+	}
+	m.Aliases[oldID] = newID
+
+	pairs := []string{}
+	for o, n := range m.Aliases {
+		pairs = append(pairs, o+"="+n)
+	}
+	sort.Strings(pairs)
+	c.Response().Header().Set("X-Item-Aliases", strings.Join(pairs, ","))
+}
+
+func adminHandleAliases(c echo.Context) error {
+	result := []lib.APIItemAlias{}
+	for _, a := range aliases.Entries() {
+		result = append(result, a.API())
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// adminHandleAliasAdd adds or replaces the alias in the JSON body and purges
+// the response cache so it applies right away.
+func adminHandleAliasAdd(c echo.Context) error {
+	var req lib.APIItemAlias
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	a := itemAlias{OldID: strings.TrimSpace(req.OldID), NewID: strings.TrimSpace(req.NewID), Note: req.Note}
+	switch {
+	case a.OldID == "" || a.NewID == "":
+		return c.String(http.StatusBadRequest, "old_id and new_id are required")
+	case a.OldID == a.NewID:
+		return c.String(http.StatusBadRequest, "old_id and new_id can't be the same")
+	case strings.Contains(a.OldID+a.NewID, "*"):
+		return c.String(http.StatusBadRequest, "Aliases can't contain wildcards")
+	}
+	if resolved, _ := aliases.Resolve(a.NewID); resolved == a.OldID {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("%s already resolves to %s", a.NewID, a.OldID))
+	}
+
+	if err := db.Save(&a).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if err := aliases.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateAliases, "")
+	return renderJSON(c, http.StatusCreated, a.API())
+}
+
+func adminHandleAliasDelete(c echo.Context) error {
+	res := db.Where("old_id = ?", c.Param("id")).Delete(&itemAlias{})
+	if res.Error != nil {
+		return c.String(http.StatusInternalServerError, res.Error.Error())
+	}
+	if res.RowsAffected == 0 {
+		return c.String(http.StatusNotFound, "No such alias")
+	}
+	if err := aliases.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateAliases, "")
+	return c.NoContent(http.StatusNoContent)
+}
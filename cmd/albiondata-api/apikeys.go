@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// apiKey is an entry of the apiKeys config list.
+type apiKey struct {
+	Key  string
+	Name string
+	Tier string
+}
+
+// tier is the quality of service granted to a group of keys, configured
+// under tiers.<name>. Zero values mean no restriction, except RateLimit
+// which falls back to --rateLimit.
+type tier struct {
+	Name             string
+	RateLimit        int
+	MaxWildcardItems int
+	MaxAge           int
+	Exports          bool
+}
+
+const anonymousTier = "anonymous"
+
+var apiKeys = map[string]apiKey{}
+
+// loadAPIKeys reads the apiKeys config list.
+func loadAPIKeys() error {
+	keys := []apiKey{}
+	if err := viper.UnmarshalKey("apiKeys", &keys); err != nil {
+		return fmt.Errorf("apiKeys: %v", err)
+	}
+	for _, k := range keys {
+		if k.Tier == "" {
+			k.Tier = "registered"
+		}
+		apiKeys[k.Key] = k
+	}
+	return nil
+}
+
+func loadTier(name string) tier {
+	t := tier{Name: name, Exports: true}
+	viper.UnmarshalKey("tiers."+name, &t)
+	t.Name = name
+	if t.RateLimit == 0 {
+		t.RateLimit = viper.GetInt("rateLimit")
+	}
+	return t
+}
+
+// rateLimitConfigured reports if any tier is rate limited.
+func rateLimitConfigured() bool {
+	if viper.GetInt("rateLimit") > 0 {
+		return true
+	}
+	for _, t := range tenants {
+		if t.RateLimit > 0 {
+			return true
+		}
+	}
+	for name := range viper.GetStringMap("tiers") {
+		if loadTier(name).RateLimit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyAuth resolves the X-API-Key header, or a bearer JWT, to a key and
+// tier, requests without either get the anonymous tier.
+func apiKeyAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tierName := anonymousTier
+		if raw, ok := bearerJWT(c); ok {
+			claims, err := parseJWT(raw)
+			if err != nil {
+				return c.String(http.StatusUnauthorized, "Invalid token: "+err.Error())
+			}
+			k := jwtAPIKey(claims)
+			c.Set("jwtClaims", claims)
+			c.Set("apiKey", k)
+			tierName = k.Tier
+		} else if key := c.Request().Header.Get("X-API-Key"); len(key) > 0 {
+			k, ok := apiKeys[key]
+			if !ok {
+				return c.String(http.StatusUnauthorized, "Invalid API key")
+			}
+			c.Set("apiKey", k)
+			tierName = k.Tier
+		}
+		c.Set("tier", loadTier(tierName))
+		return next(c)
+	}
+}
+
+// tierFor returns the tier of the request.
+func tierFor(c echo.Context) tier {
+	if t, ok := c.Get("tier").(tier); ok {
+		return t
+	}
+	return loadTier(anonymousTier)
+}
+
+// rateLimitClient identifies who a request is counted against, the key if
+// there is one, otherwise the client IP. Tenants count separately.
+func rateLimitClient(c echo.Context) string {
+	prefix := ""
+	if name := tenantName(c); name != "" {
+		prefix = "tenant:" + name + "|"
+	}
+	if k, ok := c.Get("apiKey").(apiKey); ok {
+		return prefix + "key:" + k.Key
+	}
+	return prefix + "ip:" + c.RealIP()
+}
+
+// requireExports only lets tiers with export access through.
+func requireExports(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !tierFor(c).Exports {
+			return c.String(http.StatusForbidden, "Your API key tier has no access to exports")
+		}
+		return next(c)
+	}
+}
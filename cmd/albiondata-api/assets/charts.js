@@ -0,0 +1,73 @@
+// Draws the prices_avg series of every location returned by
+// /api/v1/stats/charts/:item as lines on a canvas.
+var chartColors = ["#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4", "#42d4f4", "#f032e6", "#9a6324", "#000075", "#808000"];
+
+function drawCharts(canvas, legend, url) {
+	fetch(url).then(function (res) {
+		if (!res.ok) {
+			return res.text().then(function (text) { throw new Error(text); });
+		}
+		return res.json();
+	}).then(function (series) {
+		render(canvas, legend, series);
+	}).catch(function (err) {
+		legend.textContent = err.message;
+	});
+}
+
+function render(canvas, legend, series) {
+	var ratio = window.devicePixelRatio || 1;
+	canvas.width = canvas.clientWidth * ratio;
+	canvas.height = canvas.clientHeight * ratio;
+	var ctx = canvas.getContext("2d");
+	ctx.scale(ratio, ratio);
+	var w = canvas.clientWidth, h = canvas.clientHeight, pad = 60;
+
+	var minT = Infinity, maxT = -Infinity, minP = Infinity, maxP = -Infinity;
+	series.forEach(function (s) {
+		s.data.timestamps.forEach(function (t, i) {
+			var p = s.data.prices_avg[i];
+			minT = Math.min(minT, t); maxT = Math.max(maxT, t);
+			minP = Math.min(minP, p); maxP = Math.max(maxP, p);
+		});
+	});
+	if (minT === Infinity) {
+		legend.textContent = "No data";
+		return;
+	}
+	if (maxT === minT) { maxT = minT + 1; }
+	if (maxP === minP) { maxP = minP + 1; }
+
+	var x = function (t) { return pad + (t - minT) / (maxT - minT) * (w - 2 * pad); };
+	var y = function (p) { return h - pad - (p - minP) / (maxP - minP) * (h - 2 * pad); };
+
+	// Axes with min and max labels
+	ctx.strokeStyle = "#888";
+	ctx.fillStyle = "#333";
+	ctx.font = "12px sans-serif";
+	ctx.beginPath();
+	ctx.moveTo(pad, pad); ctx.lineTo(pad, h - pad); ctx.lineTo(w - pad, h - pad);
+	ctx.stroke();
+	ctx.fillText(Math.round(maxP).toLocaleString(), 4, pad);
+	ctx.fillText(Math.round(minP).toLocaleString(), 4, h - pad);
+	ctx.fillText(new Date(minT).toLocaleString(), pad, h - pad + 20);
+	var last = new Date(maxT).toLocaleString();
+	ctx.fillText(last, w - pad - ctx.measureText(last).width, h - pad + 20);
+
+	legend.textContent = "";
+	series.forEach(function (s, n) {
+		var color = chartColors[n % chartColors.length];
+		ctx.strokeStyle = color;
+		ctx.beginPath();
+		s.data.timestamps.forEach(function (t, i) {
+			var px = x(t), py = y(s.data.prices_avg[i]);
+			if (i === 0) { ctx.moveTo(px, py); } else { ctx.lineTo(px, py); }
+		});
+		ctx.stroke();
+
+		var label = document.createElement("span");
+		label.style.color = color;
+		label.textContent = s.location;
+		legend.appendChild(label);
+	});
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Longest request body kept in an audit entry
+const maxAuditPayload = 16 * 1024
+
+// auditEntry is an admin action that changed something, kept in the
+// admin_audit table.
+type auditEntry struct {
+	ID        uint `gorm:"primary_key"`
+	Actor     string
+	IP        string
+	Method    string
+	Route     string
+	URI       string `gorm:"type:text"`
+	Payload   string `gorm:"type:text"`
+	Status    int
+	CreatedAt time.Time
+}
+
+func (auditEntry) TableName() string {
+	return "admin_audit"
+}
+
+func (a auditEntry) API() lib.APIAuditEntry {
+	return lib.APIAuditEntry{
+		ID:        a.ID,
+		Actor:     a.Actor,
+		IP:        a.IP,
+		Method:    a.Method,
+		Route:     a.Route,
+		URI:       a.URI,
+		Payload:   a.Payload,
+		Status:    a.Status,
+		CreatedAt: lib.Timestamp(a.CreatedAt),
+	}
+}
+
+// migrateAudit creates the admin_audit table if needed.
+func migrateAudit() error {
+	return db.AutoMigrate(&auditEntry{}).Error
+}
+
+// auditActor names who made an admin request: the JWT subject, the client
+// certificate or the shared admin key.
+func auditActor(c echo.Context) string {
+	if claims, ok := requestClaims(c); ok {
+		if sub, _ := claims["sub"].(string); sub != "" {
+			return "jwt:" + sub
+		}
+	}
+	if hasClientCert(c) {
+		return "cert:" + c.Request().TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return "adminKey"
+}
+
+// auditAdmin is a middleware of the admin group recording every request
+// that isn't a GET, with its body, whether or not it succeeded.
+func auditAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead {
+			return next(c)
+		}
+
+		var payload []byte
+		if c.Request().Body != nil {
+			var err error
+			if payload, err = ioutil.ReadAll(c.Request().Body); err != nil {
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			c.Request().Body = ioutil.NopCloser(bytes.NewReader(payload))
+		}
+		if len(payload) > maxAuditPayload {
+			payload = payload[:maxAuditPayload]
+		}
+
+		err := next(c)
+		status := c.Response().Status
+		if he, ok := err.(*echo.HTTPError); ok {
+			status = he.Code
+		}
+
+		entry := auditEntry{
+			Actor:   auditActor(c),
+			IP:      c.RealIP(),
+			Method:  c.Request().Method,
+			Route:   c.Path(),
+			URI:     c.Request().RequestURI,
+			Payload: string(payload),
+			Status:  status,
+		}
+		if dberr := db.Create(&entry).Error; dberr != nil {
+			fmt.Printf("Can't write the audit log: %v\n", dberr)
+		}
+		return err
+	}
+}
+
+// adminHandleAudit lists the audit log newest first, at most ?limit= (default
+// 100) entries from before ?before= (an entry id) for paging.
+func adminHandleAudit(c echo.Context) error {
+	limit := 100
+	if len(c.QueryParam("limit")) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(c.QueryParam("limit")); err != nil || limit < 1 {
+			return c.String(http.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+	q := db.Order("id desc").Limit(limit)
+	if len(c.QueryParam("before")) > 0 {
+		before, err := strconv.Atoi(c.QueryParam("before"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, "before must be an entry id")
+		}
+		q = q.Where("id < ?", before)
+	}
+
+	entries := []auditEntry{}
+	if err := q.Find(&entries).Error; err != nil {
+		return err
+	}
+	result := []lib.APIAuditEntry{}
+	for _, a := range entries {
+		result = append(result, a.API())
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Kinds of blacklist entries
+const (
+	blacklistOrder      = "order"       // one order by albion_id
+	blacklistPriceRange = "price_range" // prices of an item, or all items, outside price_min..price_max
+)
+
+type blacklistEntry struct {
+	ID        uint `gorm:"primary_key"`
+	Kind      string
+	AlbionID  uint
+	ItemID    string
+	PriceMin  int
+	PriceMax  int
+	Reason    string
+	CreatedAt time.Time
+}
+
+func (blacklistEntry) TableName() string {
+	return "data_blacklist"
+}
+
+func (b blacklistEntry) API() lib.APIBlacklistEntry {
+	return lib.APIBlacklistEntry{
+		ID:        b.ID,
+		Kind:      b.Kind,
+		AlbionID:  b.AlbionID,
+		ItemID:    b.ItemID,
+		PriceMin:  int64(b.PriceMin),
+		PriceMax:  int64(b.PriceMax),
+		Reason:    b.Reason,
+		CreatedAt: lib.Timestamp(b.CreatedAt),
+	}
+}
+
+// dataBlacklist keeps the data_blacklist table in memory so queries can
+// leave the listed orders out without a join.
+type dataBlacklist struct {
+	sync.RWMutex
+	entries []blacklistEntry
+}
+
+var blacklist = &dataBlacklist{}
+
+// Load creates the data_blacklist table if needed and reads it.
+func (bl *dataBlacklist) Load() error {
+	if err := db.AutoMigrate(&blacklistEntry{}).Error; err != nil {
+		return err
+	}
+	entries := []blacklistEntry{}
+	if err := db.Order("id asc").Find(&entries).Error; err != nil {
+		return err
+	}
+
+	bl.Lock()
+	defer bl.Unlock()
+	bl.entries = entries
+	return nil
+}
+
+func (bl *dataBlacklist) Entries() []blacklistEntry {
+	bl.RLock()
+	defer bl.RUnlock()
+	return append([]blacklistEntry{}, bl.entries...)
+}
+
+// Exclude adds the conditions leaving blacklisted orders out to a
+// market_orders query.
+func (bl *dataBlacklist) Exclude(q *gorm.DB) *gorm.DB {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	ids := []uint{}
+	for _, b := range bl.entries {
+		switch b.Kind {
+		case blacklistOrder:
+			ids = append(ids, b.AlbionID)
+		case blacklistPriceRange:
+			cond, args := "price < ?", []interface{}{b.PriceMin}
+			if b.PriceMax > 0 {
+				cond, args = "(price < ? or price > ?)", append(args, b.PriceMax)
+			}
+			if b.ItemID != "" {
+				cond, args = "item_id = ? and "+cond, append([]interface{}{b.ItemID}, args...)
+			}
+			q = q.Where("not ("+cond+")", args...)
+		}
+	}
+	if len(ids) > 0 {
+		q = q.Where("albion_id not in (?)", ids)
+	}
+	return q
+}
+
+func adminHandleBlacklist(c echo.Context) error {
+	result := []lib.APIBlacklistEntry{}
+	for _, b := range blacklist.Entries() {
+		result = append(result, b.API())
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// adminHandleBlacklistAdd adds the entry in the JSON body and purges the
+// response cache so it applies right away.
+func adminHandleBlacklistAdd(c echo.Context) error {
+	var req lib.APIBlacklistEntry
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	b := blacklistEntry{
+		Kind:     req.Kind,
+		AlbionID: req.AlbionID,
+		ItemID:   req.ItemID,
+		PriceMin: int(req.PriceMin),
+		PriceMax: int(req.PriceMax),
+		Reason:   req.Reason,
+	}
+	switch {
+	case b.Kind == blacklistOrder && b.AlbionID == 0:
+		return c.String(http.StatusBadRequest, "albion_id is required")
+	case b.Kind == blacklistPriceRange && b.PriceMin <= 0 && b.PriceMax <= 0:
+		return c.String(http.StatusBadRequest, "price_min or price_max is required")
+	case b.Kind == blacklistPriceRange && b.PriceMax > 0 && b.PriceMax < b.PriceMin:
+		return c.String(http.StatusBadRequest, "price_max can't be below price_min")
+	case b.Kind == "uploader":
+		return c.String(http.StatusBadRequest, "Orders aren't stored with their uploader, they can't be excluded by uploader")
+	case b.Kind != blacklistOrder && b.Kind != blacklistPriceRange:
+		return c.String(http.StatusBadRequest, fmt.Sprintf("kind must be %s or %s", blacklistOrder, blacklistPriceRange))
+	}
+
+	if err := db.Create(&b).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if err := blacklist.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateBlacklist, "")
+	return renderJSON(c, http.StatusCreated, b.API())
+}
+
+func adminHandleBlacklistDelete(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "id must be a number")
+	}
+	res := db.Where("id = ?", id).Delete(&blacklistEntry{})
+	if res.Error != nil {
+		return c.String(http.StatusInternalServerError, res.Error.Error())
+	}
+	if res.RowsAffected == 0 {
+		return c.String(http.StatusNotFound, "No such blacklist entry")
+	}
+	if err := blacklist.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateBlacklist, "")
+	return c.NoContent(http.StatusNoContent)
+}
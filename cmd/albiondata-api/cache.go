@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+var respCache = newResponseCache()
+
+// How often expired entries are swept out of the in-memory caches
+const cacheSweepInterval = time.Minute
+
+type cacheEntry struct {
+	contentType string
+	header      http.Header
+	body        []byte
+	gzipped     []byte
+	items       []string
+}
+
+// Bodies smaller than this aren't worth keeping compressed
+const minGzipSize = 1024
+
+// gzipRoute reports whether cached responses of the route are kept
+// gzip-compressed as well, the routes are listed in cacheGzipRoutes.
+func gzipRoute(path string) bool {
+	for _, r := range strings.Split(viper.GetString("cacheGzipRoutes"), ",") {
+		if strings.TrimSpace(r) == path {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBody(body []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func acceptsGzip(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAcceptEncoding), "gzip")
+}
+
+// boundedCache is an in-memory TTL cache of at most max() entries, the least
+// recently used entry makes room for a new one. Expired entries are dropped
+// when read and by Sweep, which sweepCaches runs.
+type boundedCache struct {
+	sync.Mutex
+	max     func() int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type boundedEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func newBoundedCache(max func() int) *boundedCache {
+	return &boundedCache{max: max, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (bc *boundedCache) Get(key string) (interface{}, bool) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	el, ok := bc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*boundedEntry)
+	if time.Now().After(e.expires) {
+		bc.remove(el)
+		return nil, false
+	}
+	bc.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (bc *boundedCache) Set(key string, value interface{}, ttl time.Duration) {
+	max := bc.max()
+	if ttl <= 0 || max <= 0 {
+		return
+	}
+
+	bc.Lock()
+	defer bc.Unlock()
+
+	if el, ok := bc.entries[key]; ok {
+		el.Value = &boundedEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+		bc.lru.MoveToFront(el)
+		return
+	}
+	for bc.lru.Len() >= max {
+		bc.remove(bc.lru.Back())
+	}
+	bc.entries[key] = bc.lru.PushFront(&boundedEntry{key: key, value: value, expires: time.Now().Add(ttl)})
+}
+
+// DeleteFunc removes the entries drop returns true for, and returns how many.
+func (bc *boundedCache) DeleteFunc(drop func(key string, value interface{}) bool) int {
+	bc.Lock()
+	defer bc.Unlock()
+
+	dropped := 0
+	for el := bc.lru.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*boundedEntry)
+		if drop(e.key, e.value) {
+			bc.remove(el)
+			dropped++
+		}
+		el = next
+	}
+	return dropped
+}
+
+// Sweep removes the expired entries.
+func (bc *boundedCache) Sweep() int {
+	bc.Lock()
+	defer bc.Unlock()
+
+	now := time.Now()
+	swept := 0
+	for el := bc.lru.Front(); el != nil; {
+		next := el.Next()
+		if now.After(el.Value.(*boundedEntry).expires) {
+			bc.remove(el)
+			swept++
+		}
+		el = next
+	}
+	return swept
+}
+
+func (bc *boundedCache) Len() int {
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.lru.Len()
+}
+
+func (bc *boundedCache) remove(el *list.Element) {
+	bc.lru.Remove(el)
+	delete(bc.entries, el.Value.(*boundedEntry).key)
+}
+
+// sweepCaches sweeps the in-memory caches every cacheSweepInterval until ctx
+// is done, entries nobody asks for again don't stay until they are evicted.
+func sweepCaches(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cacheSweepInterval):
+			}
+			for _, bc := range []*boundedCache{respCache.entries, wildcards.entries, sparklines.entries} {
+				bc.Sweep()
+			}
+		}
+	}()
+}
+
+// responseCache keeps rendered GET responses in memory for cacheTTL seconds,
+// at most cacheMaxEntries of them.
+type responseCache struct {
+	entries *boundedCache
+
+	sync.Mutex
+	hits   uint64
+	misses uint64
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: newBoundedCache(func() int { return viper.GetInt("cacheMaxEntries") })}
+}
+
+func (rc *responseCache) Get(key string) (cacheEntry, bool) {
+	v, ok := rc.entries.Get(key)
+
+	rc.Lock()
+	defer rc.Unlock()
+	if !ok {
+		rc.misses++
+		return cacheEntry{}, false
+	}
+	rc.hits++
+	return v.(cacheEntry), true
+}
+
+func (rc *responseCache) Set(key string, e cacheEntry, ttl time.Duration) {
+	rc.entries.Set(key, e, ttl)
+}
+
+// Purge removes all entries containing an item matching pattern, which may
+// use path.Match wildcards. An empty pattern purges everything.
+func (rc *responseCache) Purge(pattern string) int {
+	return rc.entries.DeleteFunc(func(key string, v interface{}) bool {
+		return pattern == "" || entryMatches(v.(cacheEntry), pattern)
+	})
+}
+
+// entryMatches reports whether an entry may hold an item of pattern, the
+// items of an entry are the ids and wildcards the request asked for and the
+// ids they resolved to. Two wildcards are taken to overlap.
+func entryMatches(e cacheEntry, pattern string) bool {
+	for _, item := range e.items {
+		if ok, _ := path.Match(pattern, item); ok {
+			return true
+		}
+		if strings.ContainsAny(item, "*?[") {
+			if ok, _ := path.Match(item, pattern); ok || strings.ContainsAny(pattern, "*?[") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rc *responseCache) Stats() (entries int, hits uint64, misses uint64) {
+	entries = rc.entries.Len()
+	rc.Lock()
+	defer rc.Unlock()
+	return entries, rc.hits, rc.misses
+}
+
+func cacheTTL() time.Duration {
+	return time.Duration(viper.GetInt("cacheTTL")) * time.Second
+}
+
+// cacheKeyParams are the query params going into cache keys, the params the
+// API reads. Others can't change a response, ?refresh= doesn't either.
+var cacheKeyParams = func() map[string]bool {
+	m := map[string]bool{"pretty": true}
+	for _, name := range queryParamNames {
+		m[name] = name != "refresh"
+	}
+	return m
+}()
+
+// cacheKey identifies a request by everything that changes its response.
+func cacheKey(c echo.Context) string {
+	query := url.Values{}
+	for k, v := range c.QueryParams() {
+		if cacheKeyParams[k] {
+			query[k] = v
+		}
+	}
+	return c.Request().URL.Path + "?" + query.Encode() + "|" + c.Request().Header.Get("Accept-Profile") + "|" + tierFor(c).Name + "|" + tenantName(c)
+}
+
+// bufferWriter keeps a response in memory instead of sending it. With orig
+// set, bypass can send it on to the client instead.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+
+	orig        http.ResponseWriter
+	wroteHeader bool
+	bypassed    bool
+}
+
+func (bw *bufferWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferWriter) WriteHeader(code int) {
+	bw.status = code
+	bw.wroteHeader = true
+	if bw.bypassed {
+		bw.orig.WriteHeader(code)
+	}
+}
+
+func (bw *bufferWriter) Write(b []byte) (int, error) {
+	if bw.bypassed {
+		return bw.orig.Write(b)
+	}
+	return bw.body.Write(b)
+}
+
+// Flush flushes the client's response once it is bypassed, buffered
+// responses have nothing to flush.
+func (bw *bufferWriter) Flush() {
+	if f, ok := bw.orig.(http.Flusher); ok && bw.bypassed {
+		f.Flush()
+	}
+}
+
+// bypass sends what was buffered to the client and everything after it
+// straight through, for responses too large to be kept. It does nothing
+// without orig.
+func (bw *bufferWriter) bypass() error {
+	if bw.orig == nil || bw.bypassed {
+		return nil
+	}
+	bw.bypassed = true
+	if bw.wroteHeader {
+		bw.orig.WriteHeader(bw.status)
+	}
+	_, err := bw.orig.Write(bw.body.Bytes())
+	bw.body.Reset()
+	return err
+}
+
+// flightResult is a response shared by all identical concurrent requests.
+type flightResult struct {
+	status      int
+	contentType string
+	header      http.Header
+	body        []byte
+	err         error
+	// panicked is what the handler panicked with, if it did
+	panicked interface{}
+	// streamed responses went to the client of the request running the
+	// handler only
+	streamed bool
+}
+
+// shareable tells if waiting requests can be given the result instead of
+// running the handler themselves.
+func (r *flightResult) shareable() bool {
+	return r.panicked == nil && !r.streamed && r.err == nil && r.status >= 200 && r.status < 300
+}
+
+// handlerHeaders returns the headers of after that aren't in before, the ones
+// a handler set, without those describing the body.
+func handlerHeaders(before, after http.Header) http.Header {
+	h := http.Header{}
+	for k, v := range after {
+		if k == echo.HeaderContentType || k == echo.HeaderContentLength {
+			continue
+		}
+		if strings.Join(before[k], ",") != strings.Join(v, ",") {
+			h[k] = append([]string(nil), v...)
+		}
+	}
+	return h
+}
+
+// replayHeaders sets headers kept with a response on c.
+func replayHeaders(c echo.Context, h http.Header) {
+	for k, v := range h {
+		c.Response().Header()[k] = v
+	}
+}
+
+var inflight singleflight.Group
+
+// cacheResponse is a route middleware serving and storing responses in
+// respCache. Identical requests arriving while the response is computed
+// wait for it instead of querying the database themselves.
+func cacheResponse(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ttl := cacheTTL()
+		// Explained requests have to run their queries
+		if c.Request().Method != http.MethodGet || requestExplain(c) != nil {
+			return next(c)
+		}
+
+		// ?refresh=true recomputes the response and stores the new one
+		refresh := refreshRequested(c)
+		if refresh && !isAdmin(c) {
+			return c.String(http.StatusForbidden, "refresh is only allowed for admins")
+		}
+
+		key := cacheKey(c)
+		if ttl > 0 && !refresh {
+			if e, ok := respCache.Get(key); ok {
+				replayHeaders(c, e.header)
+				c.Response().Header().Set("X-Cache", "HIT")
+				if metaRequested(c) && isJSON(e.contentType) {
+					return c.Blob(http.StatusOK, e.contentType, markCacheHit(e.body))
+				}
+				c.Response().Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+				if e.gzipped != nil && acceptsGzip(c) {
+					c.Response().Header().Set(echo.HeaderContentEncoding, "gzip")
+					return c.Blob(http.StatusOK, e.contentType, e.gzipped)
+				}
+				return c.Blob(http.StatusOK, e.contentType, e.body)
+			}
+			c.Response().Header().Set("X-Cache", "MISS")
+		}
+
+		// Debug requests are never shared or stored, their numbers and meta
+		// block belong to this request only
+		if requestStats(c) != nil {
+			return next(c)
+		}
+
+		ran := false
+		orig := c.Response().Writer
+		v, _, _ := inflight.Do(key, func() (result interface{}, _ error) {
+			ran = true
+			before := http.Header{}
+			for k, v := range orig.Header() {
+				before[k] = v
+			}
+			buf := &bufferWriter{header: orig.Header(), status: http.StatusOK, orig: orig}
+			c.Response().Writer = buf
+			// A panic has to end the flight too, or the requests waiting on
+			// the key would hang
+			defer func() {
+				c.Response().Writer = orig
+				if r := recover(); r != nil {
+					result = &flightResult{panicked: r}
+				}
+			}()
+			err := next(c)
+
+			res := &flightResult{
+				status:      buf.status,
+				contentType: buf.header.Get(echo.HeaderContentType),
+				header:      handlerHeaders(before, buf.header),
+				body:        buf.body.Bytes(),
+				err:         err,
+				streamed:    buf.bypassed,
+			}
+			if ttl > 0 && err == nil && res.status == http.StatusOK && !res.streamed {
+				entry := cacheEntry{
+					contentType: res.contentType,
+					header:      res.header,
+					body:        res.body,
+					items:       cachedItems(c),
+				}
+				// Compressed once here instead of on every hit
+				if len(res.body) >= minGzipSize && gzipRoute(c.Path()) && features.Enabled("cacheGzip") {
+					entry.gzipped = gzipBody(res.body)
+				}
+				respCache.Set(key, entry, ttl)
+			}
+			return res, nil
+		})
+		res := v.(*flightResult)
+
+		if ran {
+			if res.panicked != nil {
+				// Nothing reached the client, let Recover answer instead
+				c.Response().Committed = false
+				panic(res.panicked)
+			}
+			// The handler wrote into the buffer, pass it on to the client
+			if c.Response().Committed && !res.streamed {
+				orig.WriteHeader(res.status)
+				orig.Write(res.body)
+			}
+			return res.err
+		}
+		// Failures of another request aren't passed on, this one may do better
+		if !res.shareable() {
+			return next(c)
+		}
+		replayHeaders(c, res.header)
+		return c.Blob(res.status, res.contentType, res.body)
+	}
+}
+
+// cachedItems lists the items a response is about for Purge, the ones asked
+// for by :item or ?items= and the ids they resolved to.
+func cachedItems(c echo.Context) []string {
+	items := []string{}
+	for _, param := range []string{c.Param("item"), c.QueryParam("items")} {
+		for _, item := range strings.Split(param, ",") {
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return append(items, requestMeta(c).ItemsResolved...)
+}
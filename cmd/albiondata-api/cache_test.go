@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestCacheResponsePanic(t *testing.T) {
+	e := echo.New()
+	calls := 0
+	h := cacheResponse(func(c echo.Context) error {
+		calls++
+		if calls == 1 {
+			c.String(http.StatusOK, "partial")
+			panic("boom")
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest("GET", "/panic", nil), rec)
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the handler's panic", r)
+			}
+		}()
+		h(c)
+	}()
+	if c.Response().Writer != rec {
+		t.Errorf("response writer wasn't restored")
+	}
+	if c.Response().Committed {
+		t.Errorf("response still committed after the panic")
+	}
+
+	// The key isn't left in flight, the next request runs the handler
+	rec = httptest.NewRecorder()
+	if err := h(e.NewContext(httptest.NewRequest("GET", "/panic", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.String() != "ok" {
+		t.Errorf("body = %q, want ok", rec.Body.String())
+	}
+}
+
+// flushRecorder notes how much of the body was written at every flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushedAt []int
+}
+
+func (fr *flushRecorder) Flush() {
+	fr.flushedAt = append(fr.flushedAt, fr.Body.Len())
+}
+
+func TestCacheResponseStreamed(t *testing.T) {
+	e := echo.New()
+	calls := 0
+	h := cacheResponse(func(c echo.Context) error {
+		calls++
+		return writeJSON(c, http.StatusOK, make([]int, 3*streamJSONMinLength))
+	})
+
+	for i := 0; i < 2; i++ {
+		fr := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		if err := h(e.NewContext(httptest.NewRequest("GET", "/streamed", nil), fr)); err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		if err := json.Unmarshal(fr.Body.Bytes(), &got); err != nil || len(got) != 3*streamJSONMinLength {
+			t.Fatalf("body of %d elements, %v", len(got), err)
+		}
+		if len(fr.flushedAt) != 3 || fr.flushedAt[0] == 0 || fr.flushedAt[0] >= fr.Body.Len() {
+			t.Errorf("flushed at %v of %d bytes, want every %d elements", fr.flushedAt, fr.Body.Len(), streamJSONMinLength)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, streamed responses shouldn't be cached", calls)
+	}
+}
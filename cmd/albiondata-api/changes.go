@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// How often a waiting changes request looks at the database again
+const changesPollInterval = 2 * time.Second
+
+// parseSince accepts RFC3339 or unix seconds.
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	unix, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since must be a RFC3339 timestamp or unix seconds")
+	}
+	return time.Unix(unix, 0), nil
+}
+
+// apiHandleChanges long-polls for item/location pairs with orders updated
+// after ?since=, waiting up to ?timeout= seconds for the first change.
+func apiHandleChanges(c echo.Context) error {
+	since, err := parseSince(c.QueryParam("since"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if maxAge := ageSetting("changes", "maxAge"); since.Before(time.Now().Add(-time.Duration(maxAge) * time.Second)) {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("since can't be more than %d seconds ago", maxAge))
+	}
+
+	timeout := viper.GetInt("longPollTimeout")
+	if t, err := strconv.Atoi(c.QueryParam("timeout")); err == nil && t >= 0 && t < timeout {
+		timeout = t
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	items := []string{}
+	if len(c.QueryParam("items")) > 0 {
+		items = strings.Split(c.QueryParam("items"), ",")
+	}
+	if !endpointEnabled("wildcards") && strings.Contains(c.QueryParam("items"), "*") {
+		return c.String(http.StatusBadRequest, "Wildcards are disabled on this server")
+	}
+
+	for {
+		until := time.Now()
+		changes, err := findChanges(dbFrom(c), since, until, items)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) > 0 || !until.Before(deadline) {
+			return renderJSON(c, http.StatusOK, lib.APIChangesResponse{
+				Since:   lib.Timestamp(since),
+				Until:   lib.Timestamp(until),
+				Changes: changes,
+			})
+		}
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-time.After(changesPollInterval):
+		}
+	}
+}
+
+func findChanges(conn *gorm.DB, since time.Time, until time.Time, items []string) ([]lib.APIChange, error) {
+	q := conn.Table(adslib.NewModelMarketOrder().TableName()).Select("item_id, location").Where("updated_at > ? and updated_at <= ?", since, until)
+
+	if len(items) > 0 {
+		conds := []string{}
+		args := []interface{}{}
+		for _, item := range items {
+			if strings.Contains(item, "*") {
+				conds = append(conds, "item_id LIKE ? ESCAPE '!'")
+				args = append(args, likePattern(item))
+			} else {
+				conds = append(conds, "item_id = ?")
+				args = append(args, item)
+			}
+		}
+		q = q.Where(strings.Join(conds, " or "), args...)
+	}
+
+	rows := []struct {
+		ItemID   string
+		Location adslib.Location
+	}{}
+	if err := q.Group("item_id, location").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	changes := []lib.APIChange{}
+	for _, r := range rows {
+		changes = append(changes, lib.APIChange{ItemID: r.ItemID, City: r.Location.String()})
+	}
+	return changes, nil
+}
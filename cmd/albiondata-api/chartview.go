@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Where the embedded assets are served
+const assetsPath = "/view/assets"
+
+var chartPage = template.Must(template.ParseFS(assets, "assets/charts.html"))
+
+// viewHandleCharts serves a page drawing the charts of an item, the query
+// params are passed on to /api/v1/stats/charts.
+func viewHandleCharts(c echo.Context) error {
+	if strings.ContainsAny(c.Param("item"), ",*") {
+		return c.String(http.StatusBadRequest, "The chart page shows a single item")
+	}
+	dataURL := "/api/v1/stats/charts/" + url.PathEscape(c.Param("item"))
+	if q := c.QueryParams().Encode(); q != "" {
+		dataURL += "?" + q
+	}
+
+	var buf bytes.Buffer
+	if err := chartPage.Execute(&buf, map[string]interface{}{
+		"Item":       c.Param("item"),
+		"AssetsPath": assetsPath,
+		"DataURL":    dataURL,
+	}); err != nil {
+		return err
+	}
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+// viewHandleAssets serves the files embedded from assets/.
+func viewHandleAssets(c echo.Context) error {
+	b, err := assets.ReadFile("assets/" + c.Param("file"))
+	if err != nil {
+		return c.String(http.StatusNotFound, "Not found")
+	}
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	return c.Blob(http.StatusOK, mime.TypeByExtension(path.Ext(c.Param("file"))), b)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// cityNames are the market names of the game clients that translate them,
+// by language and English name. Other languages keep the English names,
+// cityNames.<lang> in the config adds or overrides entries.
+var cityNames = map[string]map[string]string{
+	"DE-DE": {"Black Market": "Schwarzmarkt"},
+	"FR-FR": {"Black Market": "Marché noir"},
+	"ES-ES": {"Black Market": "Mercado negro"},
+	"PT-BR": {"Black Market": "Mercado Negro"},
+	"IT-IT": {"Black Market": "Mercato nero"},
+	"PL-PL": {"Black Market": "Czarny rynek"},
+	"TR-TR": {"Black Market": "Kara Pazar"},
+	"RU-RU": {
+		"Thetford":      "Тетфорд",
+		"Lymhurst":      "Лимхерст",
+		"Bridgewatch":   "Бриджвотч",
+		"Black Market":  "Черный рынок",
+		"Martlock":      "Мартлок",
+		"Caerleon":      "Карлеон",
+		"Fort Sterling": "Форт Стерлинг",
+	},
+}
+
+// JSON keys holding city names
+var cityKeys = map[string]bool{"city": true, "location": true}
+
+// cityLang returns the language cities are translated to, "" for English.
+func cityLang(c echo.Context) string {
+	if len(c.QueryParam("lang")) == 0 {
+		return ""
+	}
+	if lang := normalizeLang(c.QueryParam("lang")); lang != "EN-US" {
+		return lang
+	}
+	return ""
+}
+
+// localizeCity returns the name of city in lang, the config wins over the
+// built-in names. Viper lowercases the config keys, so they are matched
+// regardless of case.
+func localizeCity(lang string, city string) string {
+	if lang == "" {
+		return city
+	}
+	for k, name := range viper.GetStringMapString("cityNames." + lang) {
+		if strings.EqualFold(k, city) {
+			return name
+		}
+	}
+	if name, ok := cityNames[lang][city]; ok {
+		return name
+	}
+	return city
+}
+
+// localizeCities translates the city names of a response for ?lang=, ids
+// and every other value are left alone.
+func localizeCities(c echo.Context, i interface{}) (interface{}, error) {
+	lang := cityLang(c)
+	if lang == "" {
+		return i, nil
+	}
+	b, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return localizeCityValues(v, lang), nil
+}
+
+func localizeCityValues(v interface{}, lang string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if s, ok := val.(string); ok && cityKeys[k] {
+				t[k] = localizeCity(lang, s)
+			} else {
+				t[k] = localizeCityValues(val, lang)
+			}
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = localizeCityValues(val, lang)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// Request classes, every request is in one of them. Long polls mostly wait
+// for data, so they neither count as expensive nor take cheap slots.
+const (
+	classCheap     = "cheap"
+	classExpensive = "expensive"
+	classLongPoll  = "longpoll"
+)
+
+func init() {
+	viper.SetDefault("requestClasses.expensive.routes", strings.Join([]string{
+		bulkPricesPath,
+		"/api/v1/export/sqlite",
+		"/api/v1/export/parquet",
+		"/api/v1/export/orders",
+		"/api/v1/stats/matrix/:item",
+		"/api/v1/stats/transport",
+		"/api/v1/stats/spread/:item",
+		"/api/v1/stats/contributions",
+		"/api/v1/stats/correlate",
+		"/api/v1/reports/:period",
+	}, ","))
+	viper.SetDefault("requestClasses.expensive.items", 20)
+	viper.SetDefault("requestClasses.expensive.concurrency", 4)
+	viper.SetDefault("requestClasses.expensive.queueTimeout", 10000)
+	viper.SetDefault("requestClasses.cheap.queueTimeout", 5000)
+	viper.SetDefault("requestClasses.longpoll.routes", "/api/v1/changes")
+	viper.SetDefault("requestClasses.longpoll.concurrency", 200)
+	viper.SetDefault("requestClasses.longpoll.queueTimeout", 1000)
+}
+
+// Pool of the primary database for expensive requests, nil when they share
+// the primary pool
+var expensiveDB *gorm.DB
+
+// classSlots are the concurrency semaphores of the classes, a class
+// without one is unlimited.
+var classSlots = map[string]chan struct{}{}
+
+func classConfig(class, key string) string {
+	return "requestClasses." + class + "." + key
+}
+
+// openClassPools sizes the database pools of the classes. The primary pool
+// serves cheap requests (and jobs) with at most
+// requestClasses.cheap.dbConnections, expensive requests get their own
+// pool of requestClasses.expensive.dbConnections. 0 leaves a pool unbounded
+// and, for expensive requests, shares the primary one.
+func openClassPools() error {
+	for _, class := range []string{classCheap, classExpensive, classLongPoll} {
+		if n := viper.GetInt(classConfig(class, "concurrency")); n > 0 {
+			classSlots[class] = make(chan struct{}, n)
+		}
+	}
+
+	sizePrimaryPool(db)
+	var err error
+	expensiveDB, err = openExpensivePool()
+	return err
+}
+
+// sizePrimaryPool limits conn, the primary pool, to
+// requestClasses.cheap.dbConnections.
+func sizePrimaryPool(conn *gorm.DB) {
+	if n := viper.GetInt(classConfig(classCheap, "dbConnections")); n > 0 {
+		conn.DB().SetMaxOpenConns(n)
+	}
+}
+
+// openExpensivePool connects the pool of expensive requests, nil when they
+// share the primary one.
+func openExpensivePool() (*gorm.DB, error) {
+	n := viper.GetInt(classConfig(classExpensive, "dbConnections"))
+	if n <= 0 {
+		return nil, nil
+	}
+	dsn, err := primaryDSN()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := gorm.Open(viper.GetString("dbType"), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("expensive request pool: %v", err)
+	}
+	conn.DB().SetMaxOpenConns(n)
+	conn.LogMode(true)
+	registerQueryHooks(conn)
+	return conn, nil
+}
+
+// classRoute reports if the route of the request is listed in
+// requestClasses.<class>.routes.
+func classRoute(c echo.Context, class string) bool {
+	for _, r := range strings.Split(viper.GetString(classConfig(class, "routes")), ",") {
+		if strings.TrimSpace(r) == c.Path() {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClass tells expensive requests from cheap ones: routes listed in
+// requestClasses.expensive.routes, wildcards and lists of more than
+// requestClasses.expensive.items items are expensive. Routes in
+// requestClasses.longpoll.routes are long polls whatever their items.
+func requestClass(c echo.Context) string {
+	if class, ok := c.Get("requestClass").(string); ok {
+		return class
+	}
+	if classRoute(c, classLongPoll) {
+		c.Set("requestClass", classLongPoll)
+		return classLongPoll
+	}
+	class := classCheap
+	if classRoute(c, classExpensive) {
+		class = classExpensive
+	}
+	for _, items := range []string{c.Param("item"), c.QueryParam("items")} {
+		ids := strings.Split(items, ",")
+		if strings.Contains(items, "*") || len(ids) > viper.GetInt(classConfig(classExpensive, "items")) {
+			class = classExpensive
+		}
+	}
+	c.Set("requestClass", class)
+	return class
+}
+
+// limitClassConcurrency runs a request once a slot of its class is free.
+// Requests waiting longer than requestClasses.<class>.queueTimeout
+// milliseconds get 503, so a burst of expensive requests queues behind
+// itself and never takes the slots of cheap ones.
+func limitClassConcurrency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		class := requestClass(c)
+		c.Response().Header().Set("X-Request-Class", class)
+		slots, ok := classSlots[class]
+		if !ok || isInternal(c) {
+			return next(c)
+		}
+
+		timeout := time.Duration(viper.GetInt(classConfig(class, "queueTimeout"))) * time.Millisecond
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+		case <-timer.C:
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(timeout.Seconds())+1))
+			return c.String(http.StatusServiceUnavailable, fmt.Sprintf("Too many %s requests in progress, try again later", class))
+		case <-c.Request().Context().Done():
+			return c.Request().Context().Err()
+		}
+		defer func() { <-slots }()
+		return next(c)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Kinds of invalidations sent between replicas
+const (
+	invalidateCache     = "cache"
+	invalidateBlacklist = "blacklist"
+	invalidateAliases   = "aliases"
+	invalidateFeatures  = "features"
+	invalidateItems     = "items"
+)
+
+// invalidation tells the other replicas to drop what they keep in memory,
+// Pattern is the item pattern of cache purges.
+type invalidation struct {
+	Origin  string `json:"origin"`
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// clusterBus spreads admin changes to every replica with
+// clusterInvalidation set to "redis", without it changes stay local.
+type clusterBus struct {
+	id string
+}
+
+var cluster = &clusterBus{id: newInstanceID()}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (cb *clusterBus) enabled() bool {
+	return viper.GetString("clusterInvalidation") == "redis"
+}
+
+// Broadcast publishes an invalidation this instance already applied.
+func (cb *clusterBus) Broadcast(kind string, pattern string) {
+	if !cb.enabled() {
+		return
+	}
+	client, err := redisClient()
+	if err != nil {
+		fmt.Printf("Cluster invalidation: %v\n", err)
+		return
+	}
+	msg, _ := json.Marshal(invalidation{Origin: cb.id, Kind: kind, Pattern: pattern})
+	if err := client.Publish(viper.GetString("clusterChannel"), msg).Err(); err != nil {
+		fmt.Printf("Cluster invalidation: %v\n", err)
+	}
+}
+
+// Start applies the invalidations of the other replicas until ctx is done.
+func (cb *clusterBus) Start(ctx context.Context) error {
+	if !cb.enabled() {
+		return nil
+	}
+	client, err := redisClient()
+	if err != nil {
+		return fmt.Errorf("cluster invalidation: %v", err)
+	}
+	sub := client.Subscribe(viper.GetString("clusterChannel"))
+	if _, err := sub.Receive(); err != nil {
+		sub.Close()
+		return fmt.Errorf("cluster invalidation: %v", err)
+	}
+
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-ch:
+				if !ok {
+					return
+				}
+				var inv invalidation
+				if err := json.Unmarshal([]byte(m.Payload), &inv); err != nil {
+					fmt.Printf("Cluster invalidation: %v\n", err)
+					continue
+				}
+				if inv.Origin != cb.id {
+					cb.apply(inv)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (cb *clusterBus) apply(inv invalidation) {
+	var err error
+	switch inv.Kind {
+	case invalidateCache:
+		respCache.Purge(inv.Pattern)
+		return
+	case invalidateBlacklist:
+		err = blacklist.Load()
+	case invalidateAliases:
+		err = aliases.Load()
+	case invalidateFeatures:
+		err = features.Load()
+	case invalidateItems:
+		if viper.GetString("itemsFile") != "" {
+			err = items.Load(viper.GetString("itemsFile"))
+		}
+	default:
+		fmt.Printf("Cluster invalidation: unknown kind %s\n", inv.Kind)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Cluster invalidation of %s: %v\n", inv.Kind, err)
+	}
+	respCache.Purge("")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// priceConfidence scores from 0 to 1 how much a price result can be trusted:
+// many orders, a tight spread between the lowest sell and highest buy price,
+// and recent data score high. window is the age in seconds looked at.
+func priceConfidence(r lib.APIStatsPricesItem, window int) float64 {
+	// 100 orders or more is as good as it gets
+	count := math.Min(1, math.Log10(1+float64(r.OrderCount))/2)
+
+	spread := 0.5
+	if r.SellPriceMin > 0 && r.BuyPriceMax > 0 {
+		spread = 1 - math.Max(0, math.Min(1, float64(r.SellPriceMin-r.BuyPriceMax)/float64(r.SellPriceMin)))
+	}
+
+	recency := 1.0
+	if window > 0 {
+		recency = 1 - math.Min(1, float64(r.DataAgeSeconds)/float64(window))
+	}
+
+	return math.Round((0.4*count+0.3*spread+0.3*recency)*100) / 100
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const maxContributionsWindow = 30 * 24 * time.Hour
+
+// apiHandleContributions counts the orders uploaded per day and location
+// over ?window= (default 7d, at most 30d). Orders aren't stored with their
+// uploader, so there is no per uploader breakdown.
+func apiHandleContributions(c echo.Context) error {
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxContributionsWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxContributionsWindow))
+	}
+
+	conn := dbFrom(c)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	result := []lib.APIContribution{}
+	// One query per day keeps the grouping portable across databases
+	for day := today.Add(-window + 24*time.Hour); !day.After(today); day = day.Add(24 * time.Hour) {
+		rows := []struct {
+			Location adslib.Location
+			Orders   int
+		}{}
+		if err := partitionPrune(conn.Table(adslib.NewModelMarketOrder().TableName()), day).
+			Select("location, count(*) as orders").
+			Where("created_at >= ? and created_at < ?", day, day.Add(24*time.Hour)).
+			Group("location").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			result = append(result, lib.APIContribution{
+				Date:     day.Format("2006-01-02"),
+				Location: r.Location.String(),
+				Orders:   r.Orders,
+			})
+		}
+	}
+
+	return renderJSON(c, http.StatusOK, result)
+}
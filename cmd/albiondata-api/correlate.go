@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// goldSeriesName stands for the gold price in ?a= and ?b=.
+const goldSeriesName = "GOLD"
+
+// apiHandleStatsCorrelate correlates the prices of ?a= and ?b= over ?window=
+// (default 7d, at most 30d). Both are averaged over the asked locations per
+// ?interval= (default 1h), only buckets where both have a price are used.
+func apiHandleStatsCorrelate(c echo.Context) error {
+	if len(c.QueryParam("a")) == 0 || len(c.QueryParam("b")) == 0 {
+		return c.String(http.StatusBadRequest, "a and b are required")
+	}
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxHistoryWindow))
+	}
+	interval, err := parseWindow(c.QueryParam("interval"), time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if interval < time.Hour {
+		return c.String(http.StatusBadRequest, "interval can't be less than 1h")
+	}
+
+	locs := queryLocations(c)
+	since := time.Now().Add(-window)
+	a, aID, err := correlationSeries(c, c.QueryParam("a"), locs, since, interval)
+	if err != nil {
+		return paramError(c, err)
+	}
+	b, bID, err := correlationSeries(c, c.QueryParam("b"), locs, since, interval)
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = []string{aID, bID}
+
+	result := lib.APICorrelation{
+		A:          aID,
+		B:          bID,
+		Window:     window.String(),
+		Timestamps: []int64{},
+		SeriesA:    []float64{},
+		SeriesB:    []float64{},
+	}
+	buckets := []int64{}
+	for t := range a {
+		if _, ok := b[t]; ok {
+			buckets = append(buckets, t)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	for _, t := range buckets {
+		result.Timestamps = append(result.Timestamps, t*1000)
+		result.SeriesA = append(result.SeriesA, a[t])
+		result.SeriesB = append(result.SeriesB, b[t])
+	}
+	result.Coefficient = pearson(result.SeriesA, result.SeriesB)
+
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// correlationSeries averages the prices of name per interval, keyed by the
+// unix time of the bucket. It returns the resolved item id as well.
+func correlationSeries(c echo.Context, name string, locs []adslib.Location, since time.Time, interval time.Duration) (map[int64]float64, string, error) {
+	sums := map[int64]float64{}
+	counts := map[int64]int{}
+	add := func(t time.Time, price float64) {
+		k := t.Truncate(interval).Unix()
+		sums[k] += price
+		counts[k]++
+	}
+
+	if strings.EqualFold(name, goldSeriesName) {
+		prices := []adslib.ModelGoldprices{}
+		if err := goldDBFrom(c).Where("timestamp >= ?", since).Find(&prices).Error; err != nil {
+			return nil, "", err
+		}
+		for _, p := range prices {
+			add(p.Timestamp, float64(p.Price))
+		}
+		name = goldSeriesName
+	} else {
+		if strings.ContainsAny(name, ",*") {
+			return nil, "", fmt.Errorf("Only a single item can be correlated: %s", name)
+		}
+		itemID, err := resolveItemName(c, name)
+		if err != nil {
+			return nil, "", err
+		}
+		stats := []adslib.ModelMarketStats{}
+		if err := statsDBFrom(c).Where("item_id = ? and location in (?) and timestamp >= ?", itemID, locs, since).Find(&stats).Error; err != nil {
+			return nil, "", err
+		}
+		for _, s := range stats {
+			add(s.Timestamp, s.PriceAvg)
+		}
+		name = itemID
+	}
+
+	series := map[int64]float64{}
+	for k, sum := range sums {
+		series[k] = sum / float64(counts[k])
+	}
+	return series, name, nil
+}
+
+// pearson is the correlation coefficient of a and b, nil when it is
+// undefined (fewer than two points or a constant series).
+func pearson(a, b []float64) *float64 {
+	if len(a) < 2 {
+		return nil
+	}
+	meanA, sdA := meanStddev(a)
+	meanB, sdB := meanStddev(b)
+	if sdA == 0 || sdB == 0 {
+		return nil
+	}
+	cov := 0.0
+	for i := range a {
+		cov += (a[i] - meanA) * (b[i] - meanB)
+	}
+	r := cov / float64(len(a)) / (sdA * sdB)
+	r = math.Max(-1, math.Min(1, r))
+	return &r
+}
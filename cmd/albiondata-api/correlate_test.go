@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want *float64
+	}{
+		{"same direction", []float64{1, 2, 3}, []float64{2, 4, 6}, float(1)},
+		{"opposite", []float64{1, 2, 3}, []float64{3, 2, 1}, float(-1)},
+		{"partial", []float64{1, 2, 3, 4}, []float64{1, 3, 2, 4}, float(0.8)},
+		{"one point", []float64{1}, []float64{2}, nil},
+		{"constant", []float64{1, 2, 3}, []float64{5, 5, 5}, nil},
+	}
+	for _, tt := range tests {
+		got := pearson(tt.a, tt.b)
+		if (got == nil) != (tt.want == nil) || got != nil && math.Abs(*got-*tt.want) > 1e-9 {
+			t.Errorf("%s: pearson = %v, want %v", tt.name, floats([]*float64{got}), floats([]*float64{tt.want}))
+		}
+	}
+}
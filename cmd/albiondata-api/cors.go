@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/middleware"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("cors.exposeHeaders", strings.Join([]string{
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After",
+		"X-Cache", "X-Item-Aliases", "X-Upstream-Rows", "X-Request-Class",
+		"Deprecation", "Sunset", "Link", "Warning",
+	}, ","))
+	viper.SetDefault("cors.maxAge", 86400)
+}
+
+// corsConfig allows every origin like the default CORS middleware, and
+// lets browsers read the cors.exposeHeaders response headers and cache
+// preflights for cors.maxAge seconds.
+func corsConfig() middleware.CORSConfig {
+	config := middleware.DefaultCORSConfig
+	config.ExposeHeaders = []string{}
+	for _, h := range strings.Split(viper.GetString("cors.exposeHeaders"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			config.ExposeHeaders = append(config.ExposeHeaders, h)
+		}
+	}
+	config.MaxAge = viper.GetInt("cors.maxAge")
+	return config
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const currentOrdersTable = "current_orders"
+
+// currentOrder is the latest row of an order, kept in current_orders by the
+// currentOrders job.
+type currentOrder struct {
+	adslib.ModelMarketOrder
+}
+
+func (currentOrder) TableName() string {
+	return currentOrdersTable
+}
+
+// currentOrdersState remembers how far the job got and whether the table can
+// be read from.
+type currentOrdersState struct {
+	sync.Mutex
+	synced    time.Time
+	ready     bool
+	lastProbe time.Time
+}
+
+var currentOrders = &currentOrdersState{}
+
+// Ready reports whether current_orders holds data. Instances not running
+// the job look at the table at most once a minute until it does.
+func (co *currentOrdersState) Ready() bool {
+	if !viper.GetBool("jobs.currentOrders.enabled") {
+		return false
+	}
+	co.Lock()
+	defer co.Unlock()
+	if co.ready || time.Since(co.lastProbe) < time.Minute {
+		return co.ready
+	}
+	co.lastProbe = time.Now()
+	if db.HasTable(currentOrdersTable) {
+		count := 0
+		db.Table(currentOrdersTable).Limit(1).Count(&count)
+		co.ready = count > 0
+	}
+	return co.ready
+}
+
+// ordersTable is the table prices are read from, current_orders once it is
+// filled, the full history for other databases and ?at= requests.
+func ordersTable(c echo.Context) string {
+	if databaseName(c) == primaryDatabase && len(c.QueryParam("at")) == 0 && currentOrders.Ready() && features.Enabled("currentOrders") {
+		return currentOrdersTable
+	}
+	return adslib.NewModelMarketOrder().TableName()
+}
+
+// orderColumns lists the columns of market_orders, in struct order.
+func orderColumns() []string {
+	columns := []string{}
+	for _, f := range db.NewScope(&adslib.ModelMarketOrder{}).Fields() {
+		if f.IsNormal && !f.IsIgnored {
+			columns = append(columns, f.DBName)
+		}
+	}
+	return columns
+}
+
+// jobCurrentOrders copies the latest row of every order updated since the
+// last run into current_orders, and drops rows older than the prices
+// maxAge. The first run fills the table from the whole maxAge window.
+func jobCurrentOrders(e *echo.Echo) error {
+	if err := db.AutoMigrate(&currentOrder{}).Error; err != nil {
+		return err
+	}
+	db.Model(&currentOrder{}).AddUniqueIndex("idx_current_orders_albion_id", "albion_id")
+	db.Model(&currentOrder{}).AddIndex("idx_current_orders_item_location", "item_id", "location")
+
+	now := time.Now()
+	oldest := now.Add(-time.Duration(ageSetting("prices", "maxAge")) * time.Second)
+
+	currentOrders.Lock()
+	since := currentOrders.synced
+	currentOrders.Unlock()
+	if since.IsZero() {
+		// After a restart carry on from the newest row already copied
+		var newest struct{ UpdatedAt time.Time }
+		if err := db.Table(currentOrdersTable).Select("max(updated_at) as updated_at").Scan(&newest).Error; err == nil && !newest.UpdatedAt.IsZero() {
+			since = newest.UpdatedAt
+		}
+	}
+	if since.Before(oldest) {
+		since = oldest
+	}
+
+	history := adslib.NewModelMarketOrder().TableName()
+	columns := strings.Join(orderColumns(), ", ")
+	tx := db.Begin()
+	steps := []*gorm.DB{
+		tx.Exec("DELETE FROM "+currentOrdersTable+" WHERE albion_id IN (SELECT albion_id FROM "+history+" WHERE updated_at > ? AND updated_at <= ?)", since, now),
+		tx.Exec("INSERT INTO "+currentOrdersTable+" ("+columns+") SELECT "+columns+" FROM "+history+
+			" WHERE id IN (SELECT max(id) FROM "+history+" WHERE updated_at > ? AND updated_at <= ? GROUP BY albion_id)", since, now),
+		tx.Exec("DELETE FROM "+currentOrdersTable+" WHERE updated_at < ?", oldest),
+	}
+	for _, step := range steps {
+		if step.Error != nil {
+			tx.Rollback()
+			return step.Error
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	currentOrders.Lock()
+	currentOrders.synced = now
+	currentOrders.ready = true
+	currentOrders.Unlock()
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// Name of the profile built from dbType and dbURI
+const primaryDatabase = "primary"
+
+// Extra database profiles from the databases config key, by name
+var databases = map[string]*gorm.DB{}
+
+// databaseProfile is an entry of the databases config key, the TLS options
+// default to the dbTLS* flags.
+type databaseProfile struct {
+	DBType          string
+	DBURI           string
+	DBTLS           string
+	DBTLSCA         string
+	DBTLSServerName string
+}
+
+// openDatabases connects to every profile under the databases config key.
+func openDatabases() error {
+	for name := range viper.GetStringMap("databases") {
+		conn, err := openProfile(name)
+		if err != nil {
+			return err
+		}
+		databases[name] = conn
+	}
+
+	for _, table := range []string{"stats", "gold"} {
+		if name := viper.GetString(table + "Database"); name != "" {
+			if _, ok := databases[name]; !ok {
+				return fmt.Errorf("%sDatabase: unknown database %s", table, name)
+			}
+		}
+	}
+	return nil
+}
+
+// openProfile connects to the profile databases.<name>.
+func openProfile(name string) (*gorm.DB, error) {
+	var p databaseProfile
+	if err := viper.UnmarshalKey("databases."+name, &p); err != nil {
+		return nil, fmt.Errorf("database %s: %v", name, err)
+	}
+	if p.DBType == "" {
+		p.DBType = viper.GetString("dbType")
+	}
+
+	opts := globalDBTLS()
+	if p.DBTLS != "" {
+		opts = dbTLSOptions{Mode: p.DBTLS, CA: p.DBTLSCA, ServerName: p.DBTLSServerName}
+	}
+	dsn, err := dsnWithTLS(p.DBType, secrets.withPassword(p.DBType, p.DBURI), opts, name)
+	if err != nil {
+		return nil, fmt.Errorf("database %s: %v", name, err)
+	}
+
+	fmt.Printf("Connecting to %s database: %s\n", name, p.DBType)
+	conn, err := gorm.Open(p.DBType, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database %s: %v", name, err)
+	}
+	conn.LogMode(true)
+	registerQueryHooks(conn)
+	return conn, nil
+}
+
+// tableDB returns the connection holding market_stats ("stats") or
+// gold_prices ("gold"), the primary database unless statsDatabase or
+// goldDatabase point to one of the databases profiles.
+func tableDB(table string) *gorm.DB {
+	if conn, ok := databases[viper.GetString(table+"Database")]; ok {
+		return conn
+	}
+	return db
+}
+
+// How long pools replaced by reconnectDatabases stay open for the requests
+// already using them
+const reconnectGrace = time.Minute
+
+// reconnectDatabases opens the primary pool, the expensive request pool and
+// the databases profiles again, with the current password, and swaps them
+// in. The old pools are closed after reconnectGrace. Nothing is swapped if
+// one of them can't connect.
+func reconnectDatabases() error {
+	dsn, err := primaryDSN()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Connecting to database: %s\n", viper.GetString("dbType"))
+	primary, err := gorm.Open(viper.GetString("dbType"), dsn)
+	if err != nil {
+		return err
+	}
+	primary.LogMode(true)
+	registerQueryHooks(primary)
+	sizePrimaryPool(primary)
+	opened := []*gorm.DB{primary}
+
+	var expensive *gorm.DB
+	if expensiveDB != nil {
+		if expensive, err = openExpensivePool(); err != nil {
+			closeAll(opened)
+			return err
+		}
+		opened = append(opened, expensive)
+	}
+	profiles := map[string]*gorm.DB{}
+	for name := range databases {
+		conn, err := openProfile(name)
+		if err != nil {
+			closeAll(opened)
+			return err
+		}
+		profiles[name] = conn
+		opened = append(opened, conn)
+	}
+
+	old := []*gorm.DB{db}
+	if expensiveDB != nil {
+		old = append(old, expensiveDB)
+	}
+	for _, conn := range databases {
+		old = append(old, conn)
+	}
+	db, expensiveDB, databases = primary, expensive, profiles
+	time.AfterFunc(reconnectGrace, func() { closeAll(old) })
+	return nil
+}
+
+func closeAll(conns []*gorm.DB) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func closeDatabases() {
+	for _, conn := range databases {
+		conn.Close()
+	}
+}
+
+// databaseName returns the profile selected with ?db=, the primary database
+// when it isn't given.
+func databaseName(c echo.Context) string {
+	if name := c.QueryParam("db"); name != "" {
+		return name
+	}
+	return primaryDatabase
+}
+
+// selectDatabase rejects requests asking for an unknown ?db= profile.
+func selectDatabase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := databaseName(c)
+		if name == primaryDatabase {
+			return next(c)
+		}
+		conn, ok := databases[name]
+		if !ok {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Unknown database %s", name))
+		}
+		c.Set("db", conn)
+		return next(c)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+)
+
+func TestReconnectDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The password picks the file, so the new pool shows it was used
+	viper.Set("dbType", "sqlite3")
+	viper.Set("dbURI", filepath.Join(dir, passwordPlaceholder+".db"))
+	defer viper.Set("dbType", "")
+	defer viper.Set("dbURI", "")
+	defer func(orig string) { secrets.dbPassword = orig }(secrets.dbPassword)
+	secrets.dbPassword = "old"
+
+	defer func(orig *gorm.DB) { db = orig }(db)
+	if err := openDB(); err != nil {
+		t.Fatal(err)
+	}
+	old := db
+	if err := old.Exec("CREATE TABLE marker (id integer)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	secrets.dbPassword = "new"
+	if err := reconnectDatabases(); err != nil {
+		t.Fatal(err)
+	}
+	if db == old {
+		t.Fatal("db wasn't swapped")
+	}
+	if db.HasTable("marker") {
+		t.Error("new pool opened the database of the old password")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.db")); err != nil {
+		t.Errorf("new pool: %v", err)
+	}
+	// Requests that got the old pool can still finish
+	if !old.HasTable("marker") {
+		t.Error("old pool closed before reconnectGrace")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+// dbTLSOptions are the dbTLS* flags, database profiles can set their own.
+type dbTLSOptions struct {
+	Mode       string // disable, require, verify-ca or verify-full
+	CA         string
+	ServerName string
+}
+
+func globalDBTLS() dbTLSOptions {
+	return dbTLSOptions{
+		Mode:       viper.GetString("dbTLS"),
+		CA:         viper.GetString("dbTLSCA"),
+		ServerName: viper.GetString("dbTLSServerName"),
+	}
+}
+
+// dsnWithTLS adds the TLS options to a connection string in the syntax of
+// the driver, name tells apart the TLS configs registered for MySQL.
+func dsnWithTLS(dbType string, dsn string, opts dbTLSOptions, name string) (string, error) {
+	if opts.Mode == "" || opts.Mode == "disable" {
+		if opts.CA != "" || opts.ServerName != "" {
+			return "", fmt.Errorf("dbTLSCA and dbTLSServerName need dbTLS")
+		}
+		return dsn, nil
+	}
+	switch opts.Mode {
+	case "require", "verify-ca", "verify-full":
+	default:
+		return "", fmt.Errorf("dbTLS must be one of disable, require, verify-ca, verify-full")
+	}
+	if opts.Mode != "require" && opts.CA == "" {
+		return "", fmt.Errorf("dbTLS %s needs dbTLSCA", opts.Mode)
+	}
+
+	switch dbType {
+	case "mysql":
+		return mysqlDSNWithTLS(dsn, opts, name)
+	case "postgres", "postgresql":
+		if opts.ServerName != "" {
+			return "", fmt.Errorf("dbTLSServerName isn't supported by the postgres driver, it verifies the host of dbURI")
+		}
+		params := map[string]string{"sslmode": opts.Mode}
+		if opts.CA != "" {
+			params["sslrootcert"] = opts.CA
+		}
+		return addPostgresParams(dsn, params), nil
+	default:
+		return "", fmt.Errorf("dbTLS isn't supported for %s", dbType)
+	}
+}
+
+// mysqlDSNWithTLS registers a tls.Config with the driver and points the DSN
+// to it.
+func mysqlDSNWithTLS(dsn string, opts dbTLSOptions, name string) (string, error) {
+	config := &tls.Config{ServerName: opts.ServerName, MinVersion: tls.VersionTLS12}
+	if opts.Mode == "require" {
+		config.InsecureSkipVerify = true
+	}
+	if opts.CA != "" {
+		pem, err := ioutil.ReadFile(opts.CA)
+		if err != nil {
+			return "", fmt.Errorf("dbTLSCA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("dbTLSCA: no certificates in %s", opts.CA)
+		}
+		config.RootCAs = pool
+	}
+	if opts.Mode == "verify-ca" {
+		// Check the chain but not the host name, which crypto/tls can only
+		// skip together
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			certs := []*x509.Certificate{}
+			for _, b := range raw {
+				cert, err := x509.ParseCertificate(b)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return fmt.Errorf("the database sent no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{Roots: config.RootCAs, Intermediates: intermediates})
+			return err
+		}
+	}
+
+	key := "albiondata-" + name
+	if err := mysql.RegisterTLSConfig(key, config); err != nil {
+		return "", err
+	}
+	return addMySQLParams(dsn, map[string]string{"tls": key}), nil
+}
+
+// addMySQLParams appends params to a MySQL DSN, user:pass@tcp(host)/db?params.
+func addMySQLParams(dsn string, params map[string]string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	for k, v := range params {
+		dsn += sep + k + "=" + url.QueryEscape(v)
+		sep = "&"
+	}
+	return dsn
+}
+
+// addPostgresParams adds params to the query of a postgres:// URL, or to a
+// key=value connection string.
+func addPostgresParams(dsn string, params map[string]string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			query := u.Query()
+			for k, v := range params {
+				query.Set(k, v)
+			}
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	for k, v := range params {
+		dsn += " " + k + "='" + strings.Replace(v, "'", "\\'", -1) + "'"
+	}
+	return strings.TrimSpace(dsn)
+}
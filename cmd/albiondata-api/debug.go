@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Setting name carrying the *queryStats of a request through gorm scopes
+const queryStatsKey = "albiondata:query_stats"
+
+// queryStats counts the SQL work done for one request.
+type queryStats struct {
+	sync.Mutex
+	queries        int
+	dbTime         time.Duration
+	wildcardHits   int
+	wildcardMisses int
+}
+
+func (qs *queryStats) addQuery(d time.Duration) {
+	qs.Lock()
+	defer qs.Unlock()
+	qs.queries++
+	qs.dbTime += d
+}
+
+func (qs *queryStats) addWildcard(hit bool) {
+	qs.Lock()
+	defer qs.Unlock()
+	if hit {
+		qs.wildcardHits++
+	} else {
+		qs.wildcardMisses++
+	}
+}
+
+func (qs *queryStats) Debug(c echo.Context) *lib.APIDebug {
+	qs.Lock()
+	defer qs.Unlock()
+	return &lib.APIDebug{
+		Queries:        qs.queries,
+		DBTimeMs:       float64(qs.dbTime.Nanoseconds()) / float64(time.Millisecond),
+		Cache:          strings.ToLower(c.Response().Header().Get("X-Cache")),
+		WildcardHits:   qs.wildcardHits,
+		WildcardMisses: qs.wildcardMisses,
+	}
+}
+
+// registerQueryHooks hooks into gorm to time every query, feeding the per
+// request stats and query plans of connections returned by dbFrom and the
+// slow query log.
+func registerQueryHooks(db *gorm.DB) {
+	before := func(scope *gorm.Scope) {
+		scope.InstanceSet("albiondata:query_start", time.Now())
+	}
+	after := func(scope *gorm.Scope) {
+		start, ok := scope.InstanceGet("albiondata:query_start")
+		if !ok {
+			return
+		}
+		d := time.Since(start.(time.Time))
+
+		if v, ok := scope.Get(queryStatsKey); ok {
+			v.(*queryStats).addQuery(d)
+		}
+		if v, ok := scope.Get(queryExplainKey); ok {
+			v.(*queryExplain).Capture(scope)
+		}
+		if t := slowQueryThreshold(); t > 0 && d >= t {
+			route, _ := scope.Get(queryRouteKey)
+			r, _ := route.(string)
+			slowQueries.Record(scope.SQL, scope.SQLVars, d, r)
+		}
+	}
+
+	db.Callback().Query().Before("gorm:query").Register("albiondata:stats_before", before)
+	db.Callback().Query().After("gorm:query").Register("albiondata:stats_after", after)
+	db.Callback().RowQuery().Before("gorm:row_query").Register("albiondata:stats_before", before)
+	db.Callback().RowQuery().After("gorm:row_query").Register("albiondata:stats_after", after)
+}
+
+// isAdmin reports if the request carries the admin key.
+func isAdmin(c echo.Context) bool {
+	if adminCertRequired() && !hasClientCert(c) {
+		return false
+	}
+	if jwtAdmin(c) {
+		return true
+	}
+	// The certificate is all there is to check
+	if adminCertRequired() && viper.GetString("adminKey") == "" && !jwtConfigured() {
+		return true
+	}
+	key := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	return viper.GetString("adminKey") != "" && subtle.ConstantTimeCompare([]byte(key), []byte(viper.GetString("adminKey"))) == 1
+}
+
+func requestStats(c echo.Context) *queryStats {
+	qs, _ := c.Get("queryStats").(*queryStats)
+	return qs
+}
+
+// dbFrom returns the connection handlers should query market_orders through.
+func dbFrom(c echo.Context) *gorm.DB {
+	return requestDB(c, db)
+}
+
+// statsDBFrom is dbFrom for market_stats.
+func statsDBFrom(c echo.Context) *gorm.DB {
+	return requestDB(c, tableDB("stats"))
+}
+
+// goldDBFrom is dbFrom for gold_prices.
+func goldDBFrom(c echo.Context) *gorm.DB {
+	return requestDB(c, tableDB("gold"))
+}
+
+// requestDB ties base to the request, a ?db= profile replaces it.
+// Expensive requests to the primary database use its own pool.
+func requestDB(c echo.Context, base *gorm.DB) *gorm.DB {
+	if selected, ok := c.Get("db").(*gorm.DB); ok {
+		base = selected
+	} else if base == db && expensiveDB != nil && requestClass(c) == classExpensive {
+		base = expensiveDB
+	}
+	conn := base.Set(queryRouteKey, c.Request().Method+" "+c.Path())
+	if qs := requestStats(c); qs != nil {
+		conn = conn.Set(queryStatsKey, qs)
+	}
+	if qe := requestExplain(c); qe != nil {
+		conn = conn.Set(queryExplainKey, qe)
+	}
+	return conn
+}
+
+// debugHeaders adds X-Debug-* headers with query counts and timings for
+// admin requests sending X-Debug: 1.
+func debugHeaders(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Header.Get("X-Debug") != "1" || !isAdmin(c) {
+			return next(c)
+		}
+
+		qs := &queryStats{}
+		c.Set("queryStats", qs)
+		c.Response().Before(func() {
+			d := qs.Debug(c)
+			h := c.Response().Header()
+			h.Set("X-Debug-Queries", strconv.Itoa(d.Queries))
+			h.Set("X-Debug-DB-Time-Ms", strconv.FormatFloat(d.DBTimeMs, 'f', 2, 64))
+			h.Set("X-Debug-Wildcard-Cache", strconv.Itoa(d.WildcardHits)+" hits, "+strconv.Itoa(d.WildcardMisses)+" misses")
+		})
+		return next(c)
+	}
+}
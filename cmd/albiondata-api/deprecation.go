@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// deprecation is an entry of the deprecations config list.
+type deprecation struct {
+	Route   string
+	Since   string
+	Sunset  string
+	Link    string
+	Message string
+
+	since  time.Time
+	sunset time.Time
+}
+
+var deprecations = map[string]deprecation{}
+
+// loadDeprecations reads the deprecations config list.
+func loadDeprecations() error {
+	list := []deprecation{}
+	if err := viper.UnmarshalKey("deprecations", &list); err != nil {
+		return fmt.Errorf("deprecations: %v", err)
+	}
+	for _, d := range list {
+		var err error
+		if d.Since != "" {
+			if d.since, err = time.Parse(time.RFC3339, d.Since); err != nil {
+				return fmt.Errorf("deprecations: %s since: %v", d.Route, err)
+			}
+		}
+		if d.Sunset != "" {
+			if d.sunset, err = time.Parse(time.RFC3339, d.Sunset); err != nil {
+				return fmt.Errorf("deprecations: %s sunset: %v", d.Route, err)
+			}
+		}
+		deprecations[d.Route] = d
+	}
+	return nil
+}
+
+// deprecationHeaders announces deprecated routes with Deprecation, Sunset,
+// Link and Warning headers, and a warning in the meta block.
+func deprecationHeaders(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d, ok := deprecations[c.Path()]
+		if !ok {
+			return next(c)
+		}
+
+		h := c.Response().Header()
+		if d.since.IsZero() {
+			h.Set("Deprecation", "true")
+		} else {
+			h.Set("Deprecation", d.since.UTC().Format(http.TimeFormat))
+		}
+		if !d.sunset.IsZero() {
+			h.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Link != "" {
+			h.Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Link))
+		}
+		if d.Message != "" {
+			h.Set("Warning", fmt.Sprintf("299 - %q", d.Message))
+			requestMeta(c).Warning = d.Message
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Downsampling methods of ?downsample=
+const (
+	downsampleLTTB = "lttb"
+	downsampleAvg  = "avg"
+)
+
+// downsample shrinks a series to at most n points. Largest-Triangle-Three-
+// Buckets keeps the shape of the average price line, avg merges runs of
+// points into one (lowest min, highest max, mean avg and indicators). n
+// below 3 or a shorter series leave it as is. Indicators are computed on
+// the full series before, LTTB keeps their values at the points it picks.
+func downsample(data *lib.APIStatsChartsLocationResponse, n int, method string) {
+	if n < 3 || len(data.Timestamps) <= n {
+		return
+	}
+	if method == downsampleAvg {
+		averageBuckets(data, n)
+		return
+	}
+	pickPoints(data, lttbIndices(data.Timestamps, data.PricesAvg, n))
+}
+
+// lttbIndices returns the indices of the n points LTTB keeps, the first and
+// last are always kept.
+func lttbIndices(xs []int64, ys []float64, n int) []int {
+	indices := make([]int, 0, n)
+	indices = append(indices, 0)
+
+	bucketSize := float64(len(xs)-2) / float64(n-2)
+	a := 0
+	for i := 0; i < n-2; i++ {
+		// Average of the next bucket, the third corner of the triangle
+		nextStart := int(float64(i+1)*bucketSize) + 1
+		nextEnd := int(float64(i+2)*bucketSize) + 1
+		if nextEnd > len(xs) {
+			nextEnd = len(xs)
+		}
+		avgX, avgY := 0.0, 0.0
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += float64(xs[j])
+			avgY += ys[j]
+		}
+		if count := float64(nextEnd - nextStart); count > 0 {
+			avgX /= count
+			avgY /= count
+		}
+
+		// Point of this bucket making the largest triangle with a and the average
+		start := int(float64(i)*bucketSize) + 1
+		end := int(float64(i+1)*bucketSize) + 1
+		best, bestArea := start, -1.0
+		for j := start; j < end; j++ {
+			area := math.Abs((float64(xs[a])-avgX)*(ys[j]-ys[a]) - (float64(xs[a])-float64(xs[j]))*(avgY-ys[a]))
+			if area > bestArea {
+				best, bestArea = j, area
+			}
+		}
+		indices = append(indices, best)
+		a = best
+	}
+	return append(indices, len(xs)-1)
+}
+
+// pickPoints keeps the points at indices of every series of data.
+func pickPoints(data *lib.APIStatsChartsLocationResponse, indices []int) {
+	res := lib.APIStatsChartsLocationResponse{
+		Timestamps:    make([]int64, 0, len(indices)),
+		PricesMin:     make([]int64, 0, len(indices)),
+		PricesMax:     make([]int64, 0, len(indices)),
+		PricesAvg:     make([]float64, 0, len(indices)),
+		Indicators:    downsampledIndicators(data.Indicators, len(indices)),
+		ChangePercent: data.ChangePercent,
+	}
+	for _, i := range indices {
+		res.Timestamps = append(res.Timestamps, data.Timestamps[i])
+		res.PricesMin = append(res.PricesMin, data.PricesMin[i])
+		res.PricesMax = append(res.PricesMax, data.PricesMax[i])
+		res.PricesAvg = append(res.PricesAvg, data.PricesAvg[i])
+		for name, values := range data.Indicators {
+			res.Indicators[name] = append(res.Indicators[name], values[i])
+		}
+	}
+	*data = res
+}
+
+// downsampledIndicators returns empty series of n points for the
+// indicators, nil without any.
+func downsampledIndicators(indicators map[string][]*float64, n int) map[string][]*float64 {
+	if indicators == nil {
+		return nil
+	}
+	res := map[string][]*float64{}
+	for name := range indicators {
+		res[name] = make([]*float64, 0, n)
+	}
+	return res
+}
+
+// averageBuckets merges the points of data into n buckets of about the same
+// size, each stamped with the time of its first point.
+func averageBuckets(data *lib.APIStatsChartsLocationResponse, n int) {
+	res := lib.APIStatsChartsLocationResponse{
+		Timestamps:    make([]int64, 0, n),
+		PricesMin:     make([]int64, 0, n),
+		PricesMax:     make([]int64, 0, n),
+		PricesAvg:     make([]float64, 0, n),
+		Indicators:    downsampledIndicators(data.Indicators, n),
+		ChangePercent: data.ChangePercent,
+	}
+	size := float64(len(data.Timestamps)) / float64(n)
+	for i := 0; i < n; i++ {
+		start, end := int(float64(i)*size), int(float64(i+1)*size)
+		if i == n-1 {
+			end = len(data.Timestamps)
+		}
+		min, max, sum := data.PricesMin[start], data.PricesMax[start], 0.0
+		for j := start; j < end; j++ {
+			if data.PricesMin[j] < min {
+				min = data.PricesMin[j]
+			}
+			if data.PricesMax[j] > max {
+				max = data.PricesMax[j]
+			}
+			sum += data.PricesAvg[j]
+		}
+		res.Timestamps = append(res.Timestamps, data.Timestamps[start])
+		res.PricesMin = append(res.PricesMin, min)
+		res.PricesMax = append(res.PricesMax, max)
+		res.PricesAvg = append(res.PricesAvg, sum/float64(end-start))
+		for name, values := range data.Indicators {
+			res.Indicators[name] = append(res.Indicators[name], meanOf(values[start:end]))
+		}
+	}
+	*data = res
+}
+
+// meanOf averages the values that are set, nil when none is.
+func meanOf(values []*float64) *float64 {
+	sum, count := 0.0, 0
+	for _, v := range values {
+		if v != nil {
+			sum += *v
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	mean := sum / float64(count)
+	return &mean
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tikz/albiondata-api/lib"
+)
+
+func TestLTTBIndices(t *testing.T) {
+	xs := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	tests := []struct {
+		name string
+		ys   []float64
+		n    int
+		want []int
+	}{
+		{"spike", []float64{0, 0, 0, 0, 100, 0, 0, 0, 0, 0}, 3, []int{0, 4, 9}},
+		{"peak and dip", []float64{0, 0, 10, 0, 0, 0, -10, 0, 0, 0}, 4, []int{0, 2, 6, 9}},
+		{"every point", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 10, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+	for _, tt := range tests {
+		if got := lttbIndices(xs, tt.ys, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lttbIndices = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func chartData() lib.APIStatsChartsLocationResponse {
+	return lib.APIStatsChartsLocationResponse{
+		Timestamps: []int64{10, 20, 30, 40, 50, 60},
+		PricesMin:  []int64{5, 3, 8, 7, 2, 9},
+		PricesMax:  []int64{10, 12, 15, 11, 20, 14},
+		PricesAvg:  []float64{1, 3, 5, 7, 9, 11},
+		Indicators: map[string][]*float64{"sma2": {nil, float(4), nil, nil, float(6), float(8)}},
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	tests := []struct {
+		name           string
+		n              int
+		method         string
+		wantTimestamps []int64
+		wantMin        []int64
+		wantMax        []int64
+		wantAvg        []float64
+		wantIndicator  []interface{}
+	}{
+		{"below 3 points", 2, downsampleLTTB,
+			[]int64{10, 20, 30, 40, 50, 60}, []int64{5, 3, 8, 7, 2, 9}, []int64{10, 12, 15, 11, 20, 14},
+			[]float64{1, 3, 5, 7, 9, 11}, []interface{}{nil, 4.0, nil, nil, 6.0, 8.0}},
+		{"not shorter", 6, downsampleAvg,
+			[]int64{10, 20, 30, 40, 50, 60}, []int64{5, 3, 8, 7, 2, 9}, []int64{10, 12, 15, 11, 20, 14},
+			[]float64{1, 3, 5, 7, 9, 11}, []interface{}{nil, 4.0, nil, nil, 6.0, 8.0}},
+		{"avg", 3, downsampleAvg,
+			[]int64{10, 30, 50}, []int64{3, 7, 2}, []int64{12, 15, 20},
+			[]float64{2, 6, 10}, []interface{}{4.0, nil, 7.0}},
+		{"lttb keeps the picked points", 3, downsampleLTTB,
+			[]int64{10, 20, 60}, []int64{5, 3, 9}, []int64{10, 12, 14},
+			[]float64{1, 3, 11}, []interface{}{nil, 4.0, 8.0}},
+	}
+	for _, tt := range tests {
+		data := chartData()
+		downsample(&data, tt.n, tt.method)
+		if !reflect.DeepEqual(data.Timestamps, tt.wantTimestamps) {
+			t.Errorf("%s: timestamps = %v, want %v", tt.name, data.Timestamps, tt.wantTimestamps)
+		}
+		if !reflect.DeepEqual(data.PricesMin, tt.wantMin) {
+			t.Errorf("%s: prices_min = %v, want %v", tt.name, data.PricesMin, tt.wantMin)
+		}
+		if !reflect.DeepEqual(data.PricesMax, tt.wantMax) {
+			t.Errorf("%s: prices_max = %v, want %v", tt.name, data.PricesMax, tt.wantMax)
+		}
+		if !reflect.DeepEqual(data.PricesAvg, tt.wantAvg) {
+			t.Errorf("%s: prices_avg = %v, want %v", tt.name, data.PricesAvg, tt.wantAvg)
+		}
+		if got := floats(data.Indicators["sma2"]); !reflect.DeepEqual(got, tt.wantIndicator) {
+			t.Errorf("%s: sma2 = %v, want %v", tt.name, got, tt.wantIndicator)
+		}
+	}
+}
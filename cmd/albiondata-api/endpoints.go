@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Endpoints that can be switched off per deployment with
+// endpoints.<name>: false, everything is enabled by default.
+var endpointNames = []string{
+	"prices",        // /api/v1/stats/prices/:item
+	"charts",        // /api/v1/stats/charts/:item
+	"view",          // /api/v1/stats/view/:item
+	"gold",          // /api/v1/stats/gold
+	"changes",       // /api/v1/changes
+	"priceChanges",  // /api/v1/stats/changes/:item
+	"metrics",       // /api/v1/stats/metrics/:item
+	"correlate",     // /api/v1/stats/correlate
+	"calculators",   // /api/v1/calc/*
+	"transport",     // /api/v1/stats/transport
+	"spread",        // /api/v1/stats/spread/:item
+	"sparkline",     // /api/v1/stats/sparkline
+	"matrix",        // /api/v1/stats/matrix/:item
+	"reports",       // /api/v1/reports/:period, /feeds/alerts.atom
+	"contributions", // /api/v1/stats/contributions
+	"export",        // /api/v1/export/*
+	"grafana",       // /api/grafana, a Grafana SimpleJSON datasource
+	"icons",         // /api/v1/items/:id/icon
+	"market",        // /api/v1/market/:item
+	"localization",  // /api/v1/meta/localization
+	"wildcards",     // * in item ids
+	"v2",            // /api/v2/*, along with the endpoint's own toggle
+}
+
+func init() {
+	for _, name := range endpointNames {
+		viper.SetDefault("endpoints."+name, true)
+	}
+}
+
+func endpointEnabled(name string) bool {
+	return viper.GetBool("endpoints." + name)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Setting name carrying the *queryExplain of a request through gorm scopes
+const queryExplainKey = "albiondata:explain"
+
+// queryExplain collects the plans of the queries run for one request.
+type queryExplain struct {
+	sync.Mutex
+	plans []lib.APIQueryPlan
+}
+
+// Capture runs EXPLAIN for a statement that just ran on scope. It goes
+// straight to the connection, so the EXPLAIN itself isn't hooked.
+func (qe *queryExplain) Capture(scope *gorm.Scope) {
+	plan := lib.APIQueryPlan{SQL: scope.SQL, Params: queryParams(scope.SQLVars), Columns: []string{}, Rows: [][]string{}}
+
+	explain := "EXPLAIN "
+	if scope.Dialect().GetName() == "sqlite3" {
+		explain = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := scope.DB().CommonDB().Query(explain+scope.SQL, scope.SQLVars...)
+	if err == nil {
+		plan.Columns, plan.Rows, err = readPlan(rows)
+	}
+	if err != nil {
+		plan.Error = err.Error()
+	}
+
+	qe.Lock()
+	defer qe.Unlock()
+	qe.plans = append(qe.plans, plan)
+}
+
+func (qe *queryExplain) Plans() []lib.APIQueryPlan {
+	qe.Lock()
+	defer qe.Unlock()
+	return append([]lib.APIQueryPlan{}, qe.plans...)
+}
+
+// readPlan reads the rows of an EXPLAIN as strings, the columns differ
+// from one database to the next.
+func readPlan(rows *sql.Rows) ([]string, [][]string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := [][]string{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		row := make([]string, len(columns))
+		for i, v := range values {
+			row[i] = v.String
+		}
+		result = append(result, row)
+	}
+	return columns, result, rows.Err()
+}
+
+func requestExplain(c echo.Context) *queryExplain {
+	qe, _ := c.Get("queryExplain").(*queryExplain)
+	return qe
+}
+
+// explainQueries answers admin requests with ?explain=true with the plans
+// of the queries the request ran, instead of its response.
+func explainQueries(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.QueryParam("explain") != "true" {
+			return next(c)
+		}
+		if !isAdmin(c) {
+			return c.String(http.StatusForbidden, "explain needs the admin key")
+		}
+
+		qe := &queryExplain{}
+		c.Set("queryExplain", qe)
+		orig := c.Response().Writer
+		buf := &bufferWriter{header: http.Header{}, status: http.StatusOK}
+		c.Response().Writer = buf
+		err := next(c)
+		c.Response().Writer = orig
+		c.Response().Committed = false
+
+		result := lib.APIExplain{Status: buf.status, Queries: qe.Plans()}
+		if err != nil {
+			result.Error = fmt.Sprint(err)
+		}
+		return renderJSON(c, http.StatusOK, result)
+	}
+}
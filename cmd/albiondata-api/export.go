@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func init() {
+	viper.SetDefault("export.statsDays", 7)
+	viper.SetDefault("export.snapshotTTL", 300)
+}
+
+// requireAPIKey rejects anonymous requests.
+func requireAPIKey(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := c.Get("apiKey").(apiKey); !ok {
+			return c.String(http.StatusUnauthorized, "An API key is required")
+		}
+		return next(c)
+	}
+}
+
+// exportOrdersSince is the oldest updated_at of exported orders, the export
+// default age back from now.
+func exportOrdersSince() time.Time {
+	return time.Now().Add(-time.Duration(ageSetting("export", "defaultAge")) * time.Second)
+}
+
+// exportStatsSince is the oldest timestamp of exported stats.
+func exportStatsSince() time.Time {
+	return time.Now().AddDate(0, 0, -viper.GetInt("export.statsDays"))
+}
+
+// sqliteSnapshot is the last SQLite file built for /api/v1/export/sqlite,
+// rebuilt once it's older than export.snapshotTTL seconds.
+type sqliteSnapshot struct {
+	sync.Mutex
+	path  string
+	built time.Time
+}
+
+var snapshot = &sqliteSnapshot{}
+
+// Open returns the current snapshot file, building a new one if needed.
+func (s *sqliteSnapshot) Open() (*os.File, time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	ttl := time.Duration(viper.GetInt("export.snapshotTTL")) * time.Second
+	if s.path == "" || time.Since(s.built) > ttl {
+		path, err := buildSQLiteSnapshot()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		// Requests still streaming the old file keep their handle
+		if s.path != "" {
+			os.Remove(s.path)
+		}
+		s.path, s.built = path, time.Now()
+	}
+
+	f, err := os.Open(s.path)
+	return f, s.built, err
+}
+
+// buildSQLiteSnapshot writes the orders of the export default age and the
+// stats of the last export.statsDays days to a new SQLite file.
+func buildSQLiteSnapshot() (string, error) {
+	tmp, err := ioutil.TempFile("", "albiondata-export-*.sqlite")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+
+	out, err := gorm.Open("sqlite3", tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	defer out.Close()
+
+	if err := copySnapshotTables(out); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func copySnapshotTables(out *gorm.DB) error {
+	if err := out.AutoMigrate(&adslib.ModelMarketOrder{}, &adslib.ModelMarketStats{}).Error; err != nil {
+		return err
+	}
+
+	tx := out.Begin()
+	rows, err := plausibleOrders(db.Model(&adslib.ModelMarketOrder{})).Where("updated_at >= ?", exportOrdersSince()).Rows()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var order adslib.ModelMarketOrder
+		if err := db.ScanRows(rows, &order); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Create(&order).Error; err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	rows.Close()
+
+	stats := tableDB("stats")
+	rows, err = stats.Model(&adslib.ModelMarketStats{}).Where("timestamp >= ?", exportStatsSince()).Rows()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stat adslib.ModelMarketStats
+		if err := stats.ScanRows(rows, &stat); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Create(&stat).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// apiHandleExportSQLite streams a SQLite copy of the recent orders and
+// stats for offline tools to sync from.
+func apiHandleExportSQLite(c echo.Context) error {
+	f, built, err := snapshot.Open()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	defer f.Close()
+
+	name := fmt.Sprintf("albiondata-%s.sqlite", built.UTC().Format("20060102T150405Z"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	c.Response().Header().Set(echo.HeaderLastModified, built.UTC().Format(http.TimeFormat))
+	return c.Stream(http.StatusOK, "application/vnd.sqlite3", f)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// featureDefaults lists the feature flags and their state when neither the
+// config (features.<name>) nor an admin override sets them.
+var featureDefaults = map[string]bool{
+	// Serve /api/v2, the route still needs endpoints.v2
+	"v2Responses": true,
+	// Leave orders outside of priceBounds out of prices and stats
+	"priceBounds": true,
+	// Read prices from current_orders once the currentOrders job filled it
+	"currentOrders": true,
+	// Keep cached responses of cacheGzipRoutes gzip compressed
+	"cacheGzip": true,
+}
+
+func init() {
+	for name, on := range featureDefaults {
+		viper.SetDefault("features."+name, on)
+	}
+}
+
+// How long overrides set on another replica take to apply here
+const featureRefreshInterval = 30 * time.Second
+
+// featureOverride is a flag set at runtime through /admin/features, it wins
+// over the config until it's deleted.
+type featureOverride struct {
+	Name      string `gorm:"primary_key"`
+	Enabled   bool
+	UpdatedAt time.Time
+}
+
+func (featureOverride) TableName() string {
+	return "feature_flags"
+}
+
+type featureFlags struct {
+	sync.RWMutex
+	overrides map[string]featureOverride
+	loaded    time.Time
+}
+
+var features = &featureFlags{overrides: map[string]featureOverride{}}
+
+// Load creates the feature_flags table if needed and reads the overrides.
+func (ff *featureFlags) Load() error {
+	ff.Lock()
+	ff.loaded = time.Now()
+	ff.Unlock()
+
+	if err := db.AutoMigrate(&featureOverride{}).Error; err != nil {
+		return err
+	}
+	list := []featureOverride{}
+	if err := db.Find(&list).Error; err != nil {
+		return err
+	}
+	overrides := make(map[string]featureOverride, len(list))
+	for _, o := range list {
+		overrides[o.Name] = o
+	}
+
+	ff.Lock()
+	defer ff.Unlock()
+	ff.overrides = overrides
+	return nil
+}
+
+// refresh reloads the overrides every featureRefreshInterval, so replicas
+// sharing the database follow a change without a restart.
+func (ff *featureFlags) refresh() {
+	ff.RLock()
+	stale := time.Since(ff.loaded) > featureRefreshInterval
+	ff.RUnlock()
+	if !stale || db == nil {
+		return
+	}
+	if err := ff.Load(); err != nil {
+		fmt.Printf("Can't load feature flags: %v\n", err)
+	}
+}
+
+// Enabled reports whether the feature is on, an override wins over the
+// config.
+func (ff *featureFlags) Enabled(name string) bool {
+	ff.refresh()
+	ff.RLock()
+	o, ok := ff.overrides[name]
+	ff.RUnlock()
+	if ok {
+		return o.Enabled
+	}
+	return viper.GetBool("features." + name)
+}
+
+func (ff *featureFlags) Status() []lib.APIFeatureFlag {
+	ff.refresh()
+	ff.RLock()
+	defer ff.RUnlock()
+
+	result := []lib.APIFeatureFlag{}
+	for name := range featureDefaults {
+		f := lib.APIFeatureFlag{
+			Name:       name,
+			Configured: viper.GetBool("features." + name),
+		}
+		f.Enabled = f.Configured
+		if o, ok := ff.overrides[name]; ok {
+			f.Enabled = o.Enabled
+			f.Overridden = true
+			f.UpdatedAt = lib.Timestamp(o.UpdatedAt)
+		}
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// requireFeature is a route middleware answering 404 while the feature is
+// off, the routes are registered either way so it can be switched at
+// runtime.
+func requireFeature(name string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !features.Enabled(name) {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	}
+}
+
+func adminHandleFeatures(c echo.Context) error {
+	return renderJSON(c, http.StatusOK, features.Status())
+}
+
+// adminHandleFeatureSet overrides the flag with the "enabled" of the JSON
+// body and purges the response cache so it applies right away.
+func adminHandleFeatureSet(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := featureDefaults[name]; !ok {
+		return c.String(http.StatusNotFound, "Unknown feature")
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if req.Enabled == nil {
+		return c.String(http.StatusBadRequest, "enabled is required")
+	}
+
+	if err := db.Save(&featureOverride{Name: name, Enabled: *req.Enabled}).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if err := features.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateFeatures, "")
+	return renderJSON(c, http.StatusOK, features.Status())
+}
+
+// adminHandleFeatureReset drops the override, the config applies again.
+func adminHandleFeatureReset(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := featureDefaults[name]; !ok {
+		return c.String(http.StatusNotFound, "Unknown feature")
+	}
+	if err := db.Where("name = ?", name).Delete(&featureOverride{}).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if err := features.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateFeatures, "")
+	return renderJSON(c, http.StatusOK, features.Status())
+}
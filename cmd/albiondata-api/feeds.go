@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Link    []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// feedEvents are the significant price events of a report: the biggest
+// moves, then the new highs and lows.
+func feedEvents(r lib.APIMarketReport, base string) []atomEntry {
+	updated := time.Time(r.Generated).UTC().Format(time.RFC3339)
+	day := time.Time(r.To).UTC().Format("2006-01-02")
+	link := []atomLink{{Href: base + "/api/v1/reports/" + r.Period + "?format=html", Type: "text/html"}}
+	entries := []atomEntry{}
+
+	for _, m := range r.Movers {
+		direction := "up"
+		if m.Change < 0 {
+			direction = "down"
+		}
+		entries = append(entries, atomEntry{
+			ID:      fmt.Sprintf("urn:albiondata:%s:%s:move:%s:%s", r.Period, day, m.ItemID, m.City),
+			Title:   fmt.Sprintf("%s %s %.1f%% in %s", m.ItemID, direction, math.Abs(*m.ChangePercent), m.City),
+			Updated: updated,
+			Link:    link,
+			Summary: fmt.Sprintf("The average price of %s in %s moved from %.0f to %.0f between %s and %s.", m.ItemID, m.City, m.PriceFrom, m.PriceTo, m.From, m.To),
+		})
+	}
+	for _, part := range []struct {
+		kind    string
+		records []lib.APIPriceRecord
+	}{{"high", r.Highs}, {"low", r.Lows}} {
+		for _, rec := range part.records {
+			entries = append(entries, atomEntry{
+				ID:      fmt.Sprintf("urn:albiondata:%s:%s:%s:%s:%s", r.Period, day, part.kind, rec.ItemID, rec.City),
+				Title:   fmt.Sprintf("New %s for %s in %s: %d", part.kind, rec.ItemID, rec.City, rec.Price),
+				Updated: updated,
+				Link:    link,
+				Summary: fmt.Sprintf("%s in %s reached %d, the %s of the 30 days before was %d.", rec.ItemID, rec.City, rec.Price, part.kind, rec.Previous),
+			})
+		}
+	}
+	return entries
+}
+
+// apiHandleAlertsFeed serves the price events of the latest daily and
+// weekly reports as an Atom feed, for feed readers.
+func apiHandleAlertsFeed(c echo.Context) error {
+	base := c.Scheme() + "://" + c.Request().Host
+	feed := atomFeed{
+		NS:     atomNS,
+		ID:     base + "/feeds/alerts.atom",
+		Title:  "Albion market price events",
+		Link:   []atomLink{{Href: base + "/feeds/alerts.atom", Rel: "self", Type: "application/atom+xml"}},
+		Author: atomAuthor{Name: "albiondata-api"},
+	}
+
+	updated := time.Time{}
+	for _, name := range []string{"daily", "weekly"} {
+		// Only the daily report is built on request, the weekly one shows
+		// up once the job or /api/v1/reports/weekly made it
+		report, ok := reports.Get(name)
+		if name == "daily" {
+			var err error
+			if report, err = currentReport(name); err != nil {
+				return err
+			}
+		} else if !ok {
+			continue
+		}
+		feed.Entries = append(feed.Entries, feedEvents(report, base)...)
+		if time.Time(report.Generated).After(updated) {
+			updated = time.Time(report.Generated)
+		}
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	b, err := xml.MarshalIndent(feed, "", "\t")
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
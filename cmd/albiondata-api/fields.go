@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// fieldSet is the set of JSON fields a client asked for with ?fields=.
+// A nil fieldSet means every field.
+type fieldSet map[string]bool
+
+// parseFields reads the fields query param and validates the names against
+// the json tags of sample.
+func parseFields(c echo.Context, sample interface{}) (fieldSet, error) {
+	if len(c.QueryParam("fields")) == 0 {
+		return nil, nil
+	}
+
+	known := jsonFieldNames(reflect.TypeOf(sample))
+	fields := fieldSet{}
+	for _, f := range strings.Split(c.QueryParam("fields"), ",") {
+		f = strings.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		if !known[f] {
+			return nil, fmt.Errorf("Unknown field: %s", f)
+		}
+		fields[f] = true
+	}
+	return fields, nil
+}
+
+// Has reports whether any of names was requested.
+func (fs fieldSet) Has(names ...string) bool {
+	if fs == nil {
+		return true
+	}
+	for _, n := range names {
+		if fs[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// With returns a copy of the set with names added.
+func (fs fieldSet) With(names ...string) fieldSet {
+	if fs == nil {
+		return nil
+	}
+	res := fieldSet{}
+	for n := range fs {
+		res[n] = true
+	}
+	for _, n := range names {
+		res[n] = true
+	}
+	return res
+}
+
+// sparseFields converts a slice of structs into a slice of maps only holding
+// the requested fields.
+func sparseFields(slice interface{}, fields fieldSet) []map[string]interface{} {
+	v := reflect.ValueOf(slice)
+	result := make([]map[string]interface{}, 0, v.Len())
+
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		m := map[string]interface{}{}
+		for j := 0; j < item.NumField(); j++ {
+			name := jsonFieldName(item.Type().Field(j))
+			if fields.Has(name) {
+				m[name] = item.Field(j).Interface()
+			}
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		names[jsonFieldName(t.Field(i))] = true
+	}
+	return names
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if len(name) == 0 {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const (
+	defaultSparklinePoints = 24
+	maxSparklinePoints     = 500
+)
+
+// apiHandleStatsGoldSummary returns the latest gold price with its 24h and
+// 7d change, min/max over ?window= (default 7d) and a ?points= long
+// sparkline of that window.
+func apiHandleStatsGoldSummary(c echo.Context) error {
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	points := defaultSparklinePoints
+	if p, err := strconv.Atoi(c.QueryParam("points")); err == nil && p > 0 && p <= maxSparklinePoints {
+		points = p
+	}
+
+	now := time.Now()
+	from := now.Add(-window)
+	if weekAgo := now.Add(-7 * 24 * time.Hour); weekAgo.Before(from) {
+		from = weekAgo
+	}
+
+	prices := []adslib.ModelGoldprices{}
+	if err := goldDBFrom(c).Where("timestamp >= ?", from).Order("timestamp asc").Find(&prices).Error; err != nil {
+		return err
+	}
+
+	result := lib.APIGoldSummary{Window: window.String(), Sparkline: []int64{}}
+	if len(prices) == 0 {
+		return renderJSON(c, http.StatusOK, result)
+	}
+
+	last := prices[len(prices)-1]
+	result.Price = int64(last.Price)
+	result.Timestamp = lib.Timestamp(last.Timestamp)
+	result.Change24h, result.Change24hPercent = goldChange(prices, now.Add(-24*time.Hour))
+	result.Change7d, result.Change7dPercent = goldChange(prices, now.Add(-7*24*time.Hour))
+
+	inWindow := []int64{}
+	for _, p := range prices {
+		if p.Timestamp.Before(now.Add(-window)) {
+			continue
+		}
+		price := int64(p.Price)
+		inWindow = append(inWindow, price)
+		if result.WindowMin == 0 || price < result.WindowMin {
+			result.WindowMin = price
+		}
+		if price > result.WindowMax {
+			result.WindowMax = price
+		}
+	}
+	result.Sparkline = sparkline(inWindow, points)
+
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// goldChange compares the latest price with the last one at or before t.
+func goldChange(prices []adslib.ModelGoldprices, t time.Time) (int64, float64) {
+	var then *adslib.ModelGoldprices
+	for i := range prices {
+		if prices[i].Timestamp.After(t) {
+			break
+		}
+		then = &prices[i]
+	}
+	if then == nil {
+		then = &prices[0]
+	}
+
+	change := int64(prices[len(prices)-1].Price - then.Price)
+	if then.Price == 0 {
+		return change, 0
+	}
+	return change, float64(change) / float64(then.Price) * 100
+}
+
+// sparkline averages values into at most n evenly sized buckets.
+func sparkline(values []int64, n int) []int64 {
+	if len(values) <= n {
+		return values
+	}
+	res := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		from, to := i*len(values)/n, (i+1)*len(values)/n
+		sum := int64(0)
+		for _, v := range values[from:to] {
+			sum += v
+		}
+		res = append(res, sum/int64(to-from))
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// Grafana SimpleJSON datasource: targets are "gold" or an item id, which
+// gives the average price series of every location.
+
+const (
+	grafanaGoldTarget    = "gold"
+	maxGrafanaSearchHits = 100
+)
+
+// grafanaHandleTest answers the datasource connection test.
+func grafanaHandleTest(c echo.Context) error {
+	return c.String(http.StatusOK, "OK")
+}
+
+// grafanaHandleSearch lists the targets containing the search term.
+func grafanaHandleSearch(c echo.Context) error {
+	var req lib.APIGrafanaSearchRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	targets := []string{}
+	if strings.Contains(grafanaGoldTarget, strings.ToLower(req.Target)) {
+		targets = append(targets, grafanaGoldTarget)
+	}
+	itemIDs := []string{}
+	if err := statsDBFrom(c).Model(&adslib.ModelMarketStats{}).
+		Where("item_id LIKE ? ESCAPE '!'", "%"+likeEscaper.Replace(strings.ToUpper(req.Target))+"%").
+		Group("item_id").Limit(maxGrafanaSearchHits).Pluck("item_id", &itemIDs).Error; err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, append(targets, itemIDs...))
+}
+
+// grafanaHandleQuery returns the series of every target over the range.
+func grafanaHandleQuery(c echo.Context) error {
+	var req lib.APIGrafanaQueryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	from, err := time.Parse(time.RFC3339, req.Range.From)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "range.from must be a RFC3339 timestamp")
+	}
+	to, err := time.Parse(time.RFC3339, req.Range.To)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "range.to must be a RFC3339 timestamp")
+	}
+
+	result := []lib.APIGrafanaSeries{}
+	for _, t := range req.Targets {
+		if t.Target == "" {
+			continue
+		}
+
+		if t.Target == grafanaGoldTarget {
+			prices := []adslib.ModelGoldprices{}
+			if err := goldDBFrom(c).Where("timestamp >= ? and timestamp <= ?", from, to).Order("timestamp asc").Find(&prices).Error; err != nil {
+				return err
+			}
+			series := lib.APIGrafanaSeries{Target: grafanaGoldTarget, Datapoints: [][2]float64{}}
+			for _, p := range prices {
+				series.Datapoints = append(series.Datapoints, [2]float64{float64(p.Price), float64(p.Timestamp.Unix() * 1000)})
+			}
+			result = append(result, series)
+			continue
+		}
+
+		stats := []adslib.ModelMarketStats{}
+		if err := statsDBFrom(c).Where("item_id = ? and timestamp >= ? and timestamp <= ?", t.Target, from, to).Order("timestamp asc").Find(&stats).Error; err != nil {
+			return err
+		}
+		byLocation := map[adslib.Location]*lib.APIGrafanaSeries{}
+		order := []adslib.Location{}
+		for _, s := range stats {
+			series, ok := byLocation[s.Location]
+			if !ok {
+				series = &lib.APIGrafanaSeries{Target: t.Target + " " + s.Location.String(), Datapoints: [][2]float64{}}
+				byLocation[s.Location] = series
+				order = append(order, s.Location)
+			}
+			series.Datapoints = append(series.Datapoints, [2]float64{s.PriceAvg, float64(s.Timestamp.Unix() * 1000)})
+		}
+		for _, l := range order {
+			result = append(result, *byLocation[l])
+		}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// grafanaHandleAnnotations has no annotations to offer.
+func grafanaHandleAnnotations(c echo.Context) error {
+	return c.JSON(http.StatusOK, []interface{}{})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// enrichmentHook lets operators add their own data, like tags or computed
+// fields, without changing the handlers. Register one with registerHook from
+// an extra file of this package, or configure hooks.exec to run a program.
+type enrichmentHook interface {
+	// IngestOrder is called with every order before it is stored, it may
+	// change the order or drop it by returning false.
+	IngestOrder(o *adslib.ModelMarketOrder) (bool, error)
+	// Response is called with the value about to be rendered for route (the
+	// echo path, like /api/v1/stats/prices/:item) and returns what is
+	// rendered instead.
+	Response(route string, v interface{}) (interface{}, error)
+}
+
+var hooks = []enrichmentHook{}
+
+func registerHook(h enrichmentHook) {
+	hooks = append(hooks, h)
+}
+
+func init() {
+	viper.SetDefault("hooks.exec.timeout", 5)
+}
+
+// loadExecHook registers the hooks.exec program, if there is one.
+func loadExecHook() {
+	if args := strings.Fields(viper.GetString("hooks.exec.command")); len(args) > 0 {
+		h := &execHook{
+			args:    args,
+			routes:  map[string]bool{},
+			ingest:  viper.GetBool("hooks.exec.ingest"),
+			timeout: time.Duration(viper.GetInt("hooks.exec.timeout")) * time.Second,
+		}
+		for _, r := range strings.Split(viper.GetString("hooks.exec.routes"), ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				h.routes[r] = true
+			}
+		}
+		registerHook(h)
+	}
+}
+
+// ingestOrder runs the hooks on an order about to be stored. A failing hook
+// is logged and leaves the order as it is.
+func ingestOrder(o *adslib.ModelMarketOrder) bool {
+	for _, h := range hooks {
+		keep, err := h.IngestOrder(o)
+		if err != nil {
+			fmt.Printf("Ingest hook: %v\n", err)
+			continue
+		}
+		if !keep {
+			return false
+		}
+	}
+	return true
+}
+
+// enrichResponse runs the hooks on a response value. A failing hook is
+// logged and its input is rendered.
+func enrichResponse(c echo.Context, v interface{}) interface{} {
+	for _, h := range hooks {
+		enriched, err := h.Response(c.Path(), v)
+		if err != nil {
+			fmt.Printf("Response hook: %v\n", err)
+			continue
+		}
+		v = enriched
+	}
+	return v
+}
+
+// execHook runs a program for every hooked value, it gets
+// {"kind": "order"|"response", "route": ..., "data": ...} on stdin and
+// writes the replacement data to stdout, null drops an order.
+type execHook struct {
+	args    []string
+	routes  map[string]bool
+	ingest  bool
+	timeout time.Duration
+}
+
+type execHookInput struct {
+	Kind  string      `json:"kind"`
+	Route string      `json:"route,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+func (h *execHook) run(in execHookInput) ([]byte, error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.args[0], h.args[1:]...)
+	cmd.Stdin = bytes.NewReader(b)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v %s", h.args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (h *execHook) IngestOrder(o *adslib.ModelMarketOrder) (bool, error) {
+	if !h.ingest {
+		return true, nil
+	}
+	out, err := h.run(execHookInput{Kind: "order", Data: o})
+	if err != nil {
+		return true, err
+	}
+	if string(bytes.TrimSpace(out)) == "null" {
+		return false, nil
+	}
+	changed := *o
+	if err := json.Unmarshal(out, &changed); err != nil {
+		return true, fmt.Errorf("%s: %v", h.args[0], err)
+	}
+	*o = changed
+	return true, nil
+}
+
+func (h *execHook) Response(route string, v interface{}) (interface{}, error) {
+	if len(h.routes) > 0 && !h.routes[route] {
+		return v, nil
+	}
+	out, err := h.run(execHookInput{Kind: "response", Route: route, Data: v})
+	if err != nil {
+		return v, err
+	}
+	if !json.Valid(out) {
+		return v, fmt.Errorf("%s didn't write JSON", h.args[0])
+	}
+	return json.RawMessage(out), nil
+}
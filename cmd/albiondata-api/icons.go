@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultIconSize = 217
+	maxIconSize     = 217
+	iconTimeout     = 15 * time.Second
+)
+
+// Icons are fetched and kept in these sizes only, a requested size is
+// rounded up to the next one
+var iconSizes = []int{32, 64, 128, maxIconSize}
+
+var validIconID = regexp.MustCompile(`^[A-Za-z0-9_@]+$`)
+
+// Concurrent requests for the same missing icon fetch it once
+var iconFetches singleflight.Group
+
+// Only one trim of iconCacheDir runs at a time
+var iconTrim sync.Mutex
+
+func iconCacheDir() string {
+	if dir := viper.GetString("iconCacheDir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "albiondata-icons")
+}
+
+// knownIconID reports whether id is an item, by itemsFile when it is loaded
+// and otherwise by the stats, made up ids aren't asked from the render service.
+func knownIconID(id string) bool {
+	if items.Len() > 0 {
+		_, ok := items.Get(id)
+		return ok
+	}
+	ids := []string{}
+	tableDB("stats").Model(&adslib.ModelMarketStats{}).Where("item_id = ?", id).Limit(1).Pluck("item_id", &ids)
+	return len(ids) > 0
+}
+
+// apiHandleItemIcon serves the render of an item at ?size= (up to 217,
+// rounded up to one of iconSizes) and ?quality=, fetched once from
+// iconRenderURL and kept in iconCacheDir.
+func apiHandleItemIcon(c echo.Context) error {
+	id := c.Param("id")
+	if !validIconID.MatchString(id) {
+		return c.String(http.StatusBadRequest, "Invalid item id")
+	}
+	size := defaultIconSize
+	if len(c.QueryParam("size")) > 0 {
+		s, err := strconv.Atoi(c.QueryParam("size"))
+		if err != nil || s < 1 || s > maxIconSize {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("size must be between 1 and %d", maxIconSize))
+		}
+		for _, size = range iconSizes {
+			if size >= s {
+				break
+			}
+		}
+	}
+	quality, err := parseQuality(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if quality == 0 {
+		quality = minQuality
+	}
+
+	file := filepath.Join(iconCacheDir(), fmt.Sprintf("%s_%d_q%d.png", id, size, quality))
+	if _, err := os.Stat(file); err != nil {
+		if !knownIconID(id) {
+			return c.String(http.StatusNotFound, "Unknown item id")
+		}
+		_, err, _ := iconFetches.Do(file, func() (interface{}, error) {
+			if err := fetchIcon(id, size, quality, file); err != nil {
+				return nil, err
+			}
+			return nil, trimIconCache(filepath.Dir(file), viper.GetInt("iconCacheMaxFiles"))
+		})
+		if err != nil {
+			return c.String(http.StatusBadGateway, err.Error())
+		}
+	} else {
+		// Served icons count as recently used for trimIconCache
+		now := time.Now()
+		os.Chtimes(file, now, now)
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	return c.File(file)
+}
+
+func fetchIcon(id string, size int, quality int, file string) error {
+	url := fmt.Sprintf("%s/%s.png?size=%d&quality=%d", viper.GetString("iconRenderURL"), id, size, quality)
+	client := &http.Client{Timeout: iconTimeout}
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Render service answered %s", res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// Write next to the target and rename, readers never see a partial file
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// trimIconCache removes the least recently used icons of dir until at most
+// max are left, 0 keeps all of them.
+func trimIconCache(dir string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	iconTrim.Lock()
+	defer iconTrim.Unlock()
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	icons := []os.FileInfo{}
+	for _, fi := range infos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".png") {
+			icons = append(icons, fi)
+		}
+	}
+	if len(icons) <= max {
+		return nil
+	}
+	sort.Slice(icons, func(i, j int) bool { return icons[i].ModTime().Before(icons[j].ModTime()) })
+	for _, fi := range icons[:len(icons)-max] {
+		os.Remove(filepath.Join(dir, fi.Name()))
+	}
+	return nil
+}
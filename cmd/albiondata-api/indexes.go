@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/cobra"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// requiredIndex is an index the queries of the API rely on. An existing
+// index starting with the same columns, in order, counts as well.
+type requiredIndex struct {
+	table   string
+	name    string
+	columns []string
+}
+
+func requiredIndexes() []requiredIndex {
+	orders := adslib.NewModelMarketOrder().TableName()
+	stats := adslib.NewModelMarketStats().TableName()
+	gold := adslib.NewModelGoldprices().TableName()
+	return []requiredIndex{
+		// prices, per item, location and side of the market
+		{orders, "idx_api_orders_item_location_type", []string{"item_id", "location", "auction_type", "updated_at"}},
+		// age windows and retention
+		{orders, "idx_api_orders_updated", []string{"updated_at"}},
+		// freshness metrics
+		{orders, "idx_api_orders_location_updated", []string{"location", "updated_at"}},
+		// charts, history summaries and wildcards
+		{stats, "idx_api_stats_item_location_ts", []string{"item_id", "location", "timestamp"}},
+		{stats, "idx_api_stats_ts", []string{"timestamp"}},
+		{gold, "idx_api_gold_ts", []string{"timestamp"}},
+	}
+}
+
+var checkIndexesCmd = &cobra.Command{
+	Use:   "check-indexes",
+	Short: "Lists the indexes the API needs that are missing, --create adds them",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openDB(); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		defer db.Close()
+		create, _ := cmd.Flags().GetBool("create")
+		if err := checkIndexes(db, create); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	},
+}
+
+func init() {
+	checkIndexesCmd.Flags().Bool("create", false, "Create the missing indexes")
+	rootCmd.AddCommand(checkIndexesCmd)
+}
+
+// checkIndexes logs every required index that is missing and creates it
+// when create is set. Tables that don't exist are skipped.
+func checkIndexes(conn *gorm.DB, create bool) error {
+	missing := 0
+	for _, ri := range requiredIndexes() {
+		if !conn.HasTable(ri.table) {
+			continue
+		}
+		existing, err := tableIndexes(conn, ri.table)
+		if err != nil {
+			return fmt.Errorf("%s: %v", ri.table, err)
+		}
+		if indexCovered(existing, ri.columns) {
+			continue
+		}
+
+		missing++
+		fmt.Printf("Missing index on %s (%s)\n", ri.table, strings.Join(ri.columns, ", "))
+		if create {
+			fmt.Printf("Creating index %s\n", ri.name)
+			if err := conn.Table(ri.table).AddIndex(ri.name, ri.columns...).Error; err != nil {
+				return fmt.Errorf("%s: %v", ri.name, err)
+			}
+		}
+	}
+	if missing == 0 {
+		fmt.Printf("All indexes the API needs exist\n")
+	}
+	return nil
+}
+
+func indexCovered(existing map[string][]string, columns []string) bool {
+	for _, cols := range existing {
+		if len(cols) < len(columns) {
+			continue
+		}
+		covered := true
+		for i, c := range columns {
+			if !strings.EqualFold(cols[i], c) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return true
+		}
+	}
+	return false
+}
+
+// tableIndexes returns the columns of every index of table, in index order.
+func tableIndexes(conn *gorm.DB, table string) (map[string][]string, error) {
+	var query string
+	var args []interface{}
+	switch conn.Dialect().GetName() {
+	case "mysql":
+		query, args = "SELECT index_name, column_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? ORDER BY index_name, seq_in_index", []interface{}{table}
+	case "postgres":
+		query, args = "SELECT i.relname, a.attname FROM pg_index ix JOIN pg_class t ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid "+
+			"JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey) WHERE t.relname = ? ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)", []interface{}{table}
+	case "sqlite3":
+		query = "SELECT il.name, ii.name FROM pragma_index_list('" + table + "') il, pragma_index_info(il.name) ii ORDER BY il.name, ii.seqno"
+	default:
+		return nil, fmt.Errorf("index checks aren't supported on %s", conn.Dialect().GetName())
+	}
+
+	rows, err := conn.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	indexes := map[string][]string{}
+	for rows.Next() {
+		var name, column string
+		if err := rows.Scan(&name, &column); err != nil {
+			return nil, err
+		}
+		indexes[name] = append(indexes[name], column)
+	}
+	return indexes, rows.Err()
+}
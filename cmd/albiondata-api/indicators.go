@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// indicator is a rolling window series computed over prices_avg, like sma7
+// or ema30.
+type indicator struct {
+	name   string
+	kind   string
+	window int
+}
+
+// Longest window accepted, keeps the work per request bounded
+const maxIndicatorWindow = 365
+
+func parseIndicators(c echo.Context) ([]indicator, error) {
+	indicators := []indicator{}
+	if len(c.QueryParam("indicators")) == 0 {
+		return indicators, nil
+	}
+
+	for _, name := range strings.Split(c.QueryParam("indicators"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) < 4 || (name[:3] != "sma" && name[:3] != "ema") {
+			return nil, fmt.Errorf("Unknown indicator: %s, use smaN or emaN", name)
+		}
+		window, err := strconv.Atoi(name[3:])
+		if err != nil || window < 1 || window > maxIndicatorWindow {
+			return nil, fmt.Errorf("Indicator window must be between 1 and %d: %s", maxIndicatorWindow, name)
+		}
+		indicators = append(indicators, indicator{name: name, kind: name[:3], window: window})
+	}
+	return indicators, nil
+}
+
+// Compute returns one value per point, nil until the window is filled.
+func (ind indicator) Compute(values []float64) []*float64 {
+	res := make([]*float64, len(values))
+	switch ind.kind {
+	case "sma":
+		sum := 0.0
+		for i, v := range values {
+			sum += v
+			if i >= ind.window {
+				sum -= values[i-ind.window]
+			}
+			if i >= ind.window-1 {
+				avg := sum / float64(ind.window)
+				res[i] = &avg
+			}
+		}
+	case "ema":
+		// Seeded with the SMA of the first window
+		k := 2 / (float64(ind.window) + 1)
+		var ema float64
+		for i, v := range values {
+			switch {
+			case i < ind.window-1:
+				ema += v
+				continue
+			case i == ind.window-1:
+				ema = (ema + v) / float64(ind.window)
+			default:
+				ema = v*k + ema*(1-k)
+			}
+			val := ema
+			res[i] = &val
+		}
+	}
+	return res
+}
+
+// addIndicators fills in the requested indicators and the percent change
+// between the first and last average price of the series.
+func addIndicators(data *lib.APIStatsChartsLocationResponse, indicators []indicator) {
+	if len(indicators) == 0 {
+		return
+	}
+
+	data.Indicators = map[string][]*float64{}
+	for _, ind := range indicators {
+		data.Indicators[ind.name] = ind.Compute(data.PricesAvg)
+	}
+
+	if n := len(data.PricesAvg); n > 1 && data.PricesAvg[0] != 0 {
+		change := (data.PricesAvg[n-1] - data.PricesAvg[0]) / data.PricesAvg[0] * 100
+		data.ChangePercent = &change
+	}
+}
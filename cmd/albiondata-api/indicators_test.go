@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+// floats turns a series with nils into values to compare, nil stays nil.
+func floats(values []*float64) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		if v != nil {
+			res[i] = *v
+		}
+	}
+	return res
+}
+
+func TestParseIndicators(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []indicator
+		wantErr bool
+	}{
+		{"", []indicator{}, false},
+		{"sma7,EMA30", []indicator{{"sma7", "sma", 7}, {"ema30", "ema", 30}}, false},
+		{" sma1 ", []indicator{{"sma1", "sma", 1}}, false},
+		{"rsi14", nil, true},
+		{"sma", nil, true},
+		{"sma0", nil, true},
+		{"ema366", nil, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/?indicators="+url.QueryEscape(tt.query), nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		got, err := parseIndicators(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: indicators = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestIndicatorCompute(t *testing.T) {
+	values := []float64{2, 4, 6, 8, 20}
+	tests := []struct {
+		ind  indicator
+		want []interface{}
+	}{
+		{indicator{"sma1", "sma", 1}, []interface{}{2.0, 4.0, 6.0, 8.0, 20.0}},
+		{indicator{"sma3", "sma", 3}, []interface{}{nil, nil, 4.0, 6.0, 34.0 / 3}},
+		{indicator{"ema3", "ema", 3}, []interface{}{nil, nil, 4.0, 6.0, 13.0}},
+		{indicator{"sma6", "sma", 6}, []interface{}{nil, nil, nil, nil, nil}},
+		{indicator{"ema6", "ema", 6}, []interface{}{nil, nil, nil, nil, nil}},
+	}
+	for _, tt := range tests {
+		if got := floats(tt.ind.Compute(values)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.ind.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddIndicatorsChangePercent(t *testing.T) {
+	tests := []struct {
+		avg  []float64
+		want interface{}
+	}{
+		{[]float64{2, 4, 6, 8, 20}, 900.0},
+		{[]float64{10, 5}, -50.0},
+		{[]float64{0, 5}, nil},
+		{[]float64{5}, nil},
+	}
+	for _, tt := range tests {
+		data := lib.APIStatsChartsLocationResponse{PricesAvg: tt.avg}
+		addIndicators(&data, []indicator{{"sma1", "sma", 1}})
+		if got := floats([]*float64{data.ChangePercent})[0]; got != tt.want {
+			t.Errorf("%v: change_percent = %v, want %v", tt.avg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// int64Mode reads ?int64=, number (default) or string. JavaScript numbers
+// lose precision past 2^53, so clients can ask for int64 values as strings.
+func int64Mode(c echo.Context) (string, error) {
+	switch mode := c.QueryParam("int64"); mode {
+	case "", "number":
+		return "number", nil
+	case "string":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("int64 must be one of number, string")
+	}
+}
+
+// int64Strings turns v into maps and slices with every int64 written as a
+// string, keys and omitempty follow the json tags like encoding/json does.
+// Types with their own MarshalJSON (timestamps, hook output) stay as they are.
+func int64Strings(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return int64Strings(v.Elem())
+	case reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = int64Strings(v.Index(i))
+		}
+		return res
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		res := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			res[fmt.Sprint(k.Interface())] = int64Strings(v.MapIndex(k))
+		}
+		return res
+	case reflect.Struct:
+		res := map[string]interface{}{}
+		int64StructFields(v, res)
+		return res
+	default:
+		return v.Interface()
+	}
+}
+
+func int64StructFields(v reflect.Value, res map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fv := v.Field(i)
+
+		// Embedded structs without a name are flattened into the parent
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				int64StructFields(fv, res)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(tag, ",omitempty") && jsonEmpty(fv) {
+			continue
+		}
+		res[name] = int64Strings(fv)
+	}
+}
+
+// jsonEmpty is the omitempty rule of encoding/json.
+func jsonEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type fixedJSON struct{}
+
+func (fixedJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`"fixed"`), nil
+}
+
+type int64Inner struct {
+	X int64 `json:"x"`
+}
+
+type int64Sample struct {
+	int64Inner
+	A      int64            `json:"a"`
+	B      int              `json:"b"`
+	C      []int64          `json:"c"`
+	D      *int64           `json:"d,omitempty"`
+	E      map[string]int64 `json:"e,omitempty"`
+	F      fixedJSON        `json:"f"`
+	Skip   int64            `json:"-"`
+	NoTag  int64
+	hidden int64
+}
+
+func TestInt64Strings(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"int64", int64(1 << 60), `"1152921504606846976"`},
+		{"int", 5, `5`},
+		{"slice", []int64{1, 2}, `["1","2"]`},
+		{"nil slice", []int64(nil), `null`},
+		{"nil pointer", (*int64)(nil), `null`},
+		{"bytes", []byte("hi"), `"aGk="`},
+		{"map", map[int]int64{1: 9}, `{"1":"9"}`},
+		{"struct", int64Sample{int64Inner: int64Inner{X: 3}, A: 1, B: 2, Skip: 4, NoTag: 5, hidden: 6},
+			`{"NoTag":"5","a":"1","b":2,"c":null,"f":"fixed","x":"3"}`},
+		{"omitempty set", &int64Sample{D: new(int64), E: map[string]int64{"k": 7}},
+			`{"NoTag":"0","a":"0","b":0,"c":null,"d":"0","e":{"k":"7"},"f":"fixed","x":"0"}`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(int64Strings(reflect.ValueOf(tt.in)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(b) != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// itemMeta is an entry of the ao-bin-dumps formatted/items.json file.
+type itemMeta struct {
+	UniqueName               string            `json:"UniqueName"`
+	LocalizationNameVariable string            `json:"LocalizationNameVariable"`
+	LocalizedNames           map[string]string `json:"LocalizedNames"`
+}
+
+// Tier of the item, 0 for items without one.
+func (im itemMeta) Tier() int {
+	if len(im.UniqueName) > 2 && im.UniqueName[0] == 'T' && im.UniqueName[2] == '_' {
+		t, _ := strconv.Atoi(im.UniqueName[1:2])
+		return t
+	}
+	return 0
+}
+
+// itemStore holds the item metadata loaded from --itemsFile.
+type itemStore struct {
+	sync.RWMutex
+	byID map[string]itemMeta
+}
+
+var items = &itemStore{byID: map[string]itemMeta{}}
+
+// Item ids are upper case with underscores and an optional @enchantment,
+// display names never look like this
+var plausibleItemID = regexp.MustCompile(`^[A-Z0-9_]+(@[0-9]+)?$`)
+
+// Short language codes accepted by ?lang=, the full ones work as well
+var langCodes = map[string]string{
+	"en": "EN-US",
+	"de": "DE-DE",
+	"fr": "FR-FR",
+	"ru": "RU-RU",
+	"pl": "PL-PL",
+	"pt": "PT-BR",
+	"es": "ES-ES",
+	"it": "IT-IT",
+	"tr": "TR-TR",
+	"id": "ID-ID",
+	"zh": "ZH-CN",
+	"ko": "KO-KR",
+	"ja": "JA-JP",
+}
+
+func normalizeLang(lang string) string {
+	if full, ok := langCodes[strings.ToLower(lang)]; ok {
+		return full
+	}
+	return strings.ToUpper(lang)
+}
+
+// Load replaces the metadata with the contents of an items.json file.
+func (is *itemStore) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	list := []itemMeta{}
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	byID := make(map[string]itemMeta, len(list))
+	for _, im := range list {
+		byID[im.UniqueName] = im
+	}
+
+	is.Lock()
+	is.byID = byID
+	is.Unlock()
+	return nil
+}
+
+func (is *itemStore) Get(id string) (itemMeta, bool) {
+	is.RLock()
+	defer is.RUnlock()
+	im, ok := is.byID[id]
+	return im, ok
+}
+
+// Len returns the number of items loaded.
+func (is *itemStore) Len() int {
+	is.RLock()
+	defer is.RUnlock()
+	return len(is.byID)
+}
+
+// All returns every item, sorted by id.
+func (is *itemStore) All() []itemMeta {
+	is.RLock()
+	defer is.RUnlock()
+	list := make([]itemMeta, 0, len(is.byID))
+	for _, im := range is.byID {
+		list = append(list, im)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UniqueName < list[j].UniqueName })
+	return list
+}
+
+// FindByName returns the items whose name in lang equals name, or if there
+// is no exact match, contains it. tier 0 matches every tier.
+func (is *itemStore) FindByName(name string, lang string, tier int) []itemMeta {
+	is.RLock()
+	defer is.RUnlock()
+
+	name = strings.ToLower(name)
+	exact, partial := []itemMeta{}, []itemMeta{}
+	for _, im := range is.byID {
+		if tier > 0 && im.Tier() != tier {
+			continue
+		}
+		n := strings.ToLower(im.LocalizedNames[lang])
+		if n == name {
+			exact = append(exact, im)
+		} else if len(n) > 0 && strings.Contains(n, name) {
+			partial = append(partial, im)
+		}
+	}
+
+	found := exact
+	if len(found) == 0 {
+		found = partial
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].UniqueName < found[j].UniqueName })
+	return found
+}
+
+// ambiguousNameError is returned when a name matches several items.
+type ambiguousNameError struct {
+	name       string
+	candidates []lib.APIItemCandidate
+}
+
+func (e ambiguousNameError) Error() string {
+	return fmt.Sprintf("%s matches %d items", e.name, len(e.candidates))
+}
+
+// resolveItemName translates qID into the current id of the item, through
+// its display name and any aliases left by renames.
+func resolveItemName(c echo.Context, qID string) (string, error) {
+	id, err := resolveDisplayName(c, qID)
+	if err != nil {
+		return "", err
+	}
+	if current, ok := aliases.Resolve(id); ok {
+		noteAlias(c, id, current)
+		return current, nil
+	}
+	return id, nil
+}
+
+// resolveDisplayName translates a display name in ?lang= into an item id,
+// with ?tier= to narrow it down. Ids and params without lang pass through,
+// also ids missing from --itemsFile or without one loaded.
+func resolveDisplayName(c echo.Context, qID string) (string, error) {
+	if len(c.QueryParam("lang")) == 0 || plausibleItemID.MatchString(qID) {
+		return qID, nil
+	}
+	if _, ok := items.Get(qID); ok {
+		return qID, nil
+	}
+
+	lang := normalizeLang(c.QueryParam("lang"))
+	tier, _ := strconv.Atoi(c.QueryParam("tier"))
+	found := items.FindByName(qID, lang, tier)
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("No item named %s", qID)
+	case 1:
+		return found[0].UniqueName, nil
+	default:
+		candidates := []lib.APIItemCandidate{}
+		for _, im := range found {
+			candidates = append(candidates, lib.APIItemCandidate{ItemID: im.UniqueName, Name: im.LocalizedNames[lang]})
+		}
+		return "", ambiguousNameError{name: qID, candidates: candidates}
+	}
+}
+
+// paramError answers a request with invalid params, ambiguous item names
+// get 300 with the candidates to pick from.
+func paramError(c echo.Context, err error) error {
+	if amb, ok := err.(ambiguousNameError); ok {
+		return c.JSON(http.StatusMultipleChoices, lib.APIAmbiguousName{
+			Error:      amb.Error(),
+			Candidates: amb.candidates,
+		})
+	}
+	return c.String(http.StatusBadRequest, err.Error())
+}
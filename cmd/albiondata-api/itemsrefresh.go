@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("jobs.itemsRefresh.url", "https://raw.githubusercontent.com/broderickhyman/ao-bin-dumps/master/formatted/items.json")
+	rootCmd.AddCommand(refreshItemsCmd)
+}
+
+var refreshItemsCmd = &cobra.Command{
+	Use:   "refresh-items",
+	Short: "Downloads the latest items.json from ao-bin-dumps into itemsFile",
+	Run: func(cmd *cobra.Command, args []string) {
+		if path := viper.GetString("itemsFile"); path != "" {
+			if _, err := os.Stat(path); err == nil {
+				if err := items.Load(path); err != nil {
+					fmt.Printf("Can't load items: %v\n", err)
+				}
+			}
+		}
+		if _, err := refreshItems(); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+	},
+}
+
+// refreshItems downloads jobs.itemsRefresh.url, writes it to itemsFile and
+// loads it, logging how many items were added, removed or changed. It
+// reports whether anything changed.
+func refreshItems() (bool, error) {
+	path := viper.GetString("itemsFile")
+	if path == "" {
+		return false, fmt.Errorf("itemsFile must be set to refresh items")
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(viper.GetString("jobs.itemsRefresh.url"))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s returned %d", viper.GetString("jobs.itemsRefresh.url"), resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+
+	// A truncated or broken download must not replace a good file
+	list := []itemMeta{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&list); err != nil {
+		return false, fmt.Errorf("downloaded items: %v", err)
+	}
+	if len(list) == 0 {
+		return false, fmt.Errorf("downloaded items: the list is empty")
+	}
+
+	added, removed, changed := diffItems(items.All(), list)
+	if added+removed+changed == 0 {
+		fmt.Printf("Items are up to date\n")
+		return false, nil
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return false, err
+	}
+	if err := items.Load(path); err != nil {
+		return false, err
+	}
+	fmt.Printf("Items refreshed: %d added, %d removed, %d changed\n", added, removed, changed)
+	return true, nil
+}
+
+func diffItems(old, new []itemMeta) (added, removed, changed int) {
+	byID := make(map[string]itemMeta, len(old))
+	for _, im := range old {
+		byID[im.UniqueName] = im
+	}
+	for _, im := range new {
+		prev, ok := byID[im.UniqueName]
+		switch {
+		case !ok:
+			added++
+		case !reflect.DeepEqual(prev, im):
+			changed++
+		}
+		delete(byID, im.UniqueName)
+	}
+	return added, removed + len(byID), changed
+}
+
+// jobItemsRefresh keeps itemsFile current, new patch items get names
+// without a redeploy. Cached responses are dropped when anything changed.
+func jobItemsRefresh(e *echo.Echo) error {
+	changed, err := refreshItems()
+	if changed {
+		respCache.Purge("")
+		cluster.Broadcast(invalidateItems, "")
+	}
+	return err
+}
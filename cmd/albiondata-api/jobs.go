@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/robfig/cron"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// job is a background task run by the scheduler. Each job is configured
+// under jobs.<name>.enabled and jobs.<name>.schedule, the schedule uses the
+// robfig/cron syntax (seconds first, or descriptors like "@every 1h").
+type job struct {
+	name            string
+	defaultSchedule string
+	run             func(e *echo.Echo) error
+
+	sync.Mutex
+	schedule cron.Schedule
+	status   lib.APIJobStatus
+}
+
+var jobs = []*job{
+	{name: "statsAggregation", defaultSchedule: "0 5 * * * *", run: jobStatsAggregation},
+	{name: "cacheWarming", defaultSchedule: "@every 1m", run: jobCacheWarming},
+	{name: "retentionPruning", defaultSchedule: "@daily", run: jobRetentionPruning},
+	{name: "statsCompaction", defaultSchedule: "0 30 0 * * *", run: jobStatsCompaction},
+	{name: "snapshotExport", defaultSchedule: "@every 10m", run: jobSnapshotExport},
+	{name: "currentOrders", defaultSchedule: "@every 1m", run: jobCurrentOrders},
+	{name: "partitionMaintenance", defaultSchedule: "@daily", run: jobPartitionMaintenance},
+	{name: "itemsRefresh", defaultSchedule: "@daily", run: jobItemsRefresh},
+	{name: "telemetry", defaultSchedule: "@daily", run: jobTelemetry},
+	{name: "marketReport", defaultSchedule: "0 0 0 * * *", run: jobMarketReport},
+}
+
+func jobConfig(j *job, key string) string {
+	return "jobs." + j.name + "." + key
+}
+
+// startScheduler schedules every enabled job, it returns nil if there is
+// nothing to run.
+func startScheduler(e *echo.Echo) (*cron.Cron, error) {
+	sched := cron.New()
+	enabled := 0
+
+	for _, j := range jobs {
+		spec := viper.GetString(jobConfig(j, "schedule"))
+		if spec == "" {
+			spec = j.defaultSchedule
+		}
+		s, err := cron.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %v", j.name, err)
+		}
+
+		j.Lock()
+		j.schedule = s
+		j.status = lib.APIJobStatus{
+			Name:     j.name,
+			Enabled:  viper.GetBool(jobConfig(j, "enabled")),
+			Schedule: spec,
+		}
+		j.Unlock()
+
+		if viper.GetBool(jobConfig(j, "enabled")) {
+			j := j
+			sched.Schedule(s, cron.FuncJob(func() { j.execute(e) }))
+			enabled++
+		}
+	}
+
+	if enabled == 0 {
+		return nil, nil
+	}
+	sched.Start()
+	return sched, nil
+}
+
+func (j *job) execute(e *echo.Echo) {
+	if !leader.IsLeader() {
+		j.Lock()
+		j.status.Skipped++
+		j.Unlock()
+		return
+	}
+
+	start := time.Now()
+	err := j.run(e)
+
+	j.Lock()
+	defer j.Unlock()
+	j.status.Runs++
+	j.status.LastRun = lib.Timestamp(start)
+	j.status.LastDurationMs = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	j.status.LastError = ""
+	if err != nil {
+		j.status.LastError = err.Error()
+		fmt.Printf("Job %s failed: %v\n", j.name, err)
+	}
+}
+
+func (j *job) Status() lib.APIJobStatus {
+	j.Lock()
+	defer j.Unlock()
+	s := j.status
+	if s.Enabled && j.schedule != nil {
+		s.NextRun = lib.Timestamp(j.schedule.Next(time.Now()))
+	}
+	return s
+}
+
+func adminHandleJobs(c echo.Context) error {
+	result := []lib.APIJobStatus{}
+	for _, j := range jobs {
+		result = append(result, j.Status())
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+type internalRequestKey struct{}
+
+// internalGet runs a GET request through the server without touching the
+// network, so jobs share the handlers and the response cache. The request is
+// marked internal, it isn't rate limited, queued or counted as a client.
+func internalGet(e *echo.Echo, path string) (*httptest.ResponseRecorder, error) {
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(context.WithValue(req.Context(), internalRequestKey{}, true))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return rec, fmt.Errorf("GET %s returned %d", path, rec.Code)
+	}
+	return rec, nil
+}
+
+// isInternal reports whether the request was made by internalGet, clients
+// can't mark their requests so.
+func isInternal(c echo.Context) bool {
+	internal, _ := c.Request().Context().Value(internalRequestKey{}).(bool)
+	return internal
+}
+
+// jobStatsAggregation writes one market_stats row per item and location for
+// the last full hour of sell orders.
+func jobStatsAggregation(e *echo.Echo) error {
+	to := time.Now().Truncate(time.Hour)
+	from := to.Add(-time.Hour)
+
+	stats := tableDB("stats")
+	count := 0
+	if err := stats.Model(&adslib.ModelMarketStats{}).Where("timestamp = ?", from).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	rows := []struct {
+		ItemID   string
+		Location adslib.Location
+		PriceMin int
+		PriceMax int
+		PriceAvg float64
+	}{}
+	if err := plausibleOrders(db.Table(adslib.NewModelMarketOrder().TableName())).
+		Select("item_id, location, min(price) as price_min, max(price) as price_max, avg(price) as price_avg").
+		Where("auction_type = ? and updated_at >= ? and updated_at < ?", "offer", from, to).
+		Group("item_id, location").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	tx := stats.Begin()
+	created := []adslib.ModelMarketStats{}
+	for _, r := range rows {
+		stat := adslib.ModelMarketStats{
+			ItemID:    r.ItemID,
+			Location:  r.Location,
+			PriceMin:  r.PriceMin,
+			PriceMax:  r.PriceMax,
+			PriceAvg:  r.PriceAvg,
+			Timestamp: from,
+		}
+		if err := tx.Create(&stat).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		created = append(created, stat)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	webhooks.Notify(created)
+	publisher.PublishStats(created)
+	return nil
+}
+
+// jobCacheWarming requests the prices of jobs.cacheWarming.items so they are
+// served from the cache.
+func jobCacheWarming(e *echo.Echo) error {
+	items := viper.GetString("jobs.cacheWarming.items")
+	if items == "" {
+		return nil
+	}
+	for _, item := range strings.Split(items, ",") {
+		if _, err := internalGet(e, "/api/v1/stats/prices/"+item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobRetentionPruning deletes orders older than jobs.retentionPruning.days,
+// with partitioning whole days are dropped.
+func jobRetentionPruning(e *echo.Echo) error {
+	days := viper.GetInt("jobs.retentionPruning.days")
+	if days <= 0 {
+		return nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	if partitioningEnabled() {
+		return dropPartitionsBefore(cutoff)
+	}
+	return db.Unscoped().Where("updated_at < ?", cutoff).Delete(adslib.ModelMarketOrder{}).Error
+}
+
+// jobSnapshotExport writes the prices of jobs.snapshotExport.items as JSON
+// to jobs.snapshotExport.path.
+func jobSnapshotExport(e *echo.Echo) error {
+	path := viper.GetString("jobs.snapshotExport.path")
+	items := viper.GetString("jobs.snapshotExport.items")
+	if path == "" || items == "" {
+		return fmt.Errorf("jobs.snapshotExport.path and jobs.snapshotExport.items must be set")
+	}
+
+	rec, err := internalGet(e, "/api/v1/stats/prices/"+items)
+	if err != nil {
+		return err
+	}
+
+	// Write next to the target and rename, readers never see a partial file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, rec.Body.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("jwt.tierClaim", "tier")
+	viper.SetDefault("jwt.adminClaim", "role")
+	viper.SetDefault("jwt.adminValue", "admin")
+}
+
+// Unknown key ids fetch the JWKS again at most this often
+const jwksRefreshInterval = time.Minute
+
+// jwtConfigured reports if bearer JWTs are accepted, they need a JWKS URL or
+// a shared secret.
+func jwtConfigured() bool {
+	return viper.GetString("jwt.jwksURL") != "" || secrets.JWTSecret() != ""
+}
+
+// jwksCache keeps the public keys of jwt.jwksURL by key id.
+type jwksCache struct {
+	sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+var jwks = &jwksCache{keys: map[string]interface{}{}}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey converts RSA and P-256/384/521 EC keys, other kinds are skipped.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func fetchJWKS() (map[string]interface{}, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(viper.GetString("jwt.jwksURL"))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS answered %s", res.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// Key returns the key with the id, fetching the JWKS when it's unknown. The
+// fetch runs without the lock so a slow JWKS doesn't hold up tokens with
+// known keys, fetched keeps other unknown ids from fetching at the same time.
+func (jc *jwksCache) Key(kid string) (interface{}, error) {
+	jc.Lock()
+	if k, ok := jc.keys[kid]; ok {
+		jc.Unlock()
+		return k, nil
+	}
+	if time.Since(jc.fetched) < jwksRefreshInterval {
+		jc.Unlock()
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	jc.fetched = time.Now()
+	jc.Unlock()
+
+	keys, err := fetchJWKS()
+	if err != nil {
+		return nil, err
+	}
+	jc.Lock()
+	jc.keys = keys
+	jc.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+func jwtKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if secret := secrets.JWTSecret(); secret != "" {
+			return []byte(secret), nil
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		if viper.GetString("jwt.jwksURL") != "" {
+			kid, _ := token.Header["kid"].(string)
+			return jwks.Key(kid)
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+}
+
+// parseJWT validates the signature, expiry, jwt.issuer and jwt.audience of
+// a token. Tokens without exp are refused, they would never run out. aud
+// may be a string or a list, one of which has to be jwt.audience.
+func parseJWT(raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, jwtKey); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token has no exp or is expired")
+	}
+	if iss := viper.GetString("jwt.issuer"); iss != "" && !claims.VerifyIssuer(iss, true) {
+		return nil, fmt.Errorf("wrong issuer")
+	}
+	if aud := viper.GetString("jwt.audience"); aud != "" && !claimHas(claims, "aud", aud) {
+		return nil, fmt.Errorf("wrong audience")
+	}
+	return claims, nil
+}
+
+// bearerJWT returns the bearer token of the request if it is a JWT. The
+// adminKey and API keys are looked up first, they may contain dots too.
+func bearerJWT(c echo.Context) (string, bool) {
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	if !jwtConfigured() || !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if _, ok := apiKeys[token]; ok {
+		return "", false
+	}
+	if admin := viper.GetString("adminKey"); admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+		return "", false
+	}
+	return token, isJWT(token)
+}
+
+// isJWT reports if the token has the shape of a JWS, three base64url parts
+// of which the first is a JSON header naming the alg.
+func isJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(b, &header) == nil && header.Alg != ""
+}
+
+// requestClaims returns the validated claims of the request's JWT, set by
+// apiKeyAuth.
+func requestClaims(c echo.Context) (jwt.MapClaims, bool) {
+	claims, ok := c.Get("jwtClaims").(jwt.MapClaims)
+	return claims, ok
+}
+
+// claimHas reports if the claim is the value, or a list containing it.
+func claimHas(claims jwt.MapClaims, name string, value string) bool {
+	switch v := claims[name].(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtAdmin reports if the request's JWT grants admin access.
+func jwtAdmin(c echo.Context) bool {
+	claims, ok := requestClaims(c)
+	return ok && claimHas(claims, viper.GetString("jwt.adminClaim"), viper.GetString("jwt.adminValue"))
+}
+
+// jwtAPIKey turns valid claims into the key requests are authorized and
+// rate limited as, named after the subject.
+func jwtAPIKey(claims jwt.MapClaims) apiKey {
+	sub, _ := claims["sub"].(string)
+	t, _ := claims[viper.GetString("jwt.tierClaim")].(string)
+	if t == "" {
+		t = "registered"
+	}
+	return apiKey{Key: "jwt:" + sub, Name: sub, Tier: t}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func signTestJWT(t *testing.T, claims jwt.MapClaims) string {
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestBearerJWT(t *testing.T) {
+	viper.Set("jwt.secret", "test-secret")
+	viper.Set("adminKey", "admin.key.dots")
+	defer viper.Set("jwt.secret", "")
+	defer viper.Set("adminKey", "")
+	apiKeys["team.api.key"] = apiKey{Key: "team.api.key", Tier: "registered"}
+	defer delete(apiKeys, "team.api.key")
+
+	token := signTestJWT(t, jwt.MapClaims{"sub": "someone", "exp": time.Now().Add(time.Hour).Unix()})
+	tests := []struct {
+		bearer string
+		want   bool
+	}{
+		{token, true},
+		{"team.api.key", false},
+		{"admin.key.dots", false},
+		{"not.a.jwt", false},
+		{"nodots", false},
+	}
+	e := echo.New()
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.bearer)
+		c := e.NewContext(req, httptest.NewRecorder())
+		if _, got := bearerJWT(c); got != tt.want {
+			t.Errorf("bearerJWT(%q) = %v, want %v", tt.bearer, got, tt.want)
+		}
+	}
+}
+
+func TestParseJWTAudience(t *testing.T) {
+	viper.Set("jwt.secret", "test-secret")
+	viper.Set("jwt.audience", "albiondata")
+	defer viper.Set("jwt.secret", "")
+	defer viper.Set("jwt.audience", "")
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		aud  interface{}
+		want bool
+	}{
+		{"albiondata", true},
+		{[]string{"other", "albiondata"}, true},
+		{[]string{"other"}, false},
+		{"", false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		claims := jwt.MapClaims{"sub": "someone", "exp": exp}
+		if tt.aud != nil {
+			claims["aud"] = tt.aud
+		}
+		_, err := parseJWT(signTestJWT(t, claims))
+		if got := err == nil; got != tt.want {
+			t.Errorf("aud %v: valid = %v, want %v (%v)", tt.aud, got, tt.want, err)
+		}
+	}
+}
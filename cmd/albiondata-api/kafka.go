@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// kafkaPublisher mirrors the stats buckets the statsAggregation job writes
+// to kafka.statsTopic and, when kafka.queryTopic is set, anonymized query
+// events: no client IP or API key, only what was asked for and the answer.
+type kafkaPublisher struct {
+	producer     sarama.AsyncProducer
+	statsTopic   string
+	queriesTopic string
+	// done is closed once every error of the producer has been logged
+	done chan struct{}
+}
+
+var publisher *kafkaPublisher
+
+// startKafka sets up the publisher when kafka.brokers is configured.
+func startKafka() {
+	if viper.GetString("kafka.brokers") == "" {
+		return
+	}
+	config := sarama.NewConfig()
+	// Messages with the same key, like an item id, stay in order
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Return.Errors = true
+	producer, err := sarama.NewAsyncProducer(strings.Split(viper.GetString("kafka.brokers"), ","), config)
+	if err != nil {
+		fmt.Printf("Kafka: %v\n", err)
+		return
+	}
+
+	kp := &kafkaPublisher{
+		producer:     producer,
+		statsTopic:   viper.GetString("kafka.statsTopic"),
+		queriesTopic: viper.GetString("kafka.queryTopic"),
+		done:         make(chan struct{}),
+	}
+	go func() {
+		for err := range producer.Errors() {
+			fmt.Printf("Kafka: %v\n", err)
+		}
+		close(kp.done)
+	}()
+	publisher = kp
+}
+
+// stopKafka sends what is still buffered before returning.
+func stopKafka() {
+	if publisher == nil {
+		return
+	}
+	publisher.producer.AsyncClose()
+	<-publisher.done
+}
+
+// send queues a message without waiting for the brokers, it is dropped when
+// the producer's buffer is full.
+func (kp *kafkaPublisher) send(topic, key string, value []byte) {
+	msg := &sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key), Value: sarama.ByteEncoder(value)}
+	select {
+	case kp.producer.Input() <- msg:
+	default:
+		fmt.Printf("Kafka: buffer full, dropped a message to %s\n", topic)
+	}
+}
+
+// PublishStats sends one message per bucket keyed by item id.
+func (kp *kafkaPublisher) PublishStats(stats []adslib.ModelMarketStats) {
+	if kp == nil || kp.statsTopic == "" {
+		return
+	}
+	for _, s := range stats {
+		value, err := json.Marshal(lib.APIStatsBucket{
+			ItemID:    s.ItemID,
+			Location:  s.Location.String(),
+			Timestamp: lib.Timestamp(s.Timestamp),
+			PriceMin:  int64(s.PriceMin),
+			PriceMax:  int64(s.PriceMax),
+			PriceAvg:  s.PriceAvg,
+			Patch:     patchAt(s.Timestamp),
+		})
+		if err != nil {
+			continue
+		}
+		kp.send(kp.statsTopic, s.ItemID, value)
+	}
+}
+
+// queryEvents publishes every API request to kafka.queryTopic.
+func queryEvents(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publisher == nil || publisher.queriesTopic == "" || !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			return next(c)
+		}
+
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		params := map[string]string{}
+		for i, name := range c.ParamNames() {
+			if i < len(c.ParamValues()) {
+				params[name] = c.ParamValues()[i]
+			}
+		}
+		value, jerr := json.Marshal(lib.APIQueryEvent{
+			Timestamp:  lib.Timestamp(start),
+			Route:      c.Path(),
+			Params:     params,
+			Query:      c.QueryString(),
+			Status:     c.Response().Status,
+			DurationMs: float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+		})
+		if jerr == nil {
+			publisher.send(publisher.queriesTopic, c.Path(), value)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Name of the advisory lock held by the instance running background jobs
+const leaderLockName = "albiondata-api-scheduler"
+
+// Any 64 bit key works for Postgres, it just has to be the same everywhere
+const leaderLockKey = 7134289001
+
+const leaderCheckInterval = 15 * time.Second
+
+// leaderElector decides if this instance runs the background jobs. With
+// leaderElection set to "db" it holds a database advisory lock on a
+// dedicated connection, otherwise every instance is the leader.
+type leaderElector struct {
+	sync.Mutex
+	conn   *sql.Conn
+	leader bool
+}
+
+var leader = &leaderElector{}
+
+func (le *leaderElector) IsLeader() bool {
+	if viper.GetString("leaderElection") != "db" {
+		return true
+	}
+	le.Lock()
+	defer le.Unlock()
+	return le.leader
+}
+
+// Start campaigns for leadership until ctx is done.
+func (le *leaderElector) Start(ctx context.Context) {
+	if viper.GetString("leaderElection") != "db" {
+		return
+	}
+
+	go func() {
+		for {
+			le.check(ctx)
+			select {
+			case <-ctx.Done():
+				le.release()
+				return
+			case <-time.After(leaderCheckInterval):
+			}
+		}
+	}()
+}
+
+func (le *leaderElector) check(ctx context.Context) {
+	le.Lock()
+	defer le.Unlock()
+
+	// The lock lives as long as the session, make sure we still have it
+	if le.conn != nil {
+		if err := le.conn.PingContext(ctx); err == nil {
+			return
+		}
+		le.conn.Close()
+		le.conn = nil
+		le.leader = false
+		fmt.Println("Lost scheduler leadership")
+	}
+
+	conn, err := db.DB().Conn(ctx)
+	if err != nil {
+		fmt.Printf("Leader election: %v\n", err)
+		return
+	}
+
+	acquired, err := tryAdvisoryLock(ctx, conn)
+	if err != nil || !acquired {
+		if err != nil {
+			fmt.Printf("Leader election: %v\n", err)
+		}
+		conn.Close()
+		return
+	}
+
+	le.conn = conn
+	le.leader = true
+	fmt.Println("Acquired scheduler leadership")
+}
+
+func (le *leaderElector) release() {
+	le.Lock()
+	defer le.Unlock()
+	if le.conn != nil {
+		le.conn.Close()
+		le.conn = nil
+	}
+	le.leader = false
+}
+
+func tryAdvisoryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var acquired bool
+	var err error
+
+	switch db.Dialect().GetName() {
+	case "mysql":
+		var res sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", leaderLockName).Scan(&res)
+		acquired = res.Valid && res.Int64 == 1
+	case "postgres":
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockKey).Scan(&acquired)
+	case "mssql":
+		var res int
+		err = conn.QueryRowContext(ctx, "DECLARE @res int; EXEC @res = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0; SELECT @res", leaderLockName).Scan(&res)
+		acquired = res >= 0
+	default:
+		// SQLite can't be shared between hosts, so the only instance leads
+		acquired = true
+	}
+	return acquired, err
+}
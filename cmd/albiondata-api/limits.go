@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Where clients with too many items for a URL are sent
+const bulkPricesPath = "/api/v1/stats/prices"
+
+// limitURLLength answers 414 to URLs longer than maxURLLength, before they
+// get routed and expanded.
+func limitURLLength(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		max := viper.GetInt("maxURLLength")
+		if max > 0 && len(c.Request().RequestURI) > max {
+			return c.String(http.StatusRequestURITooLong,
+				fmt.Sprintf("URL longer than %d characters, POST long item lists as JSON to %s instead", max, bulkPricesPath))
+		}
+		return next(c)
+	}
+}
+
+// bodyLimitError replaces the 413 of the body limit middleware with one
+// pointing out the limit.
+func bodyLimitError(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if he, ok := err.(*echo.HTTPError); ok && he.Code == http.StatusRequestEntityTooLarge {
+			return c.String(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Request body larger than %s", viper.GetString("bodyLimit")))
+		}
+		return err
+	}
+}
+
+// apiHandleStatsPricesBulk answers like the prices endpoint for the items
+// and locations of a JSON body, for lists too long for a URL.
+func apiHandleStatsPricesBulk(c echo.Context) error {
+	var req lib.APIStatsPricesBulkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if len(req.Items) == 0 {
+		return c.String(http.StatusBadRequest, "items is required")
+	}
+
+	c.SetParamNames("item")
+	c.SetParamValues(strings.Join(req.Items, ","))
+	// Middlewares have read the query params already, rewriting the URL
+	// wouldn't reach queryLocations
+	if len(req.Locations) > 0 {
+		c.Set("locations", req.Locations)
+	}
+	return apiHandleStatsPricesItemJson(c)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func TestStatsPricesBulkLocations(t *testing.T) {
+	conn, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.AutoMigrate(&adslib.ModelMarketOrder{})
+	for _, l := range []adslib.Location{adslib.ThetfordMarket, adslib.LymhurstMarket} {
+		o := adslib.NewModelMarketOrder()
+		o.AlbionID = uint(l)
+		o.ItemID = "T4_BAG"
+		o.Price = 100
+		o.AuctionType = "offer"
+		o.Expires = time.Now().Add(time.Hour)
+		o.Location = l
+		if err := conn.Create(&o).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(orig *gorm.DB) { db = orig }(db)
+	db = conn
+
+	body := `{"items":["T4_BAG"],"locations":["Thetford"]}`
+	req := httptest.NewRequest("POST", bulkPricesPath, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	// Like the middlewares do before the handler runs
+	c.QueryParam("db")
+
+	if err := apiHandleStatsPricesBulk(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res []lib.APIStatsPricesItem
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].City != adslib.ThetfordMarket.String() {
+		t.Errorf("prices = %+v, want Thetford only", res)
+	}
+}
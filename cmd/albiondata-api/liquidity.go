@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// apiHandleStatsMetrics returns the volatility and liquidity of every city
+// over ?window= (default 7d, at most 30d). Volatility is the standard
+// deviation of the daily average price from market_stats, liquidity is
+// estimated from the orders uploaded in the window.
+func apiHandleStatsMetrics(c echo.Context) error {
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxHistoryWindow))
+	}
+
+	locs := queryLocations(c)
+	conn := dbFrom(c)
+	statsConn := statsDBFrom(c)
+	itemIDs, err := expandItemIDs(c, c.Param("item"), statsConn.Model(&adslib.ModelMarketStats{}), "stats")
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	since := time.Now().Add(-window)
+	days := window.Hours() / 24
+	result := []lib.APIItemMetrics{}
+	for _, itemID := range itemIDs {
+		stats := []adslib.ModelMarketStats{}
+		if err := statsConn.Where("item_id = ? and location in (?) and timestamp >= ?", itemID, locs, since).Order("timestamp asc").Find(&stats).Error; err != nil {
+			return err
+		}
+
+		rows := []struct {
+			Location  adslib.Location
+			Orders    int
+			AvgAmount float64
+		}{}
+		if err := plausibleOrders(partitionPrune(conn.Table(adslib.NewModelMarketOrder().TableName()), since)).
+			Select("location, count(*) as orders, avg(amount) as avg_amount").
+			Where("item_id = ? and location in (?) and created_at >= ?", itemID, locs, since).
+			Group("location").Scan(&rows).Error; err != nil {
+			return err
+		}
+
+		byLocation := map[adslib.Location]*lib.APIItemMetrics{}
+		get := func(l adslib.Location) *lib.APIItemMetrics {
+			if m, ok := byLocation[l]; ok {
+				return m
+			}
+			m := &lib.APIItemMetrics{ItemID: itemID, City: l.String()}
+			byLocation[l] = m
+			return m
+		}
+		for l, avgs := range dailyAverages(stats) {
+			m := get(l)
+			m.Days = len(avgs)
+			m.DailyAvg, m.Volatility = meanStddev(avgs)
+			if m.DailyAvg != 0 {
+				m.VolatilityPercent = m.Volatility / m.DailyAvg * 100
+			}
+		}
+		for _, r := range rows {
+			m := get(r.Location)
+			m.OrdersPerDay = float64(r.Orders) / days
+			m.AvgAmount = r.AvgAmount
+		}
+
+		// Keep the order the locations were asked for
+		for _, l := range locs {
+			if m, ok := byLocation[l]; ok {
+				result = append(result, *m)
+			}
+		}
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// dailyAverages averages the price_avg of every location per UTC day.
+func dailyAverages(stats []adslib.ModelMarketStats) map[adslib.Location][]float64 {
+	type key struct {
+		location adslib.Location
+		day      time.Time
+	}
+	sums := map[key]float64{}
+	counts := map[key]int{}
+	order := []key{}
+	for _, s := range stats {
+		k := key{s.Location, s.Timestamp.UTC().Truncate(24 * time.Hour)}
+		if _, ok := counts[k]; !ok {
+			order = append(order, k)
+		}
+		sums[k] += s.PriceAvg
+		counts[k]++
+	}
+
+	result := map[adslib.Location][]float64{}
+	for _, k := range order {
+		result[k.location] = append(result[k.location], sums[k]/float64(counts[k]))
+	}
+	return result
+}
+
+// meanStddev returns the mean and population standard deviation of values.
+func meanStddev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
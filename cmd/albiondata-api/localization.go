@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// apiHandleLocalization returns the display name in ?lang= (default en) of
+// every item, keyed by id. ?tier= and ?items= (a pattern like T4_BAG*)
+// narrow the list down, items.json has no categories to filter by.
+func apiHandleLocalization(c echo.Context) error {
+	lang := "EN-US"
+	if len(c.QueryParam("lang")) > 0 {
+		lang = normalizeLang(c.QueryParam("lang"))
+	}
+	tier := 0
+	if len(c.QueryParam("tier")) > 0 {
+		var err error
+		if tier, err = strconv.Atoi(c.QueryParam("tier")); err != nil || tier < 1 || tier > 8 {
+			return c.String(http.StatusBadRequest, "tier must be between 1 and 8")
+		}
+	}
+	pattern := c.QueryParam("items")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return c.String(http.StatusBadRequest, "Invalid items pattern")
+	}
+
+	result := map[string]string{}
+	for _, im := range items.All() {
+		if tier > 0 && im.Tier() != tier {
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, im.UniqueName); !ok {
+				continue
+			}
+		}
+		if name := im.LocalizedNames[lang]; name != "" {
+			result[im.UniqueName] = name
+		}
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const maxHistoryWindow = 30 * 24 * time.Hour
+
+// apiHandleMarketItem returns the prices, a summary of the market_stats
+// history over ?window= (default 7d) and the metadata of one item, what an
+// item page needs in one request.
+func apiHandleMarketItem(c echo.Context) error {
+	raw := c.Param("item")
+	if strings.ContainsAny(raw, ",*") {
+		return c.String(http.StatusBadRequest, "Only a single item can be asked for")
+	}
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxHistoryWindow))
+	}
+
+	itemID, err := resolveItemName(c, raw)
+	if err != nil {
+		return paramError(c, err)
+	}
+	prices, err := getStatsPricesItem(c, "prices", nil, 0)
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	result := lib.APIMarketItem{
+		ItemID:  itemID,
+		IconURL: itemIconURL(itemID),
+		Prices:  prices,
+		History: []lib.APIHistorySummary{},
+	}
+	if im, ok := items.Get(itemID); ok {
+		lang := "EN-US"
+		if len(c.QueryParam("lang")) > 0 {
+			lang = normalizeLang(c.QueryParam("lang"))
+		}
+		result.Name = im.LocalizedNames[lang]
+		result.Tier = im.Tier()
+	}
+
+	// ?patch= replaces the window with the time the patch was active
+	from, to, err := parsePatch(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	history := statsDBFrom(c).Where("item_id = ?", itemID)
+	if from.IsZero() {
+		history = history.Where("timestamp >= ?", time.Now().Add(-window))
+	} else {
+		history = history.Where("timestamp >= ?", from)
+	}
+	if !to.IsZero() {
+		history = history.Where("timestamp < ?", to)
+	}
+	stats := []adslib.ModelMarketStats{}
+	if err := history.Order("timestamp asc").Find(&stats).Error; err != nil {
+		return err
+	}
+	result.History = historySummaries(stats)
+
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// historySummaries sums up the stats of every location, stats must be in
+// timestamp order.
+func historySummaries(stats []adslib.ModelMarketStats) []lib.APIHistorySummary {
+	byLocation := map[adslib.Location]*lib.APIHistorySummary{}
+	order := []adslib.Location{}
+	first := map[adslib.Location]float64{}
+	sums := map[adslib.Location]float64{}
+	counts := map[adslib.Location]int{}
+
+	for _, s := range stats {
+		h, ok := byLocation[s.Location]
+		if !ok {
+			h = &lib.APIHistorySummary{Location: s.Location.String(), From: lib.Timestamp(s.Timestamp), PriceMin: int64(s.PriceMin), PriceMax: int64(s.PriceMax)}
+			byLocation[s.Location] = h
+			order = append(order, s.Location)
+			first[s.Location] = s.PriceAvg
+		}
+		h.To = lib.Timestamp(s.Timestamp)
+		if int64(s.PriceMin) < h.PriceMin {
+			h.PriceMin = int64(s.PriceMin)
+		}
+		if int64(s.PriceMax) > h.PriceMax {
+			h.PriceMax = int64(s.PriceMax)
+		}
+		h.LastPriceAvg = s.PriceAvg
+		sums[s.Location] += s.PriceAvg
+		counts[s.Location]++
+	}
+
+	result := []lib.APIHistorySummary{}
+	for _, l := range order {
+		h := byLocation[l]
+		h.PriceAvg = sums[l] / float64(counts[l])
+		if first[l] != 0 {
+			change := (h.LastPriceAvg - first[l]) / first[l] * 100
+			h.ChangePercent = &change
+		}
+		result = append(result, *h)
+	}
+	return result
+}
+
+// itemIconURL is the served icon of an item when the icons endpoint is
+// enabled, the render service otherwise.
+func itemIconURL(itemID string) string {
+	if endpointEnabled("icons") {
+		return "/api/v1/items/" + itemID + "/icon"
+	}
+	return viper.GetString("iconRenderURL") + "/" + itemID + ".png"
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+const mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// apiHandleStatsMatrix returns the prices of the items as a spreadsheet,
+// one row per item with the sell_price_min and buy_price_max of every
+// location in columns, and the newest price date in ?tz=. ?format= is csv
+// (default) or xlsx. Missing prices are empty cells.
+func apiHandleStatsMatrix(c echo.Context) error {
+	loc, err := parseTZ(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		return c.String(http.StatusBadRequest, "format must be one of csv, xlsx")
+	}
+	quality, err := parseQuality(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	fields := fieldSet{}.With("sell_price_min", "sell_price_min_date", "buy_price_max", "buy_price_max_date")
+	results, err := getStatsPricesItem(c, "prices", fields, quality)
+	if err != nil {
+		return paramError(c, err)
+	}
+	locs := queryLocations(c)
+	rows := priceMatrix(results, locationNames(locs), loc)
+
+	if format == "xlsx" {
+		b, err := xlsxSheet(rows)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, mimeXLSX, b)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// matrixDownload names the file of a matrix, outside of cacheResponse so
+// cached responses get it too.
+func matrixDownload(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		format := c.QueryParam("format")
+		if format == "" {
+			format = "csv"
+		}
+		name := "prices-" + time.Now().UTC().Format("2006-01-02") + "." + format
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+		return next(c)
+	}
+}
+
+// priceMatrix pivots prices into a header row and one row per item, in the
+// order the items were asked for.
+func priceMatrix(results []lib.APIStatsPricesItem, cities []string, loc *time.Location) [][]string {
+	header := []string{"item_id"}
+	column := map[string]int{}
+	for _, city := range cities {
+		column[city] = len(header)
+		header = append(header, city+" sell_price_min", city+" buy_price_max")
+	}
+	header = append(header, "updated")
+	rows := [][]string{header}
+
+	byItem := map[string]int{}
+	newest := map[string]time.Time{}
+	for _, r := range results {
+		i, ok := byItem[r.ItemID]
+		if !ok {
+			i = len(rows)
+			byItem[r.ItemID] = i
+			row := make([]string, len(header))
+			row[0] = r.ItemID
+			rows = append(rows, row)
+		}
+		col, ok := column[r.City]
+		if !ok {
+			continue
+		}
+		if r.SellPriceMin > 0 {
+			rows[i][col] = strconv.FormatInt(r.SellPriceMin, 10)
+		}
+		if r.BuyPriceMax > 0 {
+			rows[i][col+1] = strconv.FormatInt(r.BuyPriceMax, 10)
+		}
+		for _, d := range []lib.Timestamp{r.SellPriceMinDate, r.BuyPriceMaxDate} {
+			if time.Time(d).After(newest[r.ItemID]) {
+				newest[r.ItemID] = time.Time(d)
+			}
+		}
+	}
+	for itemID, i := range byItem {
+		if !newest[itemID].IsZero() {
+			rows[i][len(header)-1] = lib.Timestamp(newest[itemID]).In(loc)
+		}
+	}
+	return rows
+}
+
+// xlsxSheet writes rows as a workbook with a single sheet. Cells that are
+// whole numbers become numbers, everything else inline strings.
+func xlsxSheet(rows [][]string) ([]byte, error) {
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for r, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r+1)
+		for col, cell := range row {
+			if cell == "" {
+				continue
+			}
+			ref := xlsxColumn(col) + strconv.Itoa(r+1)
+			if _, err := strconv.ParseInt(cell, 10, 64); err == nil {
+				fmt.Fprintf(&sheet, `<c r="%s"><v>%s</v></c>`, ref, cell)
+				continue
+			}
+			fmt.Fprintf(&sheet, `<c r="%s" t="inlineStr"><is><t>`, ref)
+			xml.EscapeText(&sheet, []byte(cell))
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	files := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Prices" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// xlsxColumn is the letter name of a zero based column, A to Z, AA and on.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
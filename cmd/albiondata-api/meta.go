@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+func metaRequested(c echo.Context) bool {
+	return c.QueryParam("meta") == "true"
+}
+
+// requestMeta returns the metadata collected while handling the request,
+// handlers fill in how they interpreted the params.
+func requestMeta(c echo.Context) *lib.APIMeta {
+	if m, ok := c.Get("meta").(*lib.APIMeta); ok {
+		return m
+	}
+	m := &lib.APIMeta{
+		LocationsResolved: []string{},
+		ItemsResolved:     []string{},
+	}
+	c.Set("meta", m)
+	return m
+}
+
+// withMeta wraps data in an envelope with the request metadata.
+func withMeta(c echo.Context, data interface{}) lib.APIEnvelope {
+	m := requestMeta(c)
+	m.GeneratedAt = lib.Timestamp(time.Now())
+	m.Cache = "miss"
+	if qs := requestStats(c); qs != nil {
+		m.Debug = qs.Debug(c)
+	}
+	return lib.APIEnvelope{Data: data, Meta: m}
+}
+
+// markCacheHit flips meta.cache of a cached envelope to hit.
+func markCacheHit(body []byte) []byte {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return body
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(envelope["meta"], &m); err != nil {
+		return body
+	}
+	m["cache"] = "hit"
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	envelope["meta"] = b
+	if res, err := json.Marshal(envelope); err == nil {
+		return res
+	}
+	return body
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json")
+}
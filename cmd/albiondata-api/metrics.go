@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// freshnessCollector reads how old the newest order of every location and
+// the newest gold price are when Prometheus scrapes, so alerts can fire when
+// the collector pipeline stops feeding the database.
+type freshnessCollector struct {
+	orderAge *prometheus.Desc
+	goldAge  *prometheus.Desc
+}
+
+func newFreshnessCollector() *freshnessCollector {
+	return &freshnessCollector{
+		orderAge: prometheus.NewDesc("albiondata_last_order_age_seconds",
+			"Seconds since the newest order of a location was updated", []string{"location"}, nil),
+		goldAge: prometheus.NewDesc("albiondata_last_gold_price_age_seconds",
+			"Seconds since the newest gold price", nil, nil),
+	}
+}
+
+func (fc *freshnessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fc.orderAge
+	ch <- fc.goldAge
+}
+
+func (fc *freshnessCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, l := range adslib.Locations() {
+		var order adslib.ModelMarketOrder
+		if err := partitionPrune(db, now.Add(-time.Duration(ageSetting("prices", "maxAge"))*time.Second)).Select("updated_at").Where("location = ?", l).Order("updated_at desc").First(&order).Error; err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(fc.orderAge, prometheus.GaugeValue, now.Sub(order.UpdatedAt).Seconds(), l.String())
+	}
+
+	var gold adslib.ModelGoldprices
+	if err := tableDB("gold").Order("timestamp desc").First(&gold).Error; err == nil {
+		ch <- prometheus.MustNewConstMetric(fc.goldAge, prometheus.GaugeValue, now.Sub(gold.Timestamp).Seconds())
+	}
+}
+
+// metricsHandler serves the Prometheus metrics, freshness gauges included.
+func metricsHandler() echo.HandlerFunc {
+	prometheus.MustRegister(newFreshnessCollector())
+	return echo.WrapHandler(promhttp.Handler())
+}
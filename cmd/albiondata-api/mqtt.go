@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func init() {
+	viper.SetDefault("mqtt.topic", "albiondata/gold")
+	viper.SetDefault("mqtt.clientID", "albiondata-api")
+	viper.SetDefault("mqtt.pollInterval", 60)
+}
+
+// publishGoldTicks looks for new gold prices every mqtt.pollInterval
+// seconds and publishes each to mqtt.topic on mqtt.broker, until ctx is
+// done. Only the leader publishes so replicas don't send ticks twice.
+func publishGoldTicks(ctx context.Context) error {
+	if viper.GetString("mqtt.broker") == "" {
+		return nil
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(viper.GetString("mqtt.broker")).
+		SetClientID(viper.GetString("mqtt.clientID")).
+		SetUsername(viper.GetString("mqtt.username")).
+		SetPassword(viper.GetString("mqtt.password")).
+		SetAutoReconnect(true)
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("mqtt: %v", token.Error())
+	}
+
+	go func() {
+		defer client.Disconnect(250)
+		last := time.Now()
+		ticker := time.NewTicker(time.Duration(viper.GetInt("mqtt.pollInterval")) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !leader.IsLeader() {
+				last = time.Now()
+				continue
+			}
+
+			prices := []adslib.ModelGoldprices{}
+			if err := tableDB("gold").Where("timestamp > ?", last).Order("timestamp asc").Find(&prices).Error; err != nil {
+				fmt.Printf("mqtt: %v\n", err)
+				continue
+			}
+			for _, p := range prices {
+				payload, _ := json.Marshal(lib.APIGoldTick{Price: int64(p.Price), Timestamp: lib.Timestamp(p.Timestamp)})
+				client.Publish(viper.GetString("mqtt.topic"), 0, true, payload)
+				last = p.Timestamp
+			}
+		}
+	}()
+	return nil
+}
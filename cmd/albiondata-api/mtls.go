@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// adminCertRequired reports if admin requests need a client certificate
+// signed by adminClientCA.
+func adminCertRequired() bool {
+	return viper.GetString("adminClientCA") != ""
+}
+
+// hasClientCert reports if the request came with a client certificate that
+// was verified against adminClientCA, only adminListen verifies them.
+func hasClientCert(c echo.Context) bool {
+	tlsState := c.Request().TLS
+	return tlsState != nil && len(tlsState.VerifiedChains) > 0
+}
+
+func adminTLSConfig() (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(viper.GetString("adminClientCA"))
+	if err != nil {
+		return nil, fmt.Errorf("adminClientCA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("adminClientCA: no certificates in %s", viper.GetString("adminClientCA"))
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// startAdminListener serves everything on adminListen over TLS with
+// adminTLSCert and adminTLSKey, clients have to present a certificate of
+// adminClientCA. Admin requests arriving on listen are rejected then, they
+// have no verified certificate.
+func startAdminListener(e *echo.Echo) error {
+	addr := viper.GetString("adminListen")
+	if addr == "" {
+		return nil
+	}
+	if !adminCertRequired() {
+		return fmt.Errorf("adminListen needs adminClientCA")
+	}
+	config, err := adminTLSConfig()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: addr, Handler: e, TLSConfig: config}
+	go func() {
+		fmt.Printf("Admin listener with client certificates on %s\n", addr)
+		if err := srv.ListenAndServeTLS(viper.GetString("adminTLSCert"), viper.GetString("adminTLSKey")); err != nil {
+			fmt.Printf("Admin listener: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// Query params of the API in their canonical spelling, keys differing only
+// in case are rewritten to these
+var queryParamNames = []string{
+	"a", "after_id", "age", "at", "b", "before", "callback", "client", "columns", "days", "db", "downsample", "explain",
+	"fields", "fields_case", "format", "from", "includeExpired", "indicators", "int64", "interval",
+	"items", "lang", "limit", "locations", "maxPoints", "meta", "order", "page",
+	"patch", "pattern", "per_page", "points", "quality", "refresh", "returnRate", "since", "size",
+	"sort", "splitQuality", "table", "tier", "timeout", "to", "tz", "window",
+}
+
+var canonicalQueryParams = func() map[string]string {
+	m := map[string]string{}
+	for _, name := range queryParamNames {
+		m[strings.ToLower(name)] = name
+	}
+	return m
+}()
+
+// normalizeRequest runs before routing, it strips trailing slashes from API
+// paths and spells known query param keys canonically, so
+// /api/v1/stats/prices/T4_BAG/?Locations=Martlock is answered like
+// /api/v1/stats/prices/T4_BAG?locations=Martlock, and cached as the same.
+func normalizeRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		changed := false
+
+		if strings.HasPrefix(req.URL.Path, "/api/") && strings.HasSuffix(req.URL.Path, "/") {
+			req.URL.Path = strings.TrimRight(req.URL.Path, "/")
+			req.URL.RawPath = ""
+			changed = true
+		}
+
+		if req.URL.RawQuery != "" {
+			query := req.URL.Query()
+			normalized := url.Values{}
+			for key, values := range query {
+				name, ok := canonicalQueryParams[strings.ToLower(key)]
+				if !ok {
+					name = key
+				}
+				if name != key {
+					changed = true
+				}
+				normalized[name] = append(normalized[name], values...)
+			}
+			if changed {
+				req.URL.RawQuery = normalized.Encode()
+			}
+		}
+
+		if changed {
+			req.RequestURI = req.URL.RequestURI()
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// queryLocations resolves the locations query param, every location when it
+// is empty. Each entry matches the first location containing it. Locations
+// set on c under "locations", like those of a bulk request body, replace
+// the query param.
+func queryLocations(c echo.Context) []adslib.Location {
+	raw := c.QueryParam("locations")
+	if bodyLocs, ok := c.Get("locations").([]string); ok {
+		raw = strings.Join(bodyLocs, ",")
+	}
+
+	locs := adslib.Locations()
+	if len(raw) > 0 {
+		queryLocs := strings.Split(raw, ",")
+
+		locs = []adslib.Location{}
+		seen := map[adslib.Location]bool{}
+		for _, queryLoc := range queryLocs {
+			queryLoc = strings.TrimSpace(queryLoc)
+			if queryLoc == "" {
+				continue
+			}
+			// Keep the first mention, in the order asked for
+			if l, ok := findLocation(queryLoc); ok && !seen[l] {
+				seen[l] = true
+				locs = append(locs, l)
+			}
+		}
+	}
+	return locs
+}
+
+// findLocation returns the first location whose name contains name.
+func findLocation(name string) (adslib.Location, bool) {
+	for _, l := range adslib.Locations() {
+		if strings.Contains(l.String(), name) {
+			return l, true
+		}
+	}
+	return 0, false
+}
+
+func locationNames(locs []adslib.Location) []string {
+	names := []string{}
+	for _, l := range locs {
+		names = append(names, l.String())
+	}
+	return names
+}
+
+// likeEscaper escapes the LIKE wildcards in an item id, most ids have
+// underscores.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// likePattern turns an item id with * wildcards into a LIKE pattern where
+// only the * match anything, to be used with ESCAPE '!'.
+func likePattern(id string) string {
+	return strings.Replace(likeEscaper.Replace(id), "*", "%", -1)
+}
+
+// expandItemIDs splits a comma separated item list, wildcards are expanded
+// to the matching item ids found by scope. Repeated ids are dropped, the
+// first mention keeps its place. Expansions are cached under
+// scopeKey, which must identify the table and filters of scope, except for
+// requests looking back with ?at=.
+func expandItemIDs(c echo.Context, raw string, scope *gorm.DB, scopeKey string) ([]string, error) {
+	scopeKey = databaseName(c) + "|" + scopeKey
+	t := tierFor(c)
+	itemIDs := []string{}
+	seen := map[string]bool{}
+	add := func(ids ...string) {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				itemIDs = append(itemIDs, id)
+			}
+		}
+	}
+
+	for _, qID := range strings.Split(raw, ",") {
+		qID = strings.TrimSpace(qID)
+		if qID == "*" || qID == "" {
+			continue
+		}
+		if strings.Contains(qID, "*") {
+			if !endpointEnabled("wildcards") {
+				return nil, fmt.Errorf("Wildcards are disabled on this server")
+			}
+			sqlID := likePattern(qID)
+
+			// Every ?at= asks for another moment, those aren't worth keeping
+			cacheable := c.QueryParam("at") == ""
+			var foundIDs []string
+			ok := false
+			if cacheable {
+				foundIDs, ok = wildcards.Get(scopeKey, qID)
+			}
+			if qs := requestStats(c); qs != nil {
+				qs.addWildcard(ok)
+			}
+			if !ok {
+				foundIDs = []string{}
+				if err := scope.Select("item_id").Where("item_id LIKE ? ESCAPE '!'", sqlID).Group("item_id").Pluck("item_id", &foundIDs).Error; err != nil {
+					fmt.Printf("%v\n", err)
+					continue
+				}
+				if cacheable {
+					wildcards.Set(scopeKey, qID, foundIDs)
+				}
+			}
+			if t.MaxWildcardItems > 0 && len(foundIDs) > t.MaxWildcardItems {
+				return nil, fmt.Errorf("%s matches %d items, your API key tier allows %d", qID, len(foundIDs), t.MaxWildcardItems)
+			}
+
+			add(foundIDs...)
+
+		} else {
+			id, err := resolveItemName(c, qID)
+			if err != nil {
+				return nil, err
+			}
+			add(id)
+		}
+	}
+	return itemIDs, nil
+}
+
+// parseTZ resolves the tz query param, dates are rendered in UTC unless
+// asked otherwise.
+func parseTZ(c echo.Context) (*time.Location, error) {
+	if len(c.QueryParam("tz")) == 0 {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(c.QueryParam("tz"))
+	if err != nil {
+		return nil, fmt.Errorf("Unknown timezone: %s", c.QueryParam("tz"))
+	}
+	return loc, nil
+}
+
+// parseWindow parses durations like 90m, 24h or 7d.
+func parseWindow(s string, def time.Duration) (time.Duration, error) {
+	if len(s) == 0 {
+		return def, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("Invalid window: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid window: %s", s)
+	}
+	return d, nil
+}
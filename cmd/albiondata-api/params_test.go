@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"T4_BAG", "T4!_BAG"},
+		{"T4_*", "T4!_%"},
+		{"*_BAG@1", "%!_BAG@1"},
+		{"100%!", "100!%!!"},
+	}
+	for _, tt := range tests {
+		if got := likePattern(tt.id); got != tt.want {
+			t.Errorf("likePattern(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
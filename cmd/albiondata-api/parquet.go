@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+var parquetOrderColumns = []parquetColumn{
+	{"id", parquetInt64, parquetNoConverted},
+	{"albion_id", parquetInt64, parquetNoConverted},
+	{"item_id", parquetByteArray, parquetUTF8},
+	{"location", parquetInt32, parquetNoConverted},
+	{"quality_level", parquetInt32, parquetNoConverted},
+	{"enchantment_level", parquetInt32, parquetNoConverted},
+	{"price", parquetInt64, parquetNoConverted},
+	{"initial_amount", parquetInt64, parquetNoConverted},
+	{"amount", parquetInt64, parquetNoConverted},
+	{"auction_type", parquetByteArray, parquetUTF8},
+	{"expires", parquetInt64, parquetTimestampMillis},
+	{"updated_at", parquetInt64, parquetTimestampMillis},
+}
+
+var parquetStatColumns = []parquetColumn{
+	{"item_id", parquetByteArray, parquetUTF8},
+	{"location", parquetInt32, parquetNoConverted},
+	{"price_min", parquetInt64, parquetNoConverted},
+	{"price_max", parquetInt64, parquetNoConverted},
+	{"price_avg", parquetDouble, parquetNoConverted},
+	{"timestamp", parquetInt64, parquetTimestampMillis},
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// apiHandleExportParquet streams the exported orders, or the stats with
+// ?table=stats, as a snappy compressed Parquet file.
+func apiHandleExportParquet(c echo.Context) error {
+	table := c.QueryParam("table")
+	if table == "" {
+		table = "orders"
+	}
+
+	var conn *gorm.DB
+	var columns []parquetColumn
+	switch table {
+	case "orders":
+		conn = plausibleOrders(dbFrom(c).Model(&adslib.ModelMarketOrder{})).Where("updated_at >= ?", exportOrdersSince())
+		columns = parquetOrderColumns
+	case "stats":
+		conn = statsDBFrom(c).Model(&adslib.ModelMarketStats{}).Where("timestamp >= ?", exportStatsSince())
+		columns = parquetStatColumns
+	default:
+		return c.String(http.StatusBadRequest, "table must be orders or stats")
+	}
+
+	rows, err := conn.Rows()
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	defer rows.Close()
+
+	name := fmt.Sprintf("albiondata-%s-%s.parquet", table, time.Now().UTC().Format("20060102T150405Z"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apache.parquet")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// The footer is written last, a failure halfway leaves the client with
+	// a file it can't open rather than a wrong one
+	pw, err := newParquetWriter(c.Response(), columns)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		if table == "orders" {
+			var o adslib.ModelMarketOrder
+			if err := conn.ScanRows(rows, &o); err != nil {
+				return err
+			}
+			err = pw.Write(int64(o.ID), int64(o.AlbionID), o.ItemID, int32(o.Location), int32(o.QualityLevel),
+				int32(o.EnchantmentLevel), int64(o.Price), int64(o.InitialAmount), int64(o.Amount), o.AuctionType,
+				unixMillis(o.Expires), unixMillis(o.UpdatedAt))
+		} else {
+			var s adslib.ModelMarketStats
+			if err := conn.ScanRows(rows, &s); err != nil {
+				return err
+			}
+			err = pw.Write(s.ItemID, int32(s.Location), int64(s.PriceMin), int64(s.PriceMax), s.PriceAvg,
+				unixMillis(s.Timestamp))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return pw.Close()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/golang/snappy"
+)
+
+// The export only needs flat files of required columns, written once from
+// start to end. That is little enough to write here: the data is PLAIN
+// encoded, compressed with snappy, one page per column chunk, and the
+// metadata is thrift's compact protocol.
+
+const parquetMagic = "PAR1"
+
+// parquetRowGroupRows is how many rows are buffered before they are written
+// out as a row group.
+const parquetRowGroupRows = 64 * 1024
+
+// Physical types
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Converted types, parquetNoConverted leaves it out
+const (
+	parquetNoConverted     = -1
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+const (
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+	parquetCodecSnappy   = 1
+	parquetDataPage      = 0
+)
+
+// parquetColumn is a required column of a flat schema.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+}
+
+// parquetChunk is the metadata of a column chunk written to the file.
+type parquetChunk struct {
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+// parquetWriter writes rows of columns as a Parquet file to w. The rows are
+// buffered in row groups of parquetRowGroupRows, Close writes the last one
+// and the footer.
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	values  []bytes.Buffer
+	rows    int64
+	total   int64
+	groups  []parquetRowGroup
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	pw := &parquetWriter{w: w, columns: columns, values: make([]bytes.Buffer, len(columns))}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// Write adds a row, the values in the order of the columns. INT32 columns
+// take int32, INT64 int64, DOUBLE float64 and BYTE_ARRAY string.
+func (pw *parquetWriter) Write(row ...interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("parquet: row has %d values, want %d", len(row), len(pw.columns))
+	}
+	for i, col := range pw.columns {
+		buf := &pw.values[i]
+		var b [8]byte
+		ok := false
+		switch v := row[i].(type) {
+		case int32:
+			if ok = col.typ == parquetInt32; ok {
+				binary.LittleEndian.PutUint32(b[:4], uint32(v))
+				buf.Write(b[:4])
+			}
+		case int64:
+			if ok = col.typ == parquetInt64; ok {
+				binary.LittleEndian.PutUint64(b[:], uint64(v))
+				buf.Write(b[:])
+			}
+		case float64:
+			if ok = col.typ == parquetDouble; ok {
+				binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+				buf.Write(b[:])
+			}
+		case string:
+			if ok = col.typ == parquetByteArray; ok {
+				binary.LittleEndian.PutUint32(b[:4], uint32(len(v)))
+				buf.Write(b[:4])
+				buf.WriteString(v)
+			}
+		}
+		if !ok {
+			return fmt.Errorf("parquet: %T can't be written to column %s", row[i], col.name)
+		}
+	}
+	pw.rows++
+	if pw.rows >= parquetRowGroupRows {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (pw *parquetWriter) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: pw.rows}
+	for i := range pw.columns {
+		data := pw.values[i].Bytes()
+		page := snappy.Encode(nil, data)
+
+		var t thriftWriter
+		t.i32(1, parquetDataPage)
+		t.i32(2, int32(len(data)))
+		t.i32(3, int32(len(page)))
+		t.structBegin(5)
+		t.i32(1, int32(pw.rows))
+		t.i32(2, parquetEncodingPlain)
+		t.i32(3, parquetEncodingRLE)
+		t.i32(4, parquetEncodingRLE)
+		t.structEnd()
+		t.stop()
+		header := t.buf.Bytes()
+
+		chunk := parquetChunk{
+			offset:       pw.offset,
+			values:       pw.rows,
+			uncompressed: int64(len(header) + len(data)),
+			compressed:   int64(len(header) + len(page)),
+		}
+		if err := pw.write(header); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressed
+		pw.values[i].Reset()
+	}
+	pw.groups = append(pw.groups, group)
+	pw.total += pw.rows
+	pw.rows = 0
+	return nil
+}
+
+// Close writes the buffered rows and the footer, it doesn't close w.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	meta := pw.fileMetaData()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(meta)))
+	for _, b := range [][]byte{meta, size[:], []byte(parquetMagic)} {
+		if err := pw.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pw *parquetWriter) fileMetaData() []byte {
+	var t thriftWriter
+	t.i32(1, 1)
+
+	t.listBegin(2, thriftStruct, len(pw.columns)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)))
+	t.structEnd()
+	for _, col := range pw.columns {
+		t.elemBegin()
+		t.i32(1, col.typ)
+		t.i32(3, 0) // REQUIRED
+		t.binary(4, col.name)
+		if col.converted != parquetNoConverted {
+			t.i32(6, col.converted)
+		}
+		t.structEnd()
+	}
+
+	t.i64(3, pw.total)
+
+	t.listBegin(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			t.elemBegin()
+			t.i64(2, chunk.offset)
+			t.structBegin(3)
+			t.i32(1, pw.columns[i].typ)
+			t.listBegin(2, thriftI32, 2)
+			t.varint(parquetEncodingPlain)
+			t.varint(parquetEncodingRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.str(pw.columns[i].name)
+			t.i32(4, parquetCodecSnappy)
+			t.i64(5, chunk.values)
+			t.i64(6, chunk.uncompressed)
+			t.i64(7, chunk.compressed)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.structEnd()
+	}
+
+	t.binary(6, "albiondata-api")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// Compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in thrift's compact protocol. Field ids are
+// written as deltas of the previous field of the same struct, so nested
+// structs keep their own last id.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+	id   int16
+}
+
+func (t *thriftWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(s string) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+// elemBegin starts a struct inside a list, where it has no field header.
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.id = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], uint64(size))])
+}
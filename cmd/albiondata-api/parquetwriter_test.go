@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestThriftWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(t *thriftWriter)
+		want  []byte
+	}{
+		{"short field", func(t *thriftWriter) { t.i32(1, 1) }, []byte{0x15, 0x02}},
+		{"negative", func(t *thriftWriter) { t.i64(2, -1) }, []byte{0x26, 0x01}},
+		{"long delta", func(t *thriftWriter) { t.i32(1, 0); t.i32(20, 3) }, []byte{0x15, 0x00, 0x05, 0x28, 0x06}},
+		{"binary", func(t *thriftWriter) { t.binary(4, "ab") }, []byte{0x48, 0x02, 'a', 'b'}},
+		{"nested struct", func(t *thriftWriter) {
+			t.i32(3, 1)
+			t.structBegin(5)
+			t.i32(1, 1)
+			t.structEnd()
+			t.i32(6, 1)
+		}, []byte{0x35, 0x02, 0x2c, 0x15, 0x02, 0x00, 0x15, 0x02}},
+		{"long list", func(t *thriftWriter) { t.listBegin(1, thriftI32, 20) }, []byte{0x19, 0xf5, 0x14}},
+	}
+	for _, tt := range tests {
+		var w thriftWriter
+		tt.write(&w)
+		if got := w.buf.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var out bytes.Buffer
+	pw, err := newParquetWriter(&out, []parquetColumn{
+		{"item_id", parquetByteArray, parquetUTF8},
+		{"price", parquetInt64, parquetNoConverted},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Write("T4_BAG", int64(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Write(int64(100), "T4_BAG"); err == nil {
+		t.Errorf("values of the wrong type were written")
+	}
+	if err := pw.Write("T4_BAG"); err == nil {
+		t.Errorf("a row missing values was written")
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := out.Bytes()
+	if string(b[:4]) != parquetMagic || string(b[len(b)-4:]) != parquetMagic {
+		t.Fatalf("file isn't framed by %s", parquetMagic)
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := b[len(b)-8-size : len(b)-8]
+	if !reflect.DeepEqual(meta, pw.fileMetaData()) {
+		t.Errorf("footer length doesn't point at the metadata")
+	}
+	if pw.total != 1 || len(pw.groups) != 1 || len(pw.groups[0].chunks) != 2 {
+		t.Errorf("total %d, groups %v, want one row in one group of two chunks", pw.total, pw.groups)
+	}
+	if got := pw.groups[0].chunks[0].offset; got != int64(len(parquetMagic)) {
+		t.Errorf("first chunk at %d, want right after the magic", got)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// market_orders can be partitioned by day on updated_at, on MySQL with
+// PARTITION BY RANGE (TO_DAYS(updated_at)) and on Postgres with PARTITION BY
+// RANGE (updated_at). The table has to be set up like that by hand, the
+// partitionMaintenance job then creates the upcoming partitions and
+// retentionPruning drops old ones instead of deleting rows.
+
+func init() {
+	viper.SetDefault("partitioning.daysAhead", 3)
+}
+
+func partitioningEnabled() bool {
+	return viper.GetBool("partitioning.enabled")
+}
+
+// partitionPrune bounds a market_orders query on updated_at, so only the
+// partitions from since on are read. Queries filtering on other columns
+// (like created_at, which is never after updated_at) use it. Without
+// partitioning q is returned as is.
+func partitionPrune(q *gorm.DB, since time.Time) *gorm.DB {
+	if !partitioningEnabled() {
+		return q
+	}
+	return q.Where("updated_at >= ?", since)
+}
+
+// partitionPrefix starts the name of every partition, on Postgres they are
+// tables of their own.
+func partitionPrefix() string {
+	if db.Dialect().GetName() == "postgres" {
+		return adslib.NewModelMarketOrder().TableName() + "_p"
+	}
+	return "p"
+}
+
+// partitionName is the name of the partition holding day.
+func partitionName(day time.Time) string {
+	return partitionPrefix() + day.Format("20060102")
+}
+
+// partitions lists the partitions of market_orders.
+func partitions() ([]string, error) {
+	table := adslib.NewModelMarketOrder().TableName()
+	var query string
+	switch db.Dialect().GetName() {
+	case "mysql":
+		query = "SELECT partition_name FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = ? AND partition_name IS NOT NULL"
+	case "postgres":
+		query = "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent WHERE p.relname = ?"
+	default:
+		return nil, fmt.Errorf("partitioning isn't supported on %s", db.Dialect().GetName())
+	}
+
+	rows, err := db.Raw(query, table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// jobPartitionMaintenance creates the partitions of today and the next
+// partitioning.daysAhead days.
+func jobPartitionMaintenance(e *echo.Echo) error {
+	if !partitioningEnabled() {
+		return nil
+	}
+	existing, err := partitions()
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, name := range existing {
+		have[name] = true
+	}
+
+	table := adslib.NewModelMarketOrder().TableName()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := 0; i <= viper.GetInt("partitioning.daysAhead"); i++ {
+		day := today.AddDate(0, 0, i)
+		name := partitionName(day)
+		if have[name] {
+			continue
+		}
+		next := day.AddDate(0, 0, 1).Format("2006-01-02")
+		var stmt string
+		if db.Dialect().GetName() == "mysql" {
+			stmt = fmt.Sprintf("ALTER TABLE %s ADD PARTITION (PARTITION %s VALUES LESS THAN (TO_DAYS('%s')))", table, name, next)
+		} else {
+			stmt = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')", name, table, day.Format("2006-01-02"), next)
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// dropPartitionsBefore drops the partitions holding only days before
+// cutoff, much cheaper than deleting their rows.
+func dropPartitionsBefore(cutoff time.Time) error {
+	existing, err := partitions()
+	if err != nil {
+		return err
+	}
+	table := adslib.NewModelMarketOrder().TableName()
+	limit := partitionName(cutoff.UTC().Truncate(24 * time.Hour))
+	for _, name := range existing {
+		// Names sort by day, ones that don't follow the pattern are left alone
+		if !strings.HasPrefix(name, partitionPrefix()) || len(name) != len(limit) || name >= limit {
+			continue
+		}
+		var stmt string
+		if db.Dialect().GetName() == "mysql" {
+			stmt = fmt.Sprintf("ALTER TABLE %s DROP PARTITION %s", table, name)
+		} else {
+			stmt = fmt.Sprintf("DROP TABLE IF EXISTS %s", name)
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
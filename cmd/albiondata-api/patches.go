@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// gamePatch is a game update from the patches config, it is active from
+// its release until the next one.
+type gamePatch struct {
+	Name     string
+	Released string
+
+	released time.Time
+}
+
+// Known patches, oldest first
+var gamePatches = []gamePatch{}
+
+func loadPatches() error {
+	list := []gamePatch{}
+	if err := viper.UnmarshalKey("patches", &list); err != nil {
+		return fmt.Errorf("patches: %v", err)
+	}
+	for i := range list {
+		var err error
+		if list[i].released, err = time.Parse(time.RFC3339, list[i].Released); err != nil {
+			return fmt.Errorf("patches: %s released: %v", list[i].Name, err)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].released.Before(list[j].released) })
+	gamePatches = list
+	return nil
+}
+
+// patchAt is the patch active at t, empty before the first known one.
+func patchAt(t time.Time) string {
+	name := ""
+	for _, p := range gamePatches {
+		if p.released.After(t) {
+			break
+		}
+		name = p.Name
+	}
+	return name
+}
+
+// parsePatch turns ?patch= into the time the patch was active, to is zero
+// for the current patch. Without the param both are zero.
+func parsePatch(c echo.Context) (time.Time, time.Time, error) {
+	name := c.QueryParam("patch")
+	if name == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	for i, p := range gamePatches {
+		if p.Name != name {
+			continue
+		}
+		to := time.Time{}
+		if i+1 < len(gamePatches) {
+			to = gamePatches[i+1].released
+		}
+		return p.released, to, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("Unknown patch: %s", name)
+}
+
+// apiHandlePatches lists the known patches, the current one last.
+func apiHandlePatches(c echo.Context) error {
+	result := []lib.APIGamePatch{}
+	for i, p := range gamePatches {
+		entry := lib.APIGamePatch{Name: p.Name, Released: lib.Timestamp(p.released)}
+		if i+1 < len(gamePatches) {
+			until := lib.Timestamp(gamePatches[i+1].released)
+			entry.Until = &until
+		}
+		result = append(result, entry)
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
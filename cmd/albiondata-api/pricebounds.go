@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// priceBound is a plausible price range, 0 leaves a side open. Items is a
+// pattern like T*_ORE* selecting the items of a category.
+type priceBound struct {
+	Items string
+	Min   int
+	Max   int
+}
+
+// outside is the condition matching prices out of the bound.
+func (pb priceBound) outside() (string, []interface{}) {
+	conds, args := []string{}, []interface{}{}
+	if pb.Min > 0 {
+		conds, args = append(conds, "price < ?"), append(args, pb.Min)
+	}
+	if pb.Max > 0 {
+		conds, args = append(conds, "price > ?"), append(args, pb.Max)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "(" + strings.Join(conds, " or ") + ")", args
+}
+
+// priceBounds holds the priceBounds config, the global bound applies to the
+// items no category matches.
+var priceBounds struct {
+	global     priceBound
+	categories []priceBound
+}
+
+func loadPriceBounds() error {
+	priceBounds.global = priceBound{Min: viper.GetInt("priceBounds.min"), Max: viper.GetInt("priceBounds.max")}
+	priceBounds.categories = []priceBound{}
+	if err := viper.UnmarshalKey("priceBounds.categories", &priceBounds.categories); err != nil {
+		return fmt.Errorf("priceBounds.categories: %v", err)
+	}
+	for _, pb := range priceBounds.categories {
+		if pb.Items == "" {
+			return fmt.Errorf("priceBounds.categories: every category needs items")
+		}
+		if pb.Max > 0 && pb.Max < pb.Min {
+			return fmt.Errorf("priceBounds.categories: %s max is below min", pb.Items)
+		}
+	}
+	return nil
+}
+
+// implausible is the condition matching orders out of their bounds, empty
+// when no bounds are configured.
+func implausible() (string, []interface{}) {
+	conds, args := []string{}, []interface{}{}
+	patterns := []string{}
+	for _, pb := range priceBounds.categories {
+		like := likePattern(pb.Items)
+		patterns = append(patterns, like)
+		if cond, condArgs := pb.outside(); cond != "" {
+			conds = append(conds, "(item_id LIKE ? ESCAPE '!' and "+cond+")")
+			args = append(append(args, like), condArgs...)
+		}
+	}
+	if cond, condArgs := priceBounds.global.outside(); cond != "" {
+		for _, like := range patterns {
+			cond = "item_id NOT LIKE ? ESCAPE '!' and " + cond
+			condArgs = append([]interface{}{like}, condArgs...)
+		}
+		conds = append(conds, "("+cond+")")
+		args = append(args, condArgs...)
+	}
+	return strings.Join(conds, " or "), args
+}
+
+// plausibleOrders leaves blacklisted orders and, unless the priceBounds
+// feature is off, orders out of their price bounds out of q.
+func plausibleOrders(q *gorm.DB) *gorm.DB {
+	q = blacklist.Exclude(q)
+	if cond, args := implausible(); cond != "" && features.Enabled("priceBounds") {
+		q = q.Where("not ("+cond+")", args...)
+	}
+	return q
+}
+
+// adminHandleImplausibleOrders lists the orders of the last ?age= seconds
+// (default defaultAge) left out for being out of their price bounds, newest
+// first, at most ?limit= (default 100).
+func adminHandleImplausibleOrders(c echo.Context) error {
+	age := ageSetting("prices", "defaultAge")
+	if len(c.QueryParam("age")) > 0 {
+		var err error
+		if age, err = strconv.Atoi(c.QueryParam("age")); err != nil || age < 0 {
+			return c.String(http.StatusBadRequest, "age must be a positive number of seconds")
+		}
+	}
+	limit := 100
+	if len(c.QueryParam("limit")) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(c.QueryParam("limit")); err != nil || limit < 1 {
+			return c.String(http.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+
+	result := []lib.APIImplausibleOrder{}
+	cond, args := implausible()
+	if cond == "" {
+		return renderJSON(c, http.StatusOK, result)
+	}
+	orders := []adslib.ModelMarketOrder{}
+	if err := dbFrom(c).Where("updated_at >= ?", time.Now().Add(-time.Duration(age)*time.Second)).
+		Where(cond, args...).Order("updated_at desc").Limit(limit).Find(&orders).Error; err != nil {
+		return err
+	}
+	for _, o := range orders {
+		result = append(result, lib.APIImplausibleOrder{
+			AlbionID:  o.AlbionID,
+			ItemID:    o.ItemID,
+			City:      o.Location.String(),
+			Price:     int64(o.Price),
+			UpdatedAt: lib.Timestamp(o.UpdatedAt),
+		})
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// apiHandleStatsPriceChanges returns how the average price of every city
+// moved over ?window= (default 24h, at most 30d), from the first
+// market_stats bucket in the window to the latest one.
+func apiHandleStatsPriceChanges(c echo.Context) error {
+	window, err := parseWindow(c.QueryParam("window"), 24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxHistoryWindow))
+	}
+
+	locs := queryLocations(c)
+	statsConn := statsDBFrom(c)
+	itemIDs, err := expandItemIDs(c, c.Param("item"), statsConn.Model(&adslib.ModelMarketStats{}), "stats")
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	since := time.Now().Add(-window)
+	result := []lib.APIPriceChange{}
+	for _, itemID := range itemIDs {
+		stats := []adslib.ModelMarketStats{}
+		if err := statsConn.Where("item_id = ? and location in (?) and timestamp >= ?", itemID, locs, since).Order("timestamp asc").Find(&stats).Error; err != nil {
+			return err
+		}
+		result = append(result, priceChanges(itemID, stats)...)
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// priceChanges compares the first and last bucket of every location, stats
+// must be in timestamp order.
+func priceChanges(itemID string, stats []adslib.ModelMarketStats) []lib.APIPriceChange {
+	byLocation := map[adslib.Location]*lib.APIPriceChange{}
+	order := []adslib.Location{}
+	for _, s := range stats {
+		pc, ok := byLocation[s.Location]
+		if !ok {
+			pc = &lib.APIPriceChange{ItemID: itemID, City: s.Location.String(), From: lib.Timestamp(s.Timestamp), PriceFrom: s.PriceAvg}
+			byLocation[s.Location] = pc
+			order = append(order, s.Location)
+		}
+		pc.To = lib.Timestamp(s.Timestamp)
+		pc.PriceTo = s.PriceAvg
+	}
+
+	result := []lib.APIPriceChange{}
+	for _, l := range order {
+		pc := byLocation[l]
+		pc.Change = pc.PriceTo - pc.PriceFrom
+		if pc.PriceFrom != 0 {
+			percent := pc.Change / pc.PriceFrom * 100
+			pc.ChangePercent = &percent
+		}
+		result = append(result, *pc)
+	}
+	return result
+}
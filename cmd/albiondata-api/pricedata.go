@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// cachedPrices is what getStatsPricesItem keeps in respCache, the results
+// with the meta the query filled in.
+type cachedPrices struct {
+	Result            []lib.APIStatsPricesItem
+	LocationsResolved []string
+	ItemsResolved     []string
+	AgeApplied        int
+	Aliases           map[string]string
+	UpstreamRows      string
+}
+
+// refreshRequested reports whether ?refresh=true asks to skip the caches,
+// which only admins may do.
+func refreshRequested(c echo.Context) bool {
+	refresh, _ := strconv.ParseBool(c.QueryParam("refresh"))
+	return refresh
+}
+
+// pricesCacheKey identifies the prices query of a request by everything
+// changing its results, the JSON prices, the view and the endpoints built
+// on them share entries when their params agree.
+func pricesCacheKey(c echo.Context, endpoint string, fields fieldSet, quality int) (string, error) {
+	age, err := ageWindow(c, endpoint)
+	if err != nil {
+		return "", err
+	}
+	names := []string{}
+	for f := range fields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return strings.Join([]string{
+		"prices",
+		c.Param("item"),
+		strings.Join(locationNames(queryLocations(c)), ","),
+		strings.Join(names, ","),
+		strconv.Itoa(quality),
+		strconv.Itoa(age),
+		c.QueryParam("at"),
+		c.QueryParam("includeExpired"),
+		c.QueryParam("lang"),
+		ordersTable(c),
+		fmt.Sprint(useUpstream(c)),
+		databaseName(c),
+		tierFor(c).Name,
+	}, "|"), nil
+}
+
+// getStatsPricesItem is queryStatsPricesItem through respCache, identical
+// prices queries of any endpoint run once per cacheTTL. Explained, debug and
+// admin ?refresh=true requests always query.
+func getStatsPricesItem(c echo.Context, endpoint string, fields fieldSet, quality int) ([]lib.APIStatsPricesItem, error) {
+	ttl := cacheTTL()
+	if ttl <= 0 || requestExplain(c) != nil || requestStats(c) != nil {
+		return queryStatsPricesItem(c, endpoint, fields, quality)
+	}
+	key, err := pricesCacheKey(c, endpoint, fields, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	if !(refreshRequested(c) && isAdmin(c)) {
+		if e, ok := respCache.Get(key); ok {
+			return decodeCachedPrices(c, e.body)
+		}
+	}
+
+	v, err, _ := inflight.Do(key, func() (interface{}, error) {
+		result, err := queryStatsPricesItem(c, endpoint, fields, quality)
+		if err != nil {
+			return nil, err
+		}
+		meta := requestMeta(c)
+		body, err := json.Marshal(cachedPrices{
+			Result:            result,
+			LocationsResolved: meta.LocationsResolved,
+			ItemsResolved:     meta.ItemsResolved,
+			AgeApplied:        meta.AgeApplied,
+			Aliases:           meta.Aliases,
+			UpstreamRows:      c.Response().Header().Get("X-Upstream-Rows"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		respCache.Set(key, cacheEntry{body: body, items: meta.ItemsResolved}, ttl)
+		return body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Every caller decodes its own copy, handlers sort results in place
+	return decodeCachedPrices(c, v.([]byte))
+}
+
+func decodeCachedPrices(c echo.Context, body []byte) ([]lib.APIStatsPricesItem, error) {
+	var cached cachedPrices
+	if err := json.Unmarshal(body, &cached); err != nil {
+		return nil, err
+	}
+	meta := requestMeta(c)
+	meta.LocationsResolved = cached.LocationsResolved
+	meta.ItemsResolved = cached.ItemsResolved
+	meta.AgeApplied = cached.AgeApplied
+	for oldID, newID := range cached.Aliases {
+		noteAlias(c, oldID, newID)
+	}
+	if cached.UpstreamRows != "" {
+		c.Response().Header().Set("X-Upstream-Rows", cached.UpstreamRows)
+	}
+	return cached.Result, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// Item qualities, Normal to Masterpiece
+const (
+	minQuality = 1
+	maxQuality = 5
+)
+
+// parseQuality reads ?quality=, 0 means all qualities.
+func parseQuality(c echo.Context) (int, error) {
+	if len(c.QueryParam("quality")) == 0 {
+		return 0, nil
+	}
+	q, err := strconv.Atoi(c.QueryParam("quality"))
+	if err != nil || q < minQuality || q > maxQuality {
+		return 0, fmt.Errorf("quality must be between %d and %d", minQuality, maxQuality)
+	}
+	return q, nil
+}
+
+// qualityChartSeries builds hourly sell order buckets for one quality
+// between from and to (zero values leave them open). market_stats has no
+// quality column, so these come from market_orders and only reach back
+// qualityChartsDays.
+func qualityChartSeries(conn *gorm.DB, item string, l adslib.Location, quality int, from, to time.Time) (lib.APIStatsChartsLocationResponse, error) {
+	res := lib.APIStatsChartsLocationResponse{}
+	since := time.Now().AddDate(0, 0, -viper.GetInt("qualityChartsDays"))
+	if from.After(since) {
+		since = from
+	}
+
+	q := plausibleOrders(conn).Select("price, updated_at").Where("item_id = ? and location = ? and quality_level = ? and auction_type = ? and updated_at >= ?", item, l, quality, "offer", since)
+	if !to.IsZero() {
+		q = q.Where("updated_at < ?", to)
+	}
+	rows, err := q.Order("updated_at asc").Rows()
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	var bucket time.Time
+	var sum, count, min, max int64
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		res.Timestamps = append(res.Timestamps, bucket.Unix()*1000)
+		res.PricesMin = append(res.PricesMin, min)
+		res.PricesMax = append(res.PricesMax, max)
+		res.PricesAvg = append(res.PricesAvg, float64(sum)/float64(count))
+	}
+
+	// Read row by row, qualityChartsDays of orders can be a lot to hold
+	for rows.Next() {
+		var o struct {
+			Price     int64
+			UpdatedAt time.Time
+		}
+		if err := q.ScanRows(rows, &o); err != nil {
+			return res, err
+		}
+		b := o.UpdatedAt.Truncate(time.Hour)
+		if !b.Equal(bucket) {
+			flush()
+			bucket, sum, count, min, max = b, 0, 0, o.Price, o.Price
+		}
+		sum += o.Price
+		count++
+		if o.Price < min {
+			min = o.Price
+		}
+		if o.Price > max {
+			max = o.Price
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	flush()
+
+	return res, nil
+}
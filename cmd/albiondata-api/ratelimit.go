@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// rateLimiter counts requests per client in fixed windows.
+type rateLimiter interface {
+	// Hit records a request and returns the count in the current window and
+	// when that window ends.
+	Hit(client string, window time.Duration) (int, time.Time, error)
+	// Peek returns the same without recording a request.
+	Peek(client string, window time.Duration) (int, time.Time, error)
+}
+
+// The limiter of the /api routes, nil without rate limiting
+var apiLimiter rateLimiter
+
+func windowStart(window time.Duration) time.Time {
+	return time.Now().Truncate(window)
+}
+
+// memoryRateLimiter keeps the counters of this instance only.
+type memoryRateLimiter struct {
+	sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func (rl *memoryRateLimiter) Hit(client string, window time.Duration) (int, time.Time, error) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	start := windowStart(window)
+	if !start.Equal(rl.start) {
+		rl.start = start
+		rl.counts = map[string]int{}
+	}
+	rl.counts[client]++
+	return rl.counts[client], start.Add(window), nil
+}
+
+func (rl *memoryRateLimiter) Peek(client string, window time.Duration) (int, time.Time, error) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	start := windowStart(window)
+	if !start.Equal(rl.start) {
+		return 0, start.Add(window), nil
+	}
+	return rl.counts[client], start.Add(window), nil
+}
+
+// redisRateLimiter shares the counters between all instances using the same
+// Redis.
+type redisRateLimiter struct {
+	client *redis.Client
+}
+
+func (rl *redisRateLimiter) Hit(client string, window time.Duration) (int, time.Time, error) {
+	start := windowStart(window)
+	key := fmt.Sprintf("albiondata-api:ratelimit:%s:%d", client, start.Unix())
+
+	var incr *redis.IntCmd
+	_, err := rl.client.Pipelined(func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(key)
+		pipe.Expire(key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return int(incr.Val()), start.Add(window), nil
+}
+
+func (rl *redisRateLimiter) Peek(client string, window time.Duration) (int, time.Time, error) {
+	start := windowStart(window)
+	key := fmt.Sprintf("albiondata-api:ratelimit:%s:%d", client, start.Unix())
+
+	count, err := rl.client.Get(key).Int()
+	if err == redis.Nil {
+		return 0, start.Add(window), nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, start.Add(window), nil
+}
+
+func newRateLimiter() (rateLimiter, error) {
+	switch viper.GetString("rateLimitBackend") {
+	case "memory":
+		return &memoryRateLimiter{}, nil
+	case "redis":
+		client, err := redisClient()
+		if err != nil {
+			return nil, fmt.Errorf("rate limit redis: %v", err)
+		}
+		return &redisRateLimiter{client: client}, nil
+	default:
+		return nil, fmt.Errorf("rateLimitBackend must be one of memory, redis")
+	}
+}
+
+// rateLimit allows the tier's rate limit (or the tenant's, see tenants.go)
+// of requests per --rateLimitWindow seconds for every client on the /api
+// routes.
+func rateLimit(limiter rateLimiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit := tierFor(c).RateLimit
+			if t, ok := tenantFor(c); ok && t.RateLimit > 0 {
+				limit = t.RateLimit
+			}
+			if limit <= 0 || !strings.HasPrefix(c.Request().URL.Path, "/api/") || c.Path() == usagePath || isInternal(c) {
+				return next(c)
+			}
+			window := time.Duration(viper.GetInt("rateLimitWindow")) * time.Second
+
+			count, reset, err := limiter.Hit(rateLimitClient(c), window)
+			if err != nil {
+				// Don't take the API down with the limiter
+				fmt.Printf("Rate limiter: %v\n", err)
+				return next(c)
+			}
+
+			remaining := limit - count
+			if remaining < 0 {
+				remaining = 0
+			}
+			h := c.Response().Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+			if count > limit {
+				h.Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+				return c.String(http.StatusTooManyRequests, "Rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
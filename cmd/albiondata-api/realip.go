@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// parseTrustedProxies parses a comma separated list of IPs and CIDRs.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trustedProxies: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustProxies rewrites X-Real-IP to the resolved client address, so
+// c.RealIP() is correct for logging and rate limiting. X-Forwarded-For and
+// X-Real-IP are only honored when the peer is a trusted proxy, the
+// forwarded chain is walked from the right until the first untrusted hop.
+func trustProxies(trusted []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			remote, _, err := net.SplitHostPort(req.RemoteAddr)
+			if err != nil {
+				remote = req.RemoteAddr
+			}
+
+			client := remote
+			if isTrusted(net.ParseIP(remote), trusted) {
+				if xff := req.Header.Get(echo.HeaderXForwardedFor); len(xff) > 0 {
+					hops := strings.Split(xff, ",")
+					for i := len(hops) - 1; i >= 0; i-- {
+						client = strings.TrimSpace(hops[i])
+						if !isTrusted(net.ParseIP(client), trusted) {
+							break
+						}
+					}
+				} else if xri := req.Header.Get(echo.HeaderXRealIP); len(xri) > 0 {
+					client = xri
+				}
+			}
+
+			req.Header.Del(echo.HeaderXForwardedFor)
+			req.Header.Set(echo.HeaderXRealIP, client)
+			return next(c)
+		}
+	}
+}
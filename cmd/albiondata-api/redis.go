@@ -0,0 +1,28 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/spf13/viper"
+)
+
+var (
+	redisOnce sync.Once
+	redisConn *redis.Client
+	redisErr  error
+)
+
+// redisClient returns the client for --redisURL, connecting on first use.
+func redisClient() (*redis.Client, error) {
+	redisOnce.Do(func() {
+		var opt *redis.Options
+		opt, redisErr = redis.ParseURL(viper.GetString("redisURL"))
+		if redisErr != nil {
+			return
+		}
+		redisConn = redis.NewClient(opt)
+		redisErr = redisConn.Ping().Err()
+	})
+	return redisConn, redisErr
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Raw resource refined into each refined resource
+var refinedFrom = map[string]string{
+	"METALBAR":   "ORE",
+	"PLANKS":     "WOOD",
+	"CLOTH":      "FIBER",
+	"LEATHER":    "HIDE",
+	"STONEBLOCK": "ROCK",
+}
+
+// Raw resources needed per refine by tier, every tier above 2 also takes one
+// refined resource of the tier below
+var refiningRawCount = map[int]int{2: 1, 3: 2, 4: 2, 5: 3, 6: 4, 7: 5, 8: 5}
+
+// refiningRecipe returns the materials of one refine of itemID, like
+// T5_METALBAR or T5_METALBAR_LEVEL1@1.
+func refiningRecipe(itemID string) ([]lib.APIMaterialCost, error) {
+	if len(itemID) < 4 || itemID[0] != 'T' || itemID[2] != '_' {
+		return nil, fmt.Errorf("Not a refined resource: %s", itemID)
+	}
+	tier, _ := strconv.Atoi(itemID[1:2])
+	name := itemID[3:]
+	enchant := ""
+	if i := strings.Index(name, "_LEVEL"); i >= 0 {
+		name, enchant = name[:i], name[i:]
+	}
+	raw, ok := refinedFrom[name]
+	if !ok || refiningRawCount[tier] == 0 {
+		return nil, fmt.Errorf("Not a refined resource: %s", itemID)
+	}
+
+	materials := []lib.APIMaterialCost{
+		{ItemID: fmt.Sprintf("T%d_%s%s", tier, raw, enchant), Count: refiningRawCount[tier]},
+	}
+	if tier > 2 {
+		materials = append(materials, lib.APIMaterialCost{ItemID: fmt.Sprintf("T%d_%s", tier-1, name), Count: 1})
+	}
+	return materials, nil
+}
+
+// apiHandleCalcRefining returns the profit of refining one item per city,
+// buying the materials and selling the result at the cheapest sell orders.
+// ?returnRate= is the percentage of materials given back, the
+// refiningReturnRate config by default. Cities missing a price are left out.
+func apiHandleCalcRefining(c echo.Context) error {
+	raw := c.Param("item")
+	if strings.ContainsAny(raw, ",*") {
+		return c.String(http.StatusBadRequest, "Only a single item can be asked for")
+	}
+	itemID, err := resolveItemName(c, raw)
+	if err != nil {
+		return paramError(c, err)
+	}
+	materials, err := refiningRecipe(itemID)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	returnRate := viper.GetFloat64("refiningReturnRate")
+	if len(c.QueryParam("returnRate")) > 0 {
+		returnRate, err = strconv.ParseFloat(c.QueryParam("returnRate"), 64)
+		if err != nil || returnRate < 0 || returnRate >= 100 {
+			return c.String(http.StatusBadRequest, "returnRate must be a percentage from 0 to below 100")
+		}
+	}
+
+	ids := []string{itemID}
+	for _, m := range materials {
+		ids = append(ids, m.ItemID)
+	}
+	c.SetParamNames("item")
+	c.SetParamValues(strings.Join(ids, ","))
+	prices, err := getStatsPricesItem(c, "prices", fieldSet{"sell_price_min": true}, 0)
+	if err != nil {
+		return paramError(c, err)
+	}
+	sellPrice := map[string]map[string]int64{}
+	for _, p := range prices {
+		if sellPrice[p.City] == nil {
+			sellPrice[p.City] = map[string]int64{}
+		}
+		sellPrice[p.City][p.ItemID] = p.SellPriceMin
+	}
+
+	result := []lib.APIRefiningResult{}
+	for _, l := range queryLocations(c) {
+		city := sellPrice[l.String()]
+		r := lib.APIRefiningResult{
+			ItemID:     itemID,
+			City:       l.String(),
+			ReturnRate: returnRate,
+			SellPrice:  city[itemID],
+			Materials:  []lib.APIMaterialCost{},
+		}
+		if r.SellPrice == 0 {
+			continue
+		}
+		complete := true
+		materialCost := 0.0
+		for _, m := range materials {
+			m.Price = city[m.ItemID]
+			if m.Price == 0 {
+				complete = false
+			}
+			materialCost += float64(int64(m.Count) * m.Price)
+			r.Materials = append(r.Materials, m)
+		}
+		if !complete {
+			continue
+		}
+		r.Cost = materialCost * (1 - returnRate/100)
+		r.Profit = float64(r.SellPrice) - r.Cost
+		r.ProfitPercent = r.Profit / r.Cost * 100
+		result = append(result, r)
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
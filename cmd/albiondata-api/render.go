@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// Arrays with at least this many elements are written element by element
+const streamJSONMinLength = 500
+
+// JavaScript names accepted as ?callback=, anything else could inject code
+var jsonpCallbackName = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// fieldsCase returns the JSON key casing requested by the client, either by
+// the fields_case query param or by the Accept-Profile header.
+func fieldsCase(c echo.Context) string {
+	if fc := c.QueryParam("fields_case"); len(fc) > 0 {
+		return strings.ToLower(fc)
+	}
+	if strings.Contains(strings.ToLower(c.Request().Header.Get("Accept-Profile")), "camel") {
+		return "camel"
+	}
+	return "snake"
+}
+
+// renderJSON writes i as JSON, applying the response options the client
+// asked for.
+func renderJSON(c echo.Context, code int, i interface{}) error {
+	if len(hooks) > 0 && code == http.StatusOK {
+		i = enrichResponse(c, i)
+	}
+	if metaRequested(c) {
+		i = withMeta(c, i)
+	}
+	mode, err := int64Mode(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if mode == "string" {
+		i = int64Strings(reflect.ValueOf(i))
+	}
+
+	if i, err = localizeCities(c, i); err != nil {
+		return err
+	}
+
+	callback := c.QueryParam("callback")
+	if len(callback) > 0 && viper.GetBool("jsonp") && !jsonpCallbackName.MatchString(callback) {
+		return c.String(http.StatusBadRequest, "callback must be a JavaScript function name")
+	}
+
+	switch fieldsCase(c) {
+	case "snake":
+		return writeJSON(c, code, i)
+	case "camel":
+		b, err := json.Marshal(i)
+		if err != nil {
+			return err
+		}
+
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+
+		return writeJSON(c, code, camelKeys(v))
+	default:
+		return c.String(http.StatusBadRequest, "fields_case must be one of camel, snake")
+	}
+}
+
+// writeJSON sends i as JSON, wrapped in the ?callback= function when JSONP
+// is enabled.
+func writeJSON(c echo.Context, code int, i interface{}) error {
+	if callback := c.QueryParam("callback"); len(callback) > 0 && viper.GetBool("jsonp") {
+		return c.JSONP(code, callback, i)
+	}
+	// json.RawMessage from hooks is a slice too, but of bytes
+	if v := reflect.ValueOf(i); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 && v.Len() >= streamJSONMinLength {
+		if _, pretty := c.QueryParams()["pretty"]; !pretty {
+			return streamJSONArray(c, code, v)
+		}
+	}
+	return c.JSON(code, i)
+}
+
+// streamJSONArray encodes the elements of a large slice one at a time
+// straight into the response, the whole array is never marshaled at once.
+// Every streamJSONMinLength elements are flushed to the client, a response
+// cacheResponse would buffer bypasses the cache instead.
+func streamJSONArray(c echo.Context, code int, v reflect.Value) error {
+	res := c.Response()
+	if bw, ok := res.Writer.(*bufferWriter); ok {
+		if err := bw.bypass(); err != nil {
+			return err
+		}
+	}
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	res.WriteHeader(code)
+
+	if _, err := res.Write([]byte("[")); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(res)
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			if _, err := res.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+		if f, ok := res.Writer.(http.Flusher); ok && (i+1)%streamJSONMinLength == 0 {
+			f.Flush()
+		}
+	}
+	_, err := res.Write([]byte("]"))
+	return err
+}
+
+// camelKeys recursively converts snake_case object keys to camelCase.
+func camelKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			res[snakeToCamel(k)] = camelKeys(val)
+		}
+		return res
+	case []interface{}:
+		for i, val := range t {
+			t[i] = camelKeys(val)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) > 0 {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const (
+	defaultFeedLimit = 1000
+	maxFeedLimit     = 5000
+)
+
+func feedOrder(m adslib.ModelMarketOrder) lib.APIFeedOrder {
+	return lib.APIFeedOrder{
+		ID:               m.ID,
+		AlbionID:         m.AlbionID,
+		ItemID:           m.ItemID,
+		QualityLevel:     m.QualityLevel,
+		EnchantmentLevel: m.EnchantmentLevel,
+		Price:            int64(m.Price),
+		InitialAmount:    int64(m.InitialAmount),
+		Amount:           int64(m.Amount),
+		AuctionType:      m.AuctionType,
+		Expires:          m.Expires,
+		Location:         int(m.Location),
+		CreatedAt:        m.CreatedAt,
+		UpdatedAt:        m.UpdatedAt,
+	}
+}
+
+// orderFromFeed turns a feed order back into a row for the local database, the id
+// is left to the database.
+func orderFromFeed(o lib.APIFeedOrder) adslib.ModelMarketOrder {
+	m := adslib.NewModelMarketOrder()
+	m.AlbionID = o.AlbionID
+	m.ItemID = o.ItemID
+	m.QualityLevel = o.QualityLevel
+	m.EnchantmentLevel = o.EnchantmentLevel
+	m.Price = int(o.Price)
+	m.InitialAmount = int(o.InitialAmount)
+	m.Amount = int(o.Amount)
+	m.AuctionType = o.AuctionType
+	m.Expires = o.Expires
+	m.Location = adslib.Location(o.Location)
+	m.CreatedAt = o.CreatedAt
+	m.UpdatedAt = o.UpdatedAt
+	return m
+}
+
+// apiHandleExportOrders is the replication feed mirrors sync from, the
+// orders updated after the ?since= and ?after_id= cursor in update order, at
+// most ?limit=. The response carries the cursor of the next page.
+func apiHandleExportOrders(c echo.Context) error {
+	since := time.Time{}
+	if len(c.QueryParam("since")) > 0 {
+		var err error
+		if since, err = parseSince(c.QueryParam("since")); err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+	}
+	afterID := uint64(0)
+	if len(c.QueryParam("after_id")) > 0 {
+		var err error
+		if afterID, err = strconv.ParseUint(c.QueryParam("after_id"), 10, 64); err != nil {
+			return c.String(http.StatusBadRequest, "after_id must be a number")
+		}
+	}
+	limit := defaultFeedLimit
+	if len(c.QueryParam("limit")) > 0 {
+		l, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || l <= 0 || l > maxFeedLimit {
+			return c.String(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxFeedLimit))
+		}
+		limit = l
+	}
+
+	orders := []adslib.ModelMarketOrder{}
+	if err := dbFrom(c).Where("updated_at > ? or (updated_at = ? and id > ?)", since, since, afterID).
+		Order("updated_at asc, id asc").Limit(limit + 1).Find(&orders).Error; err != nil {
+		return err
+	}
+
+	return renderJSON(c, http.StatusOK, orderFeed(orders, since, afterID, limit))
+}
+
+// orderFeed builds a page of the feed from up to limit+1 orders read after
+// the cursor, the extra one only tells there are more. The cursor of the
+// next page is the last order of this one, or the same cursor when it's
+// empty.
+func orderFeed(orders []adslib.ModelMarketOrder, since time.Time, afterID uint64, limit int) lib.APIOrderFeed {
+	result := lib.APIOrderFeed{
+		Orders:    []lib.APIFeedOrder{},
+		NextSince: since,
+		NextAfter: uint(afterID),
+	}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		result.More = true
+	}
+	for _, m := range orders {
+		result.Orders = append(result.Orders, feedOrder(m))
+		result.NextSince = m.UpdatedAt
+		result.NextAfter = m.ID
+	}
+	return result
+}
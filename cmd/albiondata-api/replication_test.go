@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func feedRow(id uint, updatedAt time.Time) adslib.ModelMarketOrder {
+	m := adslib.ModelMarketOrder{}
+	m.ID = id
+	m.AlbionID = id
+	m.UpdatedAt = updatedAt
+	return m
+}
+
+func TestOrderFeedCursor(t *testing.T) {
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1, t2 := since.Add(time.Minute), since.Add(2*time.Minute)
+	rows := []adslib.ModelMarketOrder{feedRow(5, t1), feedRow(6, t1), feedRow(7, t2)}
+
+	tests := []struct {
+		name      string
+		orders    []adslib.ModelMarketOrder
+		limit     int
+		wantLen   int
+		wantMore  bool
+		wantSince time.Time
+		wantAfter uint
+	}{
+		{"empty keeps the cursor", nil, 2, 0, false, since, 3},
+		{"more ends at the last order kept", rows, 2, 2, true, t1, 6},
+		{"last page", rows, 3, 3, false, t2, 7},
+	}
+	for _, tt := range tests {
+		feed := orderFeed(tt.orders, since, 3, tt.limit)
+		if feed.Orders == nil || len(feed.Orders) != tt.wantLen {
+			t.Errorf("%s: %d orders, want %d", tt.name, len(feed.Orders), tt.wantLen)
+		}
+		if feed.More != tt.wantMore {
+			t.Errorf("%s: more = %v, want %v", tt.name, feed.More, tt.wantMore)
+		}
+		if !feed.NextSince.Equal(tt.wantSince) || feed.NextAfter != tt.wantAfter {
+			t.Errorf("%s: cursor = %v, %d, want %v, %d", tt.name, feed.NextSince, feed.NextAfter, tt.wantSince, tt.wantAfter)
+		}
+	}
+}
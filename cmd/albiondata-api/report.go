@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func init() {
+	viper.SetDefault("jobs.marketReport.limit", 10)
+}
+
+// Report periods by name, as in /api/v1/reports/:period
+var reportPeriods = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// Discord rejects messages of more characters
+const discordMaxLength = 2000
+
+// Highs and lows are compared to this much history before the period
+const reportHistory = 30 * 24 * time.Hour
+
+// reportStore keeps the reports built by the marketReport job.
+type reportStore struct {
+	sync.Mutex
+	reports map[string]lib.APIMarketReport
+}
+
+var reports = &reportStore{reports: map[string]lib.APIMarketReport{}}
+
+func (rs *reportStore) Get(period string) (lib.APIMarketReport, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	r, ok := rs.reports[period]
+	return r, ok
+}
+
+func (rs *reportStore) Set(period string, r lib.APIMarketReport) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.reports[period] = r
+}
+
+// buildReport summarizes the market over the period up to now: the biggest
+// moves of the average price, prices above or below anything of the 30
+// days before, and the markets and items with the most orders. Each list
+// holds at most jobs.marketReport.limit entries.
+func buildReport(name string, period time.Duration) (lib.APIMarketReport, error) {
+	limit := viper.GetInt("jobs.marketReport.limit")
+	to := time.Now()
+	from := to.Add(-period)
+	report := lib.APIMarketReport{
+		Period:    name,
+		From:      lib.Timestamp(from),
+		To:        lib.Timestamp(to),
+		Movers:    []lib.APIPriceChange{},
+		Highs:     []lib.APIPriceRecord{},
+		Lows:      []lib.APIPriceRecord{},
+		Markets:   []lib.APIMarketActivity{},
+		TopItems:  []lib.APIItemActivity{},
+		Generated: lib.Timestamp(to),
+	}
+
+	// Price moves and the range within the period, market_stats in time order
+	stats := tableDB("stats")
+	rows, err := stats.Model(&adslib.ModelMarketStats{}).Where("timestamp >= ?", from).Order("timestamp asc").Rows()
+	if err != nil {
+		return report, err
+	}
+	type key struct {
+		itemID   string
+		location adslib.Location
+	}
+	moves := map[key]*lib.APIPriceChange{}
+	ranges := map[key][2]int64{}
+	for rows.Next() {
+		var s adslib.ModelMarketStats
+		if err := stats.ScanRows(rows, &s); err != nil {
+			rows.Close()
+			return report, err
+		}
+		k := key{s.ItemID, s.Location}
+		pc, ok := moves[k]
+		if !ok {
+			pc = &lib.APIPriceChange{ItemID: s.ItemID, City: s.Location.String(), From: lib.Timestamp(s.Timestamp), PriceFrom: s.PriceAvg}
+			moves[k] = pc
+			ranges[k] = [2]int64{int64(s.PriceMin), int64(s.PriceMax)}
+		}
+		pc.To = lib.Timestamp(s.Timestamp)
+		pc.PriceTo = s.PriceAvg
+		r := ranges[k]
+		if s.PriceMin > 0 && int64(s.PriceMin) < r[0] {
+			r[0] = int64(s.PriceMin)
+		}
+		if int64(s.PriceMax) > r[1] {
+			r[1] = int64(s.PriceMax)
+		}
+		ranges[k] = r
+	}
+	rows.Close()
+
+	for _, pc := range moves {
+		if pc.PriceFrom == 0 || time.Time(pc.From).Equal(time.Time(pc.To)) {
+			continue
+		}
+		pc.Change = pc.PriceTo - pc.PriceFrom
+		percent := pc.Change / pc.PriceFrom * 100
+		pc.ChangePercent = &percent
+		report.Movers = append(report.Movers, *pc)
+	}
+	sort.Slice(report.Movers, func(i, j int) bool {
+		return math.Abs(*report.Movers[i].ChangePercent) > math.Abs(*report.Movers[j].ChangePercent)
+	})
+	if len(report.Movers) > limit {
+		report.Movers = report.Movers[:limit]
+	}
+
+	// Highs and lows against the history before the period
+	history := []struct {
+		ItemID   string
+		Location adslib.Location
+		PriceMin int64
+		PriceMax int64
+	}{}
+	if err := stats.Model(&adslib.ModelMarketStats{}).
+		Select("item_id, location, min(price_min) as price_min, max(price_max) as price_max").
+		Where("timestamp >= ? and timestamp < ?", from.Add(-reportHistory), from).
+		Group("item_id, location").Scan(&history).Error; err != nil {
+		return report, err
+	}
+	for _, h := range history {
+		r, ok := ranges[key{h.ItemID, h.Location}]
+		if !ok {
+			continue
+		}
+		if h.PriceMax > 0 && r[1] > h.PriceMax {
+			report.Highs = append(report.Highs, lib.APIPriceRecord{ItemID: h.ItemID, City: h.Location.String(), Price: r[1], Previous: h.PriceMax})
+		}
+		if h.PriceMin > 0 && r[0] > 0 && r[0] < h.PriceMin {
+			report.Lows = append(report.Lows, lib.APIPriceRecord{ItemID: h.ItemID, City: h.Location.String(), Price: r[0], Previous: h.PriceMin})
+		}
+	}
+	byMargin := func(records []lib.APIPriceRecord) {
+		sort.Slice(records, func(i, j int) bool {
+			return math.Abs(float64(records[i].Price-records[i].Previous))/float64(records[i].Previous) >
+				math.Abs(float64(records[j].Price-records[j].Previous))/float64(records[j].Previous)
+		})
+	}
+	byMargin(report.Highs)
+	byMargin(report.Lows)
+	if len(report.Highs) > limit {
+		report.Highs = report.Highs[:limit]
+	}
+	if len(report.Lows) > limit {
+		report.Lows = report.Lows[:limit]
+	}
+
+	// Activity, orders uploaded in the period
+	orders := plausibleOrders(partitionPrune(db.Table(adslib.NewModelMarketOrder().TableName()), from)).Where("created_at >= ?", from)
+	markets := []struct {
+		Location adslib.Location
+		Orders   int
+		Items    int
+	}{}
+	if err := orders.Select("location, count(*) as orders, count(distinct item_id) as items").
+		Group("location").Order("orders desc").Limit(limit).Scan(&markets).Error; err != nil {
+		return report, err
+	}
+	for _, m := range markets {
+		report.Markets = append(report.Markets, lib.APIMarketActivity{City: m.Location.String(), Orders: m.Orders, Items: m.Items})
+	}
+	topItems := []struct {
+		ItemID string
+		Orders int
+	}{}
+	if err := orders.Select("item_id, count(*) as orders").
+		Group("item_id").Order("orders desc").Limit(limit).Scan(&topItems).Error; err != nil {
+		return report, err
+	}
+	for _, i := range topItems {
+		report.TopItems = append(report.TopItems, lib.APIItemActivity{ItemID: i.ItemID, Orders: i.Orders})
+	}
+	return report, nil
+}
+
+// reportDue reports whether the report of the period has to be built: none
+// is stored, or it is from before the period started again, at midnight for
+// the daily report and Monday's midnight for the weekly one.
+func reportDue(name string, now time.Time) bool {
+	r, ok := reports.Get(name)
+	if !ok {
+		return true
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if name == "weekly" {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return time.Time(r.Generated).Before(start)
+}
+
+// currentReport returns the stored report of the period, built and stored
+// first when it's due.
+func currentReport(name string) (lib.APIMarketReport, error) {
+	if !reportDue(name, time.Now()) {
+		r, _ := reports.Get(name)
+		return r, nil
+	}
+	r, err := buildReport(name, reportPeriods[name])
+	if err != nil {
+		return r, err
+	}
+	reports.Set(name, r)
+	return r, nil
+}
+
+// jobMarketReport builds the daily report, and the weekly one when it's due
+// (on Mondays, or when there's none yet), and posts a summary of each to
+// jobs.marketReport.discordWebhook when it's set.
+func jobMarketReport(e *echo.Echo) error {
+	names := []string{"daily"}
+	if reportDue("weekly", time.Now()) {
+		names = append(names, "weekly")
+	}
+	for _, name := range names {
+		report, err := buildReport(name, reportPeriods[name])
+		if err != nil {
+			return err
+		}
+		reports.Set(name, report)
+		if url := viper.GetString("jobs.marketReport.discordWebhook"); url != "" {
+			if err := postReportSummary(url, report); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reportSummary is the report as short text, for chat messages.
+func reportSummary(r lib.APIMarketReport) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**Albion market %s report** (%s to %s)\n", r.Period, r.From, r.To)
+	if len(r.Movers) > 0 {
+		sb.WriteString("Biggest moves:\n")
+		for _, m := range r.Movers {
+			fmt.Fprintf(&sb, "- %s in %s: %.0f → %.0f (%+.1f%%)\n", m.ItemID, m.City, m.PriceFrom, m.PriceTo, *m.ChangePercent)
+		}
+	}
+	if len(r.Markets) > 0 {
+		sb.WriteString("Most active markets:\n")
+		for _, m := range r.Markets {
+			fmt.Fprintf(&sb, "- %s: %d orders of %d items\n", m.City, m.Orders, m.Items)
+		}
+	}
+	return sb.String()
+}
+
+func postReportSummary(url string, r lib.APIMarketReport) error {
+	content := reportSummary(r)
+	if utf8.RuneCountInString(content) > discordMaxLength {
+		content = string([]rune(content)[:discordMaxLength-3]) + "..."
+	}
+	b, err := json.Marshal(map[string]string{"content": content})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	res, err := client.Post(url, echo.MIMEApplicationJSON, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Report webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// apiHandleReport serves the latest report of the period, built now and
+// kept if it's due. ?format=html renders it as a page.
+func apiHandleReport(c echo.Context) error {
+	name := c.Param("period")
+	if _, ok := reportPeriods[name]; !ok {
+		return c.String(http.StatusNotFound, "period must be one of daily, weekly")
+	}
+	report, err := currentReport(name)
+	if err != nil {
+		return err
+	}
+
+	switch c.QueryParam("format") {
+	case "", "json":
+		return renderJSON(c, http.StatusOK, report)
+	case "html":
+		return c.HTML(http.StatusOK, reportHTML(report))
+	default:
+		return c.String(http.StatusBadRequest, "format must be one of json, html")
+	}
+}
+
+func reportHTML(r lib.APIMarketReport) string {
+	var sb strings.Builder
+	esc := html.EscapeString
+	fmt.Fprintf(&sb, "<html>\n\t<head>\n\t\t<title>Market %s report</title>\n\t</head>\n\t<body>\n", esc(r.Period))
+	fmt.Fprintf(&sb, "\t\t<h1>Market %s report</h1>\n\t\t<p>%s to %s</p>\n", esc(r.Period), r.From, r.To)
+
+	sb.WriteString("\t\t<h2>Biggest moves</h2>\n\t\t<table>\n\t\t\t<tr><th>item_id</th><th>city</th><th>from</th><th>to</th><th>change</th></tr>\n")
+	for _, m := range r.Movers {
+		fmt.Fprintf(&sb, "\t\t\t<tr><td>%s</td><td>%s</td><td>%.0f</td><td>%.0f</td><td>%+.1f%%</td></tr>\n", esc(m.ItemID), esc(m.City), m.PriceFrom, m.PriceTo, *m.ChangePercent)
+	}
+	sb.WriteString("\t\t</table>\n")
+
+	for _, part := range []struct {
+		title   string
+		records []lib.APIPriceRecord
+	}{{"New highs", r.Highs}, {"New lows", r.Lows}} {
+		fmt.Fprintf(&sb, "\t\t<h2>%s</h2>\n\t\t<table>\n\t\t\t<tr><th>item_id</th><th>city</th><th>price</th><th>previous</th></tr>\n", part.title)
+		for _, rec := range part.records {
+			fmt.Fprintf(&sb, "\t\t\t<tr><td>%s</td><td>%s</td><td>%d</td><td>%d</td></tr>\n", esc(rec.ItemID), esc(rec.City), rec.Price, rec.Previous)
+		}
+		sb.WriteString("\t\t</table>\n")
+	}
+
+	sb.WriteString("\t\t<h2>Most active markets</h2>\n\t\t<table>\n\t\t\t<tr><th>city</th><th>orders</th><th>items</th></tr>\n")
+	for _, m := range r.Markets {
+		fmt.Fprintf(&sb, "\t\t\t<tr><td>%s</td><td>%d</td><td>%d</td></tr>\n", esc(m.City), m.Orders, m.Items)
+	}
+	sb.WriteString("\t\t</table>\n")
+
+	sb.WriteString("\t\t<h2>Most traded items</h2>\n\t\t<table>\n\t\t\t<tr><th>item_id</th><th>orders</th></tr>\n")
+	for _, i := range r.TopItems {
+		fmt.Fprintf(&sb, "\t\t\t<tr><td>%s</td><td>%d</td></tr>\n", esc(i.ItemID), i.Orders)
+	}
+	sb.WriteString("\t\t</table>\n\t</body>\n</html>\n")
+	return sb.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tikz/albiondata-api/lib"
+)
+
+func TestReportDue(t *testing.T) {
+	defer func(orig map[string]lib.APIMarketReport) { reports.reports = orig }(reports.reports)
+	reports.reports = map[string]lib.APIMarketReport{}
+
+	// A Wednesday
+	now := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+	if !reportDue("weekly", now) {
+		t.Error("weekly: not due without a stored report")
+	}
+	tests := []struct {
+		name      string
+		generated time.Time
+		want      bool
+	}{
+		{"daily", time.Date(2024, 5, 15, 0, 0, 1, 0, time.UTC), false},
+		{"daily", time.Date(2024, 5, 14, 23, 59, 0, 0, time.UTC), true},
+		{"weekly", time.Date(2024, 5, 13, 0, 0, 1, 0, time.UTC), false},
+		{"weekly", time.Date(2024, 5, 12, 23, 59, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		reports.Set(tt.name, lib.APIMarketReport{Period: tt.name, Generated: lib.Timestamp(tt.generated)})
+		if got := reportDue(tt.name, now); got != tt.want {
+			t.Errorf("%s generated %s: due = %v, want %v", tt.name, tt.generated, got, tt.want)
+		}
+	}
+}
+
+func TestPostReportSummaryLength(t *testing.T) {
+	var content string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		content = body["content"]
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// Every line has a multi-byte arrow, so a cut by bytes splits one
+	report := lib.APIMarketReport{Period: "daily"}
+	for i := 0; i < 100; i++ {
+		percent := float64(i)
+		report.Movers = append(report.Movers, lib.APIPriceChange{ItemID: "T4_BAG", City: "Fort Sterling", PriceFrom: 1000, PriceTo: 2000, ChangePercent: &percent})
+	}
+	if err := postReportSummary(srv.URL, report); err != nil {
+		t.Fatal(err)
+	}
+	if n := utf8.RuneCountInString(content); n != discordMaxLength {
+		t.Errorf("content has %d characters, want %d", n, discordMaxLength)
+	}
+	if strings.ContainsRune(content, utf8.RuneError) || !strings.HasSuffix(content, "...") {
+		t.Errorf("content wasn't cut on a character: %q", content[len(content)-20:])
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// requestLogEntry is what requestLog writes for a sampled request.
+type requestLogEntry struct {
+	Time       string            `json:"time"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Params     map[string]string `json:"params,omitempty"`
+	Query      string            `json:"query,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	APIKey     string            `json:"api_key,omitempty"`
+	Status     int               `json:"status"`
+	Size       int64             `json:"size"`
+	DurationMs float64           `json:"duration_ms"`
+}
+
+// requestLog prints one JSON line for a requestLogSampleRate share of the
+// API requests, with their params and the status and size of the response.
+// Bodies are never logged, and only status and size of anything outside of
+// /api/.
+func requestLog(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rate := viper.GetFloat64("requestLogSampleRate")
+		if rate <= 0 || rand.Float64() >= rate {
+			return next(c)
+		}
+
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		entry := requestLogEntry{
+			Time:       start.UTC().Format(time.RFC3339),
+			Method:     c.Request().Method,
+			Route:      c.Path(),
+			Status:     c.Response().Status,
+			Size:       c.Response().Size,
+			DurationMs: float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+		}
+		if strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			entry.Params = map[string]string{}
+			for i, name := range c.ParamNames() {
+				if i < len(c.ParamValues()) {
+					entry.Params[name] = c.ParamValues()[i]
+				}
+			}
+			entry.Query = c.QueryString()
+			entry.UserAgent = c.Request().UserAgent()
+			// The key name only, never the key
+			if k, ok := c.Get("apiKey").(apiKey); ok {
+				entry.APIKey = k.Name
+			}
+		}
+
+		if b, jerr := json.Marshal(entry); jerr == nil {
+			fmt.Printf("request_log %s\n", b)
+		}
+		return nil
+	}
+}
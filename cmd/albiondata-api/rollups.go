@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+func init() {
+	viper.SetDefault("jobs.statsCompaction.rawDays", 90)
+}
+
+// statsRollup is a day of market_stats of one item and location, the
+// statsCompaction job moves days older than jobs.statsCompaction.rawDays
+// into market_stats_daily.
+type statsRollup struct {
+	ID        uint            `gorm:"primary_key"`
+	ItemID    string          `gorm:"index:idx_market_stats_daily"`
+	Location  adslib.Location `gorm:"index:idx_market_stats_daily"`
+	PriceMin  int
+	PriceMax  int
+	PriceAvg  float64
+	Timestamp time.Time `gorm:"index"`
+}
+
+func (statsRollup) TableName() string {
+	return "market_stats_daily"
+}
+
+// migrateRollups creates the market_stats_daily table if needed, next to
+// market_stats.
+func migrateRollups() error {
+	return tableDB("stats").AutoMigrate(&statsRollup{}).Error
+}
+
+// rollupBoundary is the first day still kept hourly in market_stats, every
+// day before it is in market_stats_daily. It's zero without compaction.
+func rollupBoundary(statsConn *gorm.DB) time.Time {
+	if !viper.GetBool("jobs.statsCompaction.enabled") || !statsConn.HasTable(&statsRollup{}) {
+		return time.Time{}
+	}
+	latest := statsRollup{}
+	if err := statsConn.Order("timestamp desc").First(&latest).Error; err != nil {
+		return time.Time{}
+	}
+	return latest.Timestamp.Add(24 * time.Hour)
+}
+
+// jobStatsCompaction rolls every day of market_stats older than
+// jobs.statsCompaction.rawDays up into one row per item and location, oldest
+// day first, and deletes its hourly rows.
+func jobStatsCompaction(e *echo.Echo) error {
+	rawDays := viper.GetInt("jobs.statsCompaction.rawDays")
+	// Other endpoints read up to maxHistoryWindow of hourly stats
+	if time.Duration(rawDays)*24*time.Hour <= maxHistoryWindow {
+		return fmt.Errorf("jobs.statsCompaction.rawDays must be more than %d", int(maxHistoryWindow.Hours()/24))
+	}
+	cutoff := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -rawDays)
+
+	stats := tableDB("stats")
+	oldest := adslib.ModelMarketStats{}
+	q := stats.Where("timestamp < ?", cutoff).Order("timestamp asc").First(&oldest)
+	if q.RecordNotFound() {
+		return nil
+	}
+	if q.Error != nil {
+		return q.Error
+	}
+
+	for day := oldest.Timestamp.UTC().Truncate(24 * time.Hour); day.Before(cutoff); day = day.Add(24 * time.Hour) {
+		if err := compactStatsDay(stats, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactStatsDay writes the rollups of the day and deletes its hourly
+// stats in one transaction. Items and locations already rolled up for the
+// day are left as they are.
+func compactStatsDay(stats *gorm.DB, day time.Time) error {
+	rows := []struct {
+		ItemID   string
+		Location adslib.Location
+		PriceMin int
+		PriceMax int
+		PriceAvg float64
+	}{}
+	if err := stats.Model(&adslib.ModelMarketStats{}).
+		Select("item_id, location, min(price_min) as price_min, max(price_max) as price_max, avg(price_avg) as price_avg").
+		Where("timestamp >= ? and timestamp < ?", day, day.Add(24*time.Hour)).
+		Group("item_id, location").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	existing := []statsRollup{}
+	if err := stats.Where("timestamp = ?", day).Find(&existing).Error; err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	for _, r := range existing {
+		done[fmt.Sprintf("%s|%d", r.ItemID, r.Location)] = true
+	}
+
+	tx := stats.Begin()
+	for _, r := range rows {
+		if done[fmt.Sprintf("%s|%d", r.ItemID, r.Location)] {
+			continue
+		}
+		rollup := statsRollup{
+			ItemID:    r.ItemID,
+			Location:  r.Location,
+			PriceMin:  r.PriceMin,
+			PriceMax:  r.PriceMax,
+			PriceAvg:  r.PriceAvg,
+			Timestamp: day,
+		}
+		if err := tx.Create(&rollup).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("timestamp >= ? and timestamp < ?", day, day.Add(24*time.Hour)).Delete(adslib.ModelMarketStats{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
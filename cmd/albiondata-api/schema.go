@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Response types with a JSON Schema under /api/v1/schema/:name
+var schemaTypes = map[string]interface{}{
+	"prices":        []lib.APIStatsPricesItem{},
+	"charts":        []lib.APIStatsChartsResponse{},
+	"charts-multi":  []lib.APIStatsChartsItemResponse{},
+	"gold":          lib.APIStatesChartsResponse{},
+	"gold-summary":  lib.APIGoldSummary{},
+	"changes":       lib.APIChangesResponse{},
+	"price-changes": []lib.APIPriceChange{},
+	"metrics":       []lib.APIItemMetrics{},
+	"correlate":     lib.APICorrelation{},
+	"refining":      []lib.APIRefiningResult{},
+	"spread":        []lib.APISpreadSeries{},
+	"report":        lib.APIMarketReport{},
+	"sparkline":     []lib.APISparkline{},
+	"transport":     []lib.APITransportItem{},
+	"contributions": []lib.APIContribution{},
+	"v2-prices":     []lib.APIV2PricesItem{},
+	"v2-charts":     []lib.APIV2ChartsItem{},
+	"market":        lib.APIMarketItem{},
+	"status":        lib.APIStatus{},
+}
+
+var timestampType = reflect.TypeOf(lib.Timestamp{})
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes how encoding/json marshals values of type t.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timestampType, timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := jsonSchema(t.Elem())
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			name := parts[0]
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchema(f.Type)
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// apiHandleSchema serves the JSON Schema of a response type. The property
+// names are the default snake_case ones, see fields_case.
+func apiHandleSchema(c echo.Context) error {
+	v, ok := schemaTypes[c.Param("name")]
+	if !ok {
+		return c.String(http.StatusNotFound, "Unknown schema")
+	}
+
+	s := jsonSchema(reflect.TypeOf(v))
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = c.Param("name")
+	return c.JSON(http.StatusOK, s)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("secrets.vault.field", "password")
+	viper.SetDefault("secrets.vault.jwtSecretField", "secret")
+	viper.SetDefault("secrets.renewInterval", 3600)
+}
+
+// Placeholder in dbURI and the databases profiles replaced by the password
+// of the secrets provider
+const passwordPlaceholder = "{password}"
+
+// secretStore holds the secrets read from secrets.provider, so they never
+// have to be in the config file or the environment.
+type secretStore struct {
+	sync.Mutex
+	dbPassword string
+	jwtSecret  string
+}
+
+var secrets = &secretStore{}
+
+var secretsOnce sync.Once
+
+// loadSecrets reads the secrets once, the first database connection needs
+// them. Without secrets.provider there is nothing to do.
+func loadSecrets() error {
+	var err error
+	secretsOnce.Do(func() {
+		_, err = secrets.Refresh()
+	})
+	return err
+}
+
+// Refresh reads the secrets again and reports if the database password
+// changed.
+func (ss *secretStore) Refresh() (bool, error) {
+	var password, jwtSecret string
+	var err error
+	switch viper.GetString("secrets.provider") {
+	case "":
+		return false, nil
+	case "vault":
+		password, jwtSecret, err = readVaultSecrets()
+	case "file":
+		password, jwtSecret, err = readFileSecrets()
+	default:
+		return false, fmt.Errorf("secrets.provider must be one of vault, file")
+	}
+	if err != nil {
+		return false, fmt.Errorf("secrets: %v", err)
+	}
+
+	ss.Lock()
+	defer ss.Unlock()
+	changed := ss.dbPassword != "" && ss.dbPassword != password
+	ss.dbPassword = password
+	ss.jwtSecret = jwtSecret
+	return changed, nil
+}
+
+// JWTSecret returns the secret HS256 tokens are signed with, jwt.secret
+// unless the provider has one.
+func (ss *secretStore) JWTSecret() string {
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.jwtSecret != "" {
+		return ss.jwtSecret
+	}
+	return viper.GetString("jwt.secret")
+}
+
+// withPassword fills the password placeholder of a connection string,
+// escaped for the DSN format in use.
+func (ss *secretStore) withPassword(dbType string, dsn string) string {
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.dbPassword == "" {
+		return dsn
+	}
+	return fillPassword(dbType, dsn, ss.dbPassword)
+}
+
+// fillPassword replaces the placeholder with the password. postgres:// URLs
+// get it percent-encoded, key=value strings get it single quoted with \ and
+// ' escaped. The MySQL driver splits user:pass@ at the first : and the last @
+// and has no escaping, so the password goes in as it is.
+func fillPassword(dbType string, dsn string, password string) string {
+	switch dbType {
+	case "postgres", "postgresql":
+		if strings.Contains(dsn, "://") {
+			return strings.Replace(dsn, passwordPlaceholder, escapeUserinfo(password), -1)
+		}
+		quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+		dsn = strings.Replace(dsn, "'"+passwordPlaceholder+"'", "'"+quoted+"'", -1)
+		return strings.Replace(dsn, passwordPlaceholder, "'"+quoted+"'", -1)
+	}
+	return strings.Replace(dsn, passwordPlaceholder, password, -1)
+}
+
+// escapeUserinfo percent-encodes everything but the unreserved characters of
+// RFC 3986, url.QueryEscape would turn spaces into + which userinfo keeps.
+func escapeUserinfo(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// readFileSecrets reads files mounted by a secret manager, like Docker or
+// Kubernetes secrets.
+func readFileSecrets() (string, string, error) {
+	read := func(key string) (string, error) {
+		path := viper.GetString(key)
+		if path == "" {
+			return "", nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	password, err := read("secrets.file.dbPassword")
+	if err != nil {
+		return "", "", err
+	}
+	jwtSecret, err := read("secrets.file.jwtSecret")
+	return password, jwtSecret, err
+}
+
+func vaultToken() string {
+	if t := viper.GetString("secrets.vault.token"); t != "" {
+		return t
+	}
+	return os.Getenv("VAULT_TOKEN")
+}
+
+func vaultRequest(method string, path string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(viper.GetString("secrets.vault.address"), "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", vaultToken())
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault answered %s for %s", res.Status, path)
+	}
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// readVaultField reads a field of a KV secret, version 1 or 2.
+func readVaultField(path string, field string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	body, err := vaultRequest(http.MethodGet, path)
+	if err != nil {
+		return "", err
+	}
+	data, _ := body["data"].(map[string]interface{})
+	// KV version 2 wraps the secret in another data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("%s has no field %s", path, field)
+	}
+	return v, nil
+}
+
+func readVaultSecrets() (string, string, error) {
+	password, err := readVaultField(viper.GetString("secrets.vault.dbPasswordPath"), viper.GetString("secrets.vault.field"))
+	if err != nil {
+		return "", "", err
+	}
+	jwtSecret, err := readVaultField(viper.GetString("secrets.vault.jwtSecretPath"), viper.GetString("secrets.vault.jwtSecretField"))
+	return password, jwtSecret, err
+}
+
+// renewSecrets renews the Vault token and reads the secrets again every
+// secrets.renewInterval seconds until ctx is done. A rotated database
+// password reconnects the pools, which is tried again on the next round if
+// it fails.
+func renewSecrets(ctx context.Context) {
+	provider := viper.GetString("secrets.provider")
+	interval := time.Duration(viper.GetInt("secrets.renewInterval")) * time.Second
+	if provider == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		reconnect := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			if provider == "vault" {
+				if _, err := vaultRequest(http.MethodPost, "auth/token/renew-self"); err != nil {
+					fmt.Printf("Can't renew the Vault token: %v\n", err)
+				}
+			}
+			changed, err := secrets.Refresh()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			if changed || reconnect {
+				fmt.Printf("The database password changed, reconnecting\n")
+				if err := reconnectDatabases(); err != nil {
+					fmt.Printf("Can't reconnect to the database: %v\n", err)
+					reconnect = true
+					continue
+				}
+				reconnect = false
+			}
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Setting name carrying the route of the request a query was made for
+const queryRouteKey = "albiondata:route"
+
+// slowQueryLog keeps the last queries slower than slowQueryThreshold.
+type slowQueryLog struct {
+	sync.Mutex
+	entries []lib.APISlowQuery
+	next    int
+	full    bool
+}
+
+var slowQueries = &slowQueryLog{}
+
+func slowQueryThreshold() time.Duration {
+	return time.Duration(viper.GetInt("slowQueryThreshold")) * time.Millisecond
+}
+
+func (l *slowQueryLog) Record(sql string, vars []interface{}, d time.Duration, route string) {
+	size := viper.GetInt("slowQueryLogSize")
+	if size <= 0 {
+		return
+	}
+
+	entry := lib.APISlowQuery{
+		SQL:        sql,
+		Params:     queryParams(vars),
+		DurationMs: float64(d.Nanoseconds()) / float64(time.Millisecond),
+		Route:      route,
+		Timestamp:  lib.Timestamp(time.Now()),
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if len(l.entries) != size {
+		l.entries = make([]lib.APISlowQuery, size)
+		l.next, l.full = 0, false
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % size
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// queryParams formats the values bound to a statement.
+func queryParams(vars []interface{}) []string {
+	params := make([]string, len(vars))
+	for i, v := range vars {
+		params[i] = fmt.Sprint(v)
+	}
+	return params
+}
+
+// Entries returns the recorded queries, newest first.
+func (l *slowQueryLog) Entries() []lib.APISlowQuery {
+	l.Lock()
+	defer l.Unlock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	out := make([]lib.APISlowQuery, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return out
+}
+
+func adminHandleSlowQueries(c echo.Context) error {
+	return renderJSON(c, http.StatusOK, lib.APISlowQueries{
+		ThresholdMs: viper.GetInt("slowQueryThreshold"),
+		Queries:     slowQueries.Entries(),
+	})
+}
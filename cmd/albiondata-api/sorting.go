@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// sortSpec is a parsed ?sort=&order= pair.
+type sortSpec struct {
+	field string
+	desc  bool
+}
+
+func parseSort(c echo.Context, sample interface{}) (*sortSpec, error) {
+	if len(c.QueryParam("sort")) == 0 {
+		return nil, nil
+	}
+
+	spec := &sortSpec{field: c.QueryParam("sort")}
+	if !jsonFieldNames(reflect.TypeOf(sample))[spec.field] {
+		return nil, fmt.Errorf("Unknown sort field: %s", spec.field)
+	}
+
+	switch strings.ToLower(c.QueryParam("order")) {
+	case "", "asc":
+	case "desc":
+		spec.desc = true
+	default:
+		return nil, fmt.Errorf("order must be one of asc, desc")
+	}
+	return spec, nil
+}
+
+// sortColumns are the sort fields the database can rank items by, the
+// aggregate of the field over every order of the item.
+var sortColumns = map[string]struct{ expr, auctionType string }{
+	"item_id":             {"item_id", ""},
+	"sell_price_min":      {"min(price)", "offer"},
+	"sell_price_min_date": {"max(updated_at)", "offer"},
+	"sell_price_max":      {"max(price)", "offer"},
+	"sell_price_max_date": {"max(updated_at)", "offer"},
+	"buy_price_min":       {"min(price)", "request"},
+	"buy_price_min_date":  {"max(updated_at)", "request"},
+	"buy_price_max":       {"max(price)", "request"},
+	"buy_price_max_date":  {"max(updated_at)", "request"},
+	"order_count":         {"count(*)", ""},
+}
+
+// rankItemIDs orders itemIDs in SQL by the column of spec, so pages of
+// items are cut from the sorted set. Items without orders for the column
+// go last in their given order. Fields without a column keep itemIDs as
+// they are, sortResults still orders the rows.
+func rankItemIDs(orders *gorm.DB, itemIDs []string, spec *sortSpec) ([]string, error) {
+	if spec == nil || len(itemIDs) < 2 {
+		return itemIDs, nil
+	}
+	col, ok := sortColumns[spec.field]
+	if !ok {
+		return itemIDs, nil
+	}
+
+	q := orders.Select("item_id, "+col.expr+" as sort_value").Where("item_id in (?)", itemIDs)
+	if len(col.auctionType) > 0 {
+		q = q.Where("auction_type = ?", col.auctionType)
+	}
+	dir := "asc"
+	if spec.desc {
+		dir = "desc"
+	}
+	var rows []struct {
+		ItemID string
+	}
+	if err := q.Group("item_id").Order("sort_value " + dir + ", item_id asc").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ranked := make([]string, 0, len(itemIDs))
+	seen := map[string]bool{}
+	for _, r := range rows {
+		ranked = append(ranked, r.ItemID)
+		seen[r.ItemID] = true
+	}
+	for _, id := range itemIDs {
+		if !seen[id] {
+			ranked = append(ranked, id)
+		}
+	}
+	return ranked, nil
+}
+
+// sortResults sorts a slice of structs in place by the json field of spec.
+// Zero values (missing prices or dates) always go last.
+func sortResults(slice interface{}, spec *sortSpec) {
+	if spec == nil {
+		return
+	}
+
+	v := reflect.ValueOf(slice)
+	if v.Len() == 0 {
+		return
+	}
+
+	idx := -1
+	t := v.Index(0).Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonFieldName(t.Field(i)) == spec.field {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return
+	}
+
+	sort.SliceStable(slice, func(i, j int) bool {
+		a, b := v.Index(i).Field(idx), v.Index(j).Field(idx)
+		aZero, bZero := isZeroValue(a), isZeroValue(b)
+		if aZero || bZero {
+			return !aZero && bZero
+		}
+		if spec.desc {
+			return lessValue(b, a)
+		}
+		return lessValue(a, b)
+	})
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func lessValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Ptr:
+		return lessValue(a.Elem(), b.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	if ta, ok := a.Interface().(lib.Timestamp); ok {
+		return time.Time(ta).Before(time.Time(b.Interface().(lib.Timestamp)))
+	}
+	return false
+}
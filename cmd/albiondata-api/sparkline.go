@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// sparklineCache keeps the sparkline of each item for sparklineCacheTTL
+// seconds, item tables asking for overlapping lists share them. It keeps at
+// most sparklineCacheMaxEntries sparklines.
+type sparklineCache struct {
+	entries *boundedCache
+}
+
+var sparklines = &sparklineCache{entries: newBoundedCache(func() int { return viper.GetInt("sparklineCacheMaxEntries") })}
+
+func sparklineCacheTTL() time.Duration {
+	return time.Duration(viper.GetInt("sparklineCacheTTL")) * time.Second
+}
+
+func (sc *sparklineCache) Get(key string) ([]int64, bool) {
+	v, ok := sc.entries.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]int64), true
+}
+
+func (sc *sparklineCache) Set(key string, prices []int64) {
+	sc.entries.Set(key, prices, sparklineCacheTTL())
+}
+
+// apiHandleStatsSparkline returns ?points= (default 24) average prices
+// over the last ?days= (default 7, at most 30) for every item of ?items=,
+// averaged over ?locations=. Points without stats are 0.
+func apiHandleStatsSparkline(c echo.Context) error {
+	days := 7
+	if d := c.QueryParam("days"); d != "" {
+		var err error
+		if days, err = strconv.Atoi(d); err != nil || days <= 0 {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Invalid days: %s", d))
+		}
+	}
+	window := time.Duration(days) * 24 * time.Hour
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("days can't be more than %d", int(maxHistoryWindow.Hours()/24)))
+	}
+	points := defaultSparklinePoints
+	if p, err := strconv.Atoi(c.QueryParam("points")); err == nil && p > 0 && p <= maxSparklinePoints {
+		points = p
+	}
+	if len(c.QueryParam("items")) == 0 {
+		return c.String(http.StatusBadRequest, "items is required")
+	}
+
+	locs := queryLocations(c)
+	statsConn := statsDBFrom(c)
+	itemIDs, err := expandItemIDs(c, c.QueryParam("items"), statsConn.Model(&adslib.ModelMarketStats{}), "stats")
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	// Points end at the next boundary of their width, so every request in
+	// that time asks for the same buckets and the cache holds
+	width := window / time.Duration(points)
+	to := time.Now().Truncate(width).Add(width)
+	from := to.Add(-window)
+	keyPrefix := fmt.Sprintf("%s|%d|%d|%s|", databaseName(c), days, points, strings.Join(locationNames(locs), ","))
+
+	result := make([]lib.APISparkline, len(itemIDs))
+	missing := []string{}
+	for i, itemID := range itemIDs {
+		result[i].ItemID = itemID
+		if prices, ok := sparklines.Get(keyPrefix + itemID); ok {
+			result[i].Prices = prices
+		} else {
+			missing = append(missing, itemID)
+		}
+	}
+
+	if len(missing) > 0 {
+		computed, err := sparklinePrices(statsConn, missing, locs, from, width, points)
+		if err != nil {
+			return err
+		}
+		for i := range result {
+			if prices, ok := computed[result[i].ItemID]; ok {
+				result[i].Prices = prices
+				sparklines.Set(keyPrefix+result[i].ItemID, prices)
+			}
+		}
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// sparklinePrices reads the stats of all items at once and averages them
+// into points buckets of width starting at from.
+func sparklinePrices(stats *gorm.DB, itemIDs []string, locs []adslib.Location, from time.Time, width time.Duration, points int) (map[string][]int64, error) {
+	rows, err := stats.Model(&adslib.ModelMarketStats{}).Select("item_id, price_avg, timestamp").
+		Where("item_id in (?) and location in (?) and timestamp >= ?", itemIDs, locs, from).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sums := map[string][]float64{}
+	counts := map[string][]int{}
+	for _, itemID := range itemIDs {
+		sums[itemID] = make([]float64, points)
+		counts[itemID] = make([]int, points)
+	}
+	for rows.Next() {
+		var s adslib.ModelMarketStats
+		if err := stats.ScanRows(rows, &s); err != nil {
+			return nil, err
+		}
+		i := int(s.Timestamp.Sub(from) / width)
+		if i < 0 || i >= points || s.PriceAvg <= 0 || sums[s.ItemID] == nil {
+			continue
+		}
+		sums[s.ItemID][i] += s.PriceAvg
+		counts[s.ItemID][i]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := map[string][]int64{}
+	for _, itemID := range itemIDs {
+		prices := make([]int64, points)
+		for i, n := range counts[itemID] {
+			if n > 0 {
+				prices[i] = int64(math.Round(sums[itemID][i] / float64(n)))
+			}
+		}
+		result[itemID] = prices
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// spreadBucket is the lowest sell and highest buy order of a location in
+// one interval.
+type spreadBucket struct {
+	sellMin int64
+	buyMax  int64
+}
+
+// apiHandleStatsSpread returns the spread between the lowest sell order and
+// the highest buy order of every city per ?interval= (default 1h) over
+// ?window= (default 7d, at most 30d). Only buckets with both kinds of
+// orders are listed, ?quality= narrows the orders down.
+func apiHandleStatsSpread(c echo.Context) error {
+	window, err := parseWindow(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if window > maxHistoryWindow {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("window can't be more than %s", maxHistoryWindow))
+	}
+	interval, err := parseWindow(c.QueryParam("interval"), time.Hour)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if interval < time.Hour {
+		return c.String(http.StatusBadRequest, "interval can't be less than 1h")
+	}
+	quality, err := parseQuality(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	locs := queryLocations(c)
+	since := time.Now().Add(-window)
+	orders := plausibleOrders(partitionPrune(dbFrom(c).Table(adslib.NewModelMarketOrder().TableName()), since)).
+		Where("updated_at >= ?", since)
+	if quality > 0 {
+		orders = orders.Where("quality_level = ?", quality)
+	}
+	itemIDs, err := expandItemIDs(c, c.Param("item"), orders, fmt.Sprintf("spread|%s|%d", window, quality))
+	if err != nil {
+		return paramError(c, err)
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	result := []lib.APISpreadSeries{}
+	for _, itemID := range itemIDs {
+		series, err := spreadSeries(orders, itemID, locs, interval)
+		if err != nil {
+			return err
+		}
+		result = append(result, series...)
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+// spreadSeries reads the orders of one item row by row into buckets per
+// location and interval.
+func spreadSeries(orders *gorm.DB, itemID string, locs []adslib.Location, interval time.Duration) ([]lib.APISpreadSeries, error) {
+	rows, err := orders.Select("location, auction_type, price, updated_at").
+		Where("item_id = ? and location in (?)", itemID, locs).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := map[adslib.Location]map[int64]*spreadBucket{}
+	for rows.Next() {
+		var o struct {
+			Location    adslib.Location
+			AuctionType string
+			Price       int64
+			UpdatedAt   time.Time
+		}
+		if err := orders.ScanRows(rows, &o); err != nil {
+			return nil, err
+		}
+		if buckets[o.Location] == nil {
+			buckets[o.Location] = map[int64]*spreadBucket{}
+		}
+		t := o.UpdatedAt.Truncate(interval).Unix()
+		b, ok := buckets[o.Location][t]
+		if !ok {
+			b = &spreadBucket{sellMin: math.MaxInt32}
+			buckets[o.Location][t] = b
+		}
+		switch o.AuctionType {
+		case "offer":
+			if o.Price < b.sellMin {
+				b.sellMin = o.Price
+			}
+		case "request":
+			if o.Price > b.buyMax {
+				b.buyMax = o.Price
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []lib.APISpreadSeries{}
+	for _, l := range locs {
+		times := []int64{}
+		for t, b := range buckets[l] {
+			if b.sellMin != math.MaxInt32 && b.buyMax > 0 {
+				times = append(times, t)
+			}
+		}
+		if len(times) == 0 {
+			continue
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+		s := lib.APISpreadSeries{
+			ItemID:        itemID,
+			City:          l.String(),
+			Timestamps:    make([]int64, 0, len(times)),
+			SellPriceMin:  make([]int64, 0, len(times)),
+			BuyPriceMax:   make([]int64, 0, len(times)),
+			Spread:        make([]int64, 0, len(times)),
+			SpreadPercent: make([]float64, 0, len(times)),
+		}
+		for _, t := range times {
+			b := buckets[l][t]
+			s.Timestamps = append(s.Timestamps, t*1000)
+			s.SellPriceMin = append(s.SellPriceMin, b.sellMin)
+			s.BuyPriceMax = append(s.BuyPriceMax, b.buyMax)
+			s.Spread = append(s.Spread, b.sellMin-b.buyMax)
+			s.SpreadPercent = append(s.SpreadPercent, float64(b.sellMin-b.buyMax)/float64(b.sellMin)*100)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// A frontend copied into frontend/ before building is served with
+// --staticEmbedded, no files on disk needed at runtime.
+//
+//go:embed frontend
+var frontend embed.FS
+
+// embeddedFrontend returns the embedded frontend, false when the binary was
+// built without one.
+func embeddedFrontend() (fs.FS, bool) {
+	sub, err := fs.Sub(frontend, "frontend")
+	if err != nil {
+		return nil, false
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil, false
+	}
+	return sub, true
+}
+
+// serveStatic registers the static file routes under staticFilePrefix.
+// staticFolderPath, when set, takes precedence over the embedded frontend.
+// It returns false when there is nothing to serve.
+func serveStatic(e *echo.Echo) bool {
+	prefix := "/" + strings.Trim(viper.GetString("staticFilePrefix"), "/")
+
+	if viper.GetString("staticFolderPath") != "" {
+		if viper.GetString("staticFilePrefix") == "" {
+			return false
+		}
+		if !viper.GetBool("staticHardened") {
+			e.Static(prefix, viper.GetString("staticFolderPath"))
+			return true
+		}
+		h := hardenedStatic(viper.GetString("staticFolderPath"))
+		e.GET(prefix, h)
+		e.GET(strings.TrimSuffix(prefix, "/")+"/*", h)
+		return true
+	}
+
+	if !viper.GetBool("staticEmbedded") {
+		return false
+	}
+	files, ok := embeddedFrontend()
+	if !ok {
+		return false
+	}
+	h := http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(http.FS(files)))
+	e.GET(prefix, echo.WrapHandler(h))
+	e.GET(strings.TrimSuffix(prefix, "/")+"/*", echo.WrapHandler(h))
+	return true
+}
+
+// hardenedStatic serves files below root, refusing dotfiles, extensions
+// missing from staticExtensions and symlinks leading outside of root.
+// Refused paths are logged and answered with 404.
+func hardenedStatic(root string) echo.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, ext := range strings.Split(viper.GetString("staticExtensions"), ",") {
+		allowed[strings.ToLower(strings.TrimSpace(ext))] = true
+	}
+
+	reject := func(c echo.Context, p string, reason string) error {
+		fmt.Printf("Rejected static path %s from %s: %s\n", p, c.RealIP(), reason)
+		return c.String(http.StatusNotFound, "Not found")
+	}
+
+	return func(c echo.Context) error {
+		p := path.Clean("/" + c.Param("*"))
+		for _, segment := range strings.Split(p, "/") {
+			if strings.HasPrefix(segment, ".") {
+				return reject(c, p, "dotfile")
+			}
+		}
+
+		rootResolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return reject(c, p, err.Error())
+		}
+		resolved, err := filepath.EvalSymlinks(filepath.Join(rootResolved, filepath.FromSlash(p)))
+		if err != nil {
+			return c.String(http.StatusNotFound, "Not found")
+		}
+		if resolved != rootResolved && !strings.HasPrefix(resolved, rootResolved+string(filepath.Separator)) {
+			return reject(c, p, "outside of staticFolderPath")
+		}
+
+		if fi, err := os.Stat(resolved); err == nil && fi.IsDir() {
+			resolved = filepath.Join(resolved, "index.html")
+		}
+		if !allowed[strings.ToLower(filepath.Ext(resolved))] {
+			return reject(c, p, "extension not in staticExtensions")
+		}
+		return c.File(resolved)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// statusRow is the operator status of the API, the api_status table has a
+// single row so every replica serves the same message.
+type statusRow struct {
+	ID          uint `gorm:"primary_key"`
+	Message     string
+	Flags       string // comma separated
+	Maintenance string `gorm:"type:text"` // JSON list of lib.APIMaintenanceWindow
+	UpdatedAt   time.Time
+}
+
+func (statusRow) TableName() string {
+	return "api_status"
+}
+
+const statusRowID = 1
+
+// migrateStatus creates the api_status table if needed.
+func migrateStatus() error {
+	return db.AutoMigrate(&statusRow{}).Error
+}
+
+// currentStatus reads the status, maintenance windows already over are
+// left out.
+func currentStatus() (lib.APIStatus, error) {
+	result := lib.APIStatus{
+		Flags:        []string{},
+		Maintenance:  []lib.APIMaintenanceWindow{},
+		CurrentPatch: patchAt(time.Now()),
+	}
+	row := statusRow{}
+	q := db.Where("id = ?", statusRowID).First(&row)
+	if q.RecordNotFound() {
+		return result, nil
+	}
+	if q.Error != nil {
+		return result, q.Error
+	}
+
+	result.Message = row.Message
+	result.UpdatedAt = lib.Timestamp(row.UpdatedAt)
+	if row.Flags != "" {
+		result.Flags = strings.Split(row.Flags, ",")
+	}
+	windows := []lib.APIMaintenanceWindow{}
+	if row.Maintenance != "" {
+		if err := json.Unmarshal([]byte(row.Maintenance), &windows); err != nil {
+			return result, err
+		}
+	}
+	for _, w := range windows {
+		if time.Time(w.To).After(time.Now()) {
+			result.Maintenance = append(result.Maintenance, w)
+		}
+	}
+	return result, nil
+}
+
+// apiHandleStatus returns the operator message, degradation flags and
+// upcoming maintenance windows for frontends to show.
+func apiHandleStatus(c echo.Context) error {
+	status, err := currentStatus()
+	if err != nil {
+		return err
+	}
+	return renderJSON(c, http.StatusOK, status)
+}
+
+// adminHandleStatusSet replaces the status with the JSON body, an empty
+// body clears it.
+func adminHandleStatusSet(c echo.Context) error {
+	var req lib.APIStatus
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	flags := []string{}
+	for _, f := range req.Flags {
+		if f = strings.TrimSpace(f); f != "" {
+			if strings.Contains(f, ",") {
+				return c.String(http.StatusBadRequest, "flags can't contain commas")
+			}
+			flags = append(flags, f)
+		}
+	}
+	for _, w := range req.Maintenance {
+		if !time.Time(w.To).After(time.Time(w.From)) {
+			return c.String(http.StatusBadRequest, "Every maintenance window has to end after it starts")
+		}
+	}
+	maintenance, err := json.Marshal(req.Maintenance)
+	if err != nil {
+		return err
+	}
+
+	row := statusRow{ID: statusRowID, Message: req.Message, Flags: strings.Join(flags, ","), Maintenance: string(maintenance)}
+	if err := db.Save(&row).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	respCache.Purge("")
+	cluster.Broadcast(invalidateCache, "")
+
+	status, err := currentStatus()
+	if err != nil {
+		return err
+	}
+	return renderJSON(c, http.StatusOK, status)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/cobra"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// syncState is how far the local database got in the feed of a source.
+type syncState struct {
+	Source    string `gorm:"primary_key"`
+	Since     time.Time
+	AfterID   uint
+	UpdatedAt time.Time
+}
+
+func (syncState) TableName() string {
+	return "sync_state"
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Copies the orders of another albiondata-api instance into the database, --interval keeps it current",
+	Run: func(cmd *cobra.Command, args []string) {
+		source, _ := cmd.Flags().GetString("from")
+		key, _ := cmd.Flags().GetString("key")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if source == "" {
+			fmt.Println("--from is required")
+			return
+		}
+		if err := openDB(); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		defer db.Close()
+		loadExecHook()
+		if err := db.AutoMigrate(&syncState{}).Error; err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+
+		for {
+			copied, err := syncOrders(strings.TrimRight(source, "/"), key)
+			fmt.Printf("Synced %d orders from %s\n", copied, source)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			}
+			if interval <= 0 {
+				return
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	syncCmd.Flags().String("from", "", "Base URL of the instance to copy from, like https://mirror.example.com")
+	syncCmd.Flags().String("key", "", "API key of the instance, its tier needs exports")
+	syncCmd.Flags().Duration("interval", 0, "Sync again after this long, like 1m, 0 syncs once")
+	rootCmd.AddCommand(syncCmd)
+}
+
+// syncOrders pages through the /api/v1/export/orders feed of source from
+// where the last run stopped, writing each page and the new cursor in one
+// transaction. Orders already stored are skipped, the others go through the
+// ingest hooks.
+func syncOrders(source string, key string) (int, error) {
+	state := syncState{Source: source}
+	if err := db.Where("source = ?", source).FirstOrInit(&state).Error; err != nil {
+		return 0, err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	copied := 0
+	for {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(maxFeedLimit))
+		query.Set("after_id", strconv.FormatUint(uint64(state.AfterID), 10))
+		if !state.Since.IsZero() {
+			query.Set("since", state.Since.UTC().Format(time.RFC3339Nano))
+		}
+		req, err := http.NewRequest(http.MethodGet, source+"/api/v1/export/orders?"+query.Encode(), nil)
+		if err != nil {
+			return copied, err
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return copied, err
+		}
+		var page lib.APIOrderFeed
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return copied, fmt.Errorf("%s answered %s", source, res.Status)
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return copied, err
+		}
+
+		tx := db.Begin()
+		for _, o := range page.Orders {
+			m := orderFromFeed(o)
+			stored, err := upsertOrder(tx, m)
+			if err != nil {
+				tx.Rollback()
+				return copied, err
+			}
+			if stored {
+				copied++
+			}
+		}
+		state.Since, state.AfterID = page.NextSince, page.NextAfter
+		if err := tx.Save(&state).Error; err != nil {
+			tx.Rollback()
+			return copied, err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return copied, err
+		}
+
+		if !page.More {
+			return copied, nil
+		}
+	}
+}
+
+// upsertOrder stores an order of the feed by its albion_id, which is unique
+// in market_orders. An order uploaded again comes with a newer updated_at and
+// replaces the local row, older or equal ones are skipped. It reports
+// whether the order was written.
+func upsertOrder(tx *gorm.DB, m adslib.ModelMarketOrder) (bool, error) {
+	var existing adslib.ModelMarketOrder
+	q := tx.Unscoped().Where("albion_id = ?", m.AlbionID).First(&existing)
+	if q.Error != nil && !q.RecordNotFound() {
+		return false, q.Error
+	}
+	found := !q.RecordNotFound()
+	if found && !m.UpdatedAt.After(existing.UpdatedAt) {
+		return false, nil
+	}
+	if !ingestOrder(&m) {
+		return false, nil
+	}
+	if !found {
+		return true, tx.Create(&m).Error
+	}
+
+	// UpdateColumns keeps the updated_at of the feed instead of setting now
+	return true, tx.Unscoped().Model(&existing).UpdateColumns(map[string]interface{}{
+		"item_id":           m.ItemID,
+		"quality_level":     m.QualityLevel,
+		"enchantment_level": m.EnchantmentLevel,
+		"price":             m.Price,
+		"initial_amount":    m.InitialAmount,
+		"amount":            m.Amount,
+		"auction_type":      m.AuctionType,
+		"expires":           m.Expires,
+		"location":          m.Location,
+		"updated_at":        m.UpdatedAt,
+		"deleted_at":        nil,
+	}).Error
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// telemetryReport gathers the aggregate counts sent by the telemetry job.
+// It holds nothing about clients: no IPs, keys or item ids, only how often
+// each route was used in the current usageWindow.
+func telemetryReport() lib.APITelemetryReport {
+	report := lib.APITelemetryReport{
+		Instance:      cluster.id,
+		Version:       version,
+		DBType:        viper.GetString("dbType"),
+		UptimeSeconds: int64(time.Since(usage.started).Seconds()),
+		Routes:        usage.RouteTotals(),
+		Endpoints:     []string{},
+		Features:      []string{},
+		Jobs:          []string{},
+	}
+	if report.Version == "" {
+		report.Version = "dev"
+	}
+	for _, name := range endpointNames {
+		if endpointEnabled(name) {
+			report.Endpoints = append(report.Endpoints, name)
+		}
+	}
+	for _, f := range features.Status() {
+		if f.Enabled {
+			report.Features = append(report.Features, f.Name)
+		}
+	}
+	// Read from the config, the jobs list refers to this job
+	for name := range viper.GetStringMap("jobs") {
+		if viper.GetBool("jobs." + name + ".enabled") {
+			report.Jobs = append(report.Jobs, name)
+		}
+	}
+	sort.Strings(report.Jobs)
+	return report
+}
+
+// jobTelemetry posts telemetryReport to jobs.telemetry.url, it only runs
+// when an operator enables the job.
+func jobTelemetry(e *echo.Echo) error {
+	if viper.GetString("jobs.telemetry.url") == "" {
+		return fmt.Errorf("jobs.telemetry.url isn't set")
+	}
+	b, err := json.Marshal(telemetryReport())
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Post(viper.GetString("jobs.telemetry.url"), echo.MIMEApplicationJSON, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Telemetry endpoint answered %s", res.Status)
+	}
+	return nil
+}
+
+// adminHandleTelemetry shows the report the telemetry job sends, whether or
+// not it is enabled.
+func adminHandleTelemetry(c echo.Context) error {
+	return renderJSON(c, http.StatusOK, telemetryReport())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+// tenant is a logical instance served under /<name>/, configured under
+// tenants.<name>. Its requests read the database profile Database (the
+// primary one when empty), are cached apart from other tenants and are rate
+// limited with RateLimit instead of the tier's limit when it is set.
+type tenant struct {
+	Name      string
+	Database  string
+	RateLimit int
+}
+
+var tenants = map[string]tenant{}
+
+// loadTenants reads the tenants config key, the database profiles have to
+// be open already.
+func loadTenants() error {
+	for name := range viper.GetStringMap("tenants") {
+		t := tenant{}
+		if err := viper.UnmarshalKey("tenants."+name, &t); err != nil {
+			return fmt.Errorf("tenant %s: %v", name, err)
+		}
+		t.Name = name
+		switch name {
+		case "api", "admin", "view", "metrics", ".well-known":
+			return fmt.Errorf("tenant %s: the name is taken by a route", name)
+		}
+		if t.Database != "" && t.Database != primaryDatabase {
+			if _, ok := databases[t.Database]; !ok {
+				return fmt.Errorf("tenant %s: unknown database %s", name, t.Database)
+			}
+		}
+		tenants[name] = t
+	}
+	return nil
+}
+
+// routeTenant runs before routing, it takes the tenant prefix off the path
+// and selects the tenant's database, so /east/api/v1/stats/prices/T4_BAG is
+// routed like /api/v1/stats/prices/T4_BAG?db=<database of east>. A ?db=
+// given by the client is replaced.
+func routeTenant(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+		t, ok := tenants[parts[0]]
+		if !ok {
+			return next(c)
+		}
+
+		req.URL.Path = "/"
+		if len(parts) == 2 {
+			req.URL.Path += parts[1]
+		}
+		req.URL.RawPath = ""
+		query := req.URL.Query()
+		query.Del("db")
+		if t.Database != "" {
+			query.Set("db", t.Database)
+		}
+		req.URL.RawQuery = query.Encode()
+		req.RequestURI = req.URL.RequestURI()
+
+		c.Set("tenant", t)
+		return next(c)
+	}
+}
+
+// tenantFor returns the tenant a request came in through.
+func tenantFor(c echo.Context) (tenant, bool) {
+	t, ok := c.Get("tenant").(tenant)
+	return t, ok
+}
+
+// tenantName is "" for requests outside of any tenant.
+func tenantName(c echo.Context) string {
+	t, _ := tenantFor(c)
+	return t.Name
+}
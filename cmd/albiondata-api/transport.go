@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const defaultTransportLimit = 50
+const maxTransportLimit = 500
+
+// itemWeights holds the weight in kg per item id loaded from
+// --itemWeightsFile, items.json doesn't have them.
+type itemWeights struct {
+	sync.RWMutex
+	byID map[string]float64
+}
+
+var weights = &itemWeights{byID: map[string]float64{}}
+
+// Load replaces the weights with the contents of a JSON file mapping item
+// ids to kg, like {"T4_ORE": 0.51}.
+func (iw *itemWeights) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	byID := map[string]float64{}
+	if err := json.NewDecoder(f).Decode(&byID); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	iw.Lock()
+	iw.byID = byID
+	iw.Unlock()
+	return nil
+}
+
+func (iw *itemWeights) Get(id string) (float64, bool) {
+	iw.RLock()
+	defer iw.RUnlock()
+	w, ok := iw.byID[id]
+	return w, ok
+}
+
+func (iw *itemWeights) IDs() []string {
+	iw.RLock()
+	defer iw.RUnlock()
+	ids := make([]string, 0, len(iw.byID))
+	for id := range iw.byID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// apiHandleStatsTransport ranks items by the silver made per kg carried
+// when buying at the cheapest sell order in ?from= and selling to the
+// highest buy order in ?to=. ?items= narrows the items down, by default
+// every item with a known weight is looked at. ?limit= (default 50, at most
+// 500) caps the number of results.
+func apiHandleStatsTransport(c echo.Context) error {
+	from, ok := findLocation(c.QueryParam("from"))
+	if len(c.QueryParam("from")) == 0 || !ok {
+		return c.String(http.StatusBadRequest, "from must be a location")
+	}
+	to, ok := findLocation(c.QueryParam("to"))
+	if len(c.QueryParam("to")) == 0 || !ok {
+		return c.String(http.StatusBadRequest, "to must be a location")
+	}
+	limit := defaultTransportLimit
+	if len(c.QueryParam("limit")) > 0 {
+		var err error
+		limit, err = strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || limit < 1 || limit > maxTransportLimit {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("limit must be a number from 1 to %d", maxTransportLimit))
+		}
+	}
+
+	age, err := ageWindow(c, "prices")
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	now := time.Now()
+	table := ordersTable(c)
+	orders := plausibleOrders(dbFrom(c).Table(table).
+		Where("updated_at >= ? and expires > ?", now.Add(-time.Duration(age)*time.Second), now))
+
+	itemIDs := weights.IDs()
+	if len(c.QueryParam("items")) > 0 {
+		itemIDs, err = expandItemIDs(c, c.QueryParam("items"), orders, fmt.Sprintf("%s|%d", table, age))
+		if err != nil {
+			return paramError(c, err)
+		}
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames([]adslib.Location{from, to})
+	meta.ItemsResolved = itemIDs
+	meta.AgeApplied = age
+
+	result := []lib.APITransportItem{}
+	if len(itemIDs) == 0 {
+		return renderJSON(c, http.StatusOK, result)
+	}
+
+	type itemPrice struct {
+		ItemID string
+		Price  int64
+	}
+	buy, sell := []itemPrice{}, []itemPrice{}
+	if err := orders.Select("item_id, min(price) as price").
+		Where("location = ? and auction_type = ? and item_id in (?)", from, "offer", itemIDs).
+		Group("item_id").Scan(&buy).Error; err != nil {
+		return err
+	}
+	if err := orders.Select("item_id, max(price) as price").
+		Where("location = ? and auction_type = ? and item_id in (?)", to, "request", itemIDs).
+		Group("item_id").Scan(&sell).Error; err != nil {
+		return err
+	}
+	sellPrice := map[string]int64{}
+	for _, p := range sell {
+		sellPrice[p.ItemID] = p.Price
+	}
+
+	for _, p := range buy {
+		weight, ok := weights.Get(p.ItemID)
+		if !ok || weight <= 0 || sellPrice[p.ItemID] == 0 {
+			continue
+		}
+		profit := sellPrice[p.ItemID] - p.Price
+		if profit <= 0 {
+			continue
+		}
+		result = append(result, lib.APITransportItem{
+			ItemID:          p.ItemID,
+			BuyPrice:        p.Price,
+			SellPrice:       sellPrice[p.ItemID],
+			Profit:          profit,
+			Weight:          weight,
+			ProfitPerWeight: float64(profit) / weight,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].ProfitPerWeight > result[j].ProfitPerWeight })
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// upstreamCache keeps upstream answers for upstreamCacheTTL seconds, by URL.
+type upstreamCache struct {
+	sync.Mutex
+	entries map[string]upstreamEntry
+}
+
+type upstreamEntry struct {
+	prices  []lib.APIStatsPricesItem
+	expires time.Time
+}
+
+var upstream = &upstreamCache{entries: map[string]upstreamEntry{}}
+
+func (uc *upstreamCache) get(u string) ([]lib.APIStatsPricesItem, bool) {
+	uc.Lock()
+	defer uc.Unlock()
+	e, ok := uc.entries[u]
+	if ok && time.Now().After(e.expires) {
+		delete(uc.entries, u)
+		ok = false
+	}
+	return e.prices, ok
+}
+
+func (uc *upstreamCache) set(u string, prices []lib.APIStatsPricesItem) {
+	uc.Lock()
+	defer uc.Unlock()
+	uc.entries[u] = upstreamEntry{
+		prices:  prices,
+		expires: time.Now().Add(time.Duration(viper.GetInt("upstreamCacheTTL")) * time.Second),
+	}
+}
+
+// Prices fetches the prices of the items in the cities from upstreamURL,
+// which has to serve /api/v1/stats/prices like this API or the public one.
+func (uc *upstreamCache) Prices(itemIDs []string, cities []string, quality int) ([]lib.APIStatsPricesItem, error) {
+	query := url.Values{}
+	query.Set("locations", strings.Join(cities, ","))
+	if quality > 0 {
+		// The public API calls it qualities
+		query.Set("quality", strconv.Itoa(quality))
+		query.Set("qualities", strconv.Itoa(quality))
+	}
+	u := fmt.Sprintf("%s/api/v1/stats/prices/%s?%s", strings.TrimRight(viper.GetString("upstreamURL"), "/"),
+		url.PathEscape(strings.Join(itemIDs, ",")), query.Encode())
+	if prices, ok := uc.get(u); ok {
+		return prices, nil
+	}
+
+	client := &http.Client{Timeout: time.Duration(viper.GetInt("upstreamTimeout")) * time.Second}
+	res, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Upstream answered %s", res.Status)
+	}
+	prices := []lib.APIStatsPricesItem{}
+	if err := json.NewDecoder(res.Body).Decode(&prices); err != nil {
+		return nil, fmt.Errorf("Upstream: %v", err)
+	}
+	uc.set(u, prices)
+	return prices, nil
+}
+
+// useUpstream reports whether missing prices of the request are asked from
+// upstreamURL, only current prices of the primary database are.
+func useUpstream(c echo.Context) bool {
+	return viper.GetString("upstreamURL") != "" && databaseName(c) == primaryDatabase && len(c.QueryParam("at")) == 0
+}
+
+// fillFromUpstream adds the upstream prices of the item and location pairs
+// missing from result. Upstream failures are logged, the local prices are
+// served anyway.
+func fillFromUpstream(c echo.Context, result []lib.APIStatsPricesItem, itemIDs []string, locs []adslib.Location, quality int) []lib.APIStatsPricesItem {
+	have := map[string]bool{}
+	for _, r := range result {
+		have[r.ItemID+"|"+r.City] = true
+	}
+	missingItems := map[string]bool{}
+	missingCities := map[string]bool{}
+	missingIDs, cities := []string{}, []string{}
+	for _, itemID := range itemIDs {
+		for _, l := range locs {
+			if have[itemID+"|"+l.String()] {
+				continue
+			}
+			if !missingItems[itemID] {
+				missingItems[itemID] = true
+				missingIDs = append(missingIDs, itemID)
+			}
+			if !missingCities[l.String()] {
+				missingCities[l.String()] = true
+				cities = append(cities, l.String())
+			}
+		}
+	}
+	if len(missingIDs) == 0 {
+		return result
+	}
+
+	prices, err := upstream.Prices(missingIDs, cities, quality)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return result
+	}
+	filled := 0
+	for _, p := range prices {
+		l, ok := findLocation(p.City)
+		if !ok || !missingItems[p.ItemID] || !missingCities[l.String()] || have[p.ItemID+"|"+l.String()] {
+			continue
+		}
+		// The public API lists pairs without data with zero prices
+		if p.SellPriceMin == 0 && p.SellPriceMax == 0 && p.BuyPriceMin == 0 && p.BuyPriceMax == 0 {
+			continue
+		}
+		p.City = l.String()
+		have[p.ItemID+"|"+p.City] = true
+		result = append(result, p)
+		filled++
+	}
+	if filled > 0 {
+		c.Response().Header().Set("X-Upstream-Rows", strconv.Itoa(filled))
+	}
+	return result
+}
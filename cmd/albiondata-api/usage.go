@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// clientUsage counts the /api requests of a rate limit client in the
+// reporting window starting at window.
+type clientUsage struct {
+	window   time.Time
+	requests int
+	rejected int
+	last     time.Time
+	routes   map[string]int
+}
+
+type usageCounter struct {
+	sync.Mutex
+	started time.Time
+	clients map[string]*clientUsage
+	pruned  int64
+}
+
+var usage = &usageCounter{started: time.Now(), clients: map[string]*clientUsage{}}
+
+// Window returns the start of the reporting window of t. Windows are
+// usageWindow seconds long, a day starts at midnight UTC. With a
+// usageWindow of 0 the counts run from the start of this instance.
+func (uc *usageCounter) Window(t time.Time) time.Time {
+	window := time.Duration(viper.GetInt("usageWindow")) * time.Second
+	if window <= 0 {
+		return uc.started
+	}
+	return t.UTC().Truncate(window)
+}
+
+func (uc *usageCounter) Record(client string, route string, status int) {
+	now := time.Now()
+	window := uc.Window(now)
+
+	uc.Lock()
+	defer uc.Unlock()
+	if minute := now.Unix() / 60; minute != uc.pruned {
+		uc.prune(minute, window)
+	}
+
+	cu, ok := uc.clients[client]
+	if !ok || cu.window.Before(window) {
+		cu = &clientUsage{window: window, routes: map[string]int{}}
+		uc.clients[client] = cu
+	}
+	cu.requests++
+	if status == http.StatusTooManyRequests {
+		cu.rejected++
+	}
+	cu.last = now
+	cu.routes[route]++
+}
+
+// prune drops clients without requests in the current window.
+func (uc *usageCounter) prune(minute int64, window time.Time) {
+	uc.pruned = minute
+	for client, cu := range uc.clients {
+		if cu.window.Before(window) {
+			delete(uc.clients, client)
+		}
+	}
+}
+
+// Get returns the usage of client in the current window.
+func (uc *usageCounter) Get(client string) (clientUsage, bool) {
+	window := uc.Window(time.Now())
+	uc.Lock()
+	defer uc.Unlock()
+	cu, ok := uc.clients[client]
+	if !ok || cu.window.Before(window) {
+		return clientUsage{}, false
+	}
+	copied := *cu
+	copied.routes = make(map[string]int, len(cu.routes))
+	for r, n := range cu.routes {
+		copied.routes[r] = n
+	}
+	return copied, true
+}
+
+// RouteTotals sums the requests per route over all clients in the current
+// window.
+func (uc *usageCounter) RouteTotals() map[string]int {
+	window := uc.Window(time.Now())
+	uc.Lock()
+	defer uc.Unlock()
+	totals := map[string]int{}
+	for _, cu := range uc.clients {
+		if cu.window.Before(window) {
+			continue
+		}
+		for r, n := range cu.routes {
+			totals[r] += n
+		}
+	}
+	return totals
+}
+
+// trackUsage counts the /api requests of every client, including the ones
+// rejected by the rate limit.
+func trackUsage(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if strings.HasPrefix(c.Request().URL.Path, "/api/") && !isInternal(c) {
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			}
+			usage.Record(rateLimitClient(c), c.Path(), status)
+		}
+		return err
+	}
+}
+
+const usagePath = "/api/v1/me/usage"
+
+// apiHandleUsage reports the calling key's (or without a key, the calling
+// IP's) requests in the current usageWindow and where it stands in the
+// current rate limit window. It doesn't count against the limit itself.
+func apiHandleUsage(c echo.Context) error {
+	t := tierFor(c)
+	result := lib.APIUsage{
+		Tier:   t.Name,
+		Since:  lib.Timestamp(usage.Window(time.Now())),
+		Routes: map[string]int{},
+	}
+	if k, ok := c.Get("apiKey").(apiKey); ok {
+		result.Key = k.Name
+	}
+
+	client := rateLimitClient(c)
+	if cu, ok := usage.Get(client); ok {
+		result.Requests = cu.requests
+		result.Rejected = cu.rejected
+		result.LastRequest = lib.Timestamp(cu.last)
+		result.Routes = cu.routes
+	}
+
+	limit := t.RateLimit
+	if tn, ok := tenantFor(c); ok && tn.RateLimit > 0 {
+		limit = tn.RateLimit
+	}
+	if limit > 0 && apiLimiter != nil {
+		window := time.Duration(viper.GetInt("rateLimitWindow")) * time.Second
+		used, reset, err := apiLimiter.Peek(client, window)
+		if err != nil {
+			return c.String(http.StatusServiceUnavailable, err.Error())
+		}
+		remaining := limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		result.RateLimit = &lib.APIRateLimitStatus{
+			Limit:         limit,
+			WindowSeconds: viper.GetInt("rateLimitWindow"),
+			Used:          used,
+			Remaining:     remaining,
+			Reset:         lib.Timestamp(reset),
+			Limited:       used >= limit,
+		}
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageWindows(t *testing.T) {
+	uc := &usageCounter{started: time.Now(), clients: map[string]*clientUsage{}}
+	uc.Record("a", "/api/v1/stats/prices/:item", 200)
+	uc.Record("a", "/api/v1/stats/prices/:item", 429)
+	uc.Record("b", "/api/v1/stats/charts/:item", 200)
+	if cu, ok := uc.Get("a"); !ok || cu.requests != 2 || cu.rejected != 1 {
+		t.Fatalf("a: %+v, want 2 requests, 1 rejected", cu)
+	}
+
+	// a and b were last seen in the window before this one
+	window := uc.Window(time.Now())
+	for _, cu := range uc.clients {
+		cu.window = window.Add(-time.Second)
+	}
+	if _, ok := uc.Get("a"); ok {
+		t.Errorf("a: usage of the last window reported")
+	}
+	if totals := uc.RouteTotals(); len(totals) != 0 {
+		t.Errorf("route totals %v, want none in this window", totals)
+	}
+
+	uc.Record("a", "/api/v1/stats/prices/:item", 200)
+	if cu, ok := uc.Get("a"); !ok || cu.requests != 1 || cu.rejected != 0 {
+		t.Errorf("a: %+v, want the counts to start over", cu)
+	}
+
+	uc.prune(uc.pruned+1, window)
+	if _, ok := uc.clients["b"]; ok {
+		t.Errorf("b: idle client not pruned")
+	}
+	if _, ok := uc.clients["a"]; !ok {
+		t.Errorf("a: active client pruned")
+	}
+}
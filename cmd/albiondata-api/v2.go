@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// /api/v2 takes the response changes v1 can't get without breaking existing
+// tools: null for missing prices, a row per quality and paged envelopes.
+// v1 stays as it is.
+
+const (
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// parsePage reads ?page= (from 1) and ?per_page=.
+func parsePage(c echo.Context) (int, int, error) {
+	page, perPage := 1, defaultPerPage
+	if len(c.QueryParam("page")) > 0 {
+		p, err := strconv.Atoi(c.QueryParam("page"))
+		if err != nil || p < 1 {
+			return 0, 0, fmt.Errorf("page must be a number from 1")
+		}
+		page = p
+	}
+	if len(c.QueryParam("per_page")) > 0 {
+		p, err := strconv.Atoi(c.QueryParam("per_page"))
+		if err != nil || p < 1 || p > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+		perPage = p
+	}
+	return page, perPage, nil
+}
+
+// pageBounds returns the slice bounds of a page over total entries.
+func pageBounds(page int, perPage int, total int) (int, int) {
+	from := (page - 1) * perPage
+	if from > total {
+		from = total
+	}
+	to := from + perPage
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+func priceOrNil(price int64, date lib.Timestamp) (*int64, *lib.Timestamp) {
+	if time.Time(date).IsZero() {
+		return nil, nil
+	}
+	return &price, &date
+}
+
+func v2PriceItem(r lib.APIStatsPricesItem, quality int) lib.APIV2PricesItem {
+	res := lib.APIV2PricesItem{
+		ItemID:         r.ItemID,
+		City:           r.City,
+		Quality:        quality,
+		DataAgeSeconds: r.DataAgeSeconds,
+		Stale:          r.Stale,
+		OrderCount:     r.OrderCount,
+		Confidence:     r.Confidence,
+	}
+	res.SellPriceMin, res.SellPriceMinDate = priceOrNil(r.SellPriceMin, r.SellPriceMinDate)
+	res.SellPriceMax, res.SellPriceMaxDate = priceOrNil(r.SellPriceMax, r.SellPriceMaxDate)
+	res.BuyPriceMin, res.BuyPriceMinDate = priceOrNil(r.BuyPriceMin, r.BuyPriceMinDate)
+	res.BuyPriceMax, res.BuyPriceMaxDate = priceOrNil(r.BuyPriceMax, r.BuyPriceMaxDate)
+	return res
+}
+
+// apiHandleV2StatsPrices returns a row per item, city and quality, ?quality=
+// limits it to one quality. Like charts it pages items, a page has the rows
+// of per_page items and ?sort= orders them.
+func apiHandleV2StatsPrices(c echo.Context) error {
+	sortBy, err := parseSort(c, lib.APIV2PricesItem{})
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	page, perPage, err := parsePage(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	quality, err := parseQuality(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	qualities := []int{quality}
+	if quality == 0 {
+		qualities = []int{}
+		for q := minQuality; q <= maxQuality; q++ {
+			qualities = append(qualities, q)
+		}
+	}
+
+	scope, err := pricesOrders(c, "prices", quality)
+	if err != nil {
+		return paramError(c, err)
+	}
+	raw := c.Param("item")
+	itemIDs, err := expandItemIDs(c, raw, scope.orders, scope.key)
+	if err != nil {
+		return paramError(c, err)
+	}
+	if itemIDs, err = rankItemIDs(scope.orders, itemIDs, sortBy); err != nil {
+		return err
+	}
+
+	// Only the items of the requested page are queried, once per quality
+	from, to := pageBounds(page, perPage, len(itemIDs))
+	results := []lib.APIV2PricesItem{}
+	if from < to {
+		c.SetParamNames("item")
+		c.SetParamValues(strings.Join(itemIDs[from:to], ","))
+		for _, q := range qualities {
+			rows, err := getStatsPricesItem(c, "prices", nil, q)
+			if err != nil {
+				return paramError(c, err)
+			}
+			for _, r := range rows {
+				results = append(results, v2PriceItem(r, q))
+			}
+		}
+		c.SetParamValues(raw)
+	}
+	sortResults(results, sortBy)
+
+	requestMeta(c).ItemsResolved = itemIDs
+	return renderJSON(c, http.StatusOK, lib.APIV2Page{
+		Data:       results,
+		Pagination: lib.APIV2Pagination{Page: page, PerPage: perPage, Total: len(itemIDs)},
+	})
+}
+
+// v2Charts is a v1 chart series with RFC3339 timestamps.
+func v2Charts(r lib.APIStatsChartsResponse) lib.APIV2Charts {
+	timestamps := make([]lib.Timestamp, len(r.Data.Timestamps))
+	for i, ms := range r.Data.Timestamps {
+		timestamps[i] = lib.Timestamp(time.Unix(0, ms*int64(time.Millisecond)))
+	}
+	return lib.APIV2Charts{
+		Location: r.Location,
+		Quality:  r.Quality,
+		Data: lib.APIV2ChartsSeries{
+			Timestamps:    timestamps,
+			PricesMin:     r.Data.PricesMin,
+			PricesMax:     r.Data.PricesMax,
+			PricesAvg:     r.Data.PricesAvg,
+			Indicators:    r.Data.Indicators,
+			ChangePercent: r.Data.ChangePercent,
+		},
+	}
+}
+
+// apiHandleV2StatsCharts always answers with a page of items, even for a
+// single one.
+func apiHandleV2StatsCharts(c echo.Context) error {
+	page, perPage, err := parsePage(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	locs := queryLocations(c)
+	conn, statsConn := dbFrom(c), statsDBFrom(c)
+	itemIDs, err := expandItemIDs(c, c.Param("item"), statsConn.Model(&adslib.ModelMarketStats{}), "stats")
+	if err != nil {
+		return paramError(c, err)
+	}
+	opts, err := parseChartOptions(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	meta := requestMeta(c)
+	meta.LocationsResolved = locationNames(locs)
+	meta.ItemsResolved = itemIDs
+
+	// Only the items of the requested page are read
+	from, to := pageBounds(page, perPage, len(itemIDs))
+	result := []lib.APIV2ChartsItem{}
+	for _, itemID := range itemIDs[from:to] {
+		item := lib.APIV2ChartsItem{ItemID: itemID, Data: []lib.APIV2Charts{}}
+		for _, series := range getStatsCharts(conn, statsConn, itemID, locs, opts) {
+			item.Data = append(item.Data, v2Charts(series))
+		}
+		result = append(result, item)
+	}
+	return renderJSON(c, http.StatusOK, lib.APIV2Page{
+		Data:       result,
+		Pagination: lib.APIV2Pagination{Page: page, PerPage: perPage, Total: len(itemIDs)},
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		page, perPage, total int
+		from, to             int
+	}{
+		{1, 10, 25, 0, 10},
+		{3, 10, 25, 20, 25},
+		{4, 10, 25, 25, 25},
+		{2, 5, 10, 5, 10},
+		{1, 10, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if from, to := pageBounds(tt.page, tt.perPage, tt.total); from != tt.from || to != tt.to {
+			t.Errorf("pageBounds(%d, %d, %d) = %d, %d, want %d, %d", tt.page, tt.perPage, tt.total, from, to, tt.from, tt.to)
+		}
+	}
+}
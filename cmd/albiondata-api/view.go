@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+)
+
+// Columns of /stats/view when ?columns= isn't given
+var defaultViewColumns = []string{
+	"item_id", "city",
+	"sell_price_min", "sell_price_min_date", "sell_price_max", "sell_price_max_date",
+	"buy_price_min", "buy_price_min_date", "buy_price_max", "buy_price_max_date",
+}
+
+// viewRow is a price result with the quality it was queried for, 0 for all.
+type viewRow struct {
+	lib.APIStatsPricesItem
+	Quality int
+}
+
+// parseViewColumns reads ?columns=, any price field plus quality and
+// enchant.
+func parseViewColumns(c echo.Context) ([]string, error) {
+	if len(c.QueryParam("columns")) == 0 {
+		return defaultViewColumns, nil
+	}
+
+	known := jsonFieldNames(reflect.TypeOf(lib.APIStatsPricesItem{}))
+	known["quality"] = true
+	known["enchant"] = true
+
+	columns := []string{}
+	for _, col := range strings.Split(c.QueryParam("columns"), ",") {
+		col = strings.TrimSpace(col)
+		if !known[col] {
+			return nil, fmt.Errorf("Unknown column: %s", col)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// enchantLevel reads the @N suffix of enchanted item ids.
+func enchantLevel(itemID string) int {
+	i := strings.LastIndex(itemID, "@")
+	if i < 0 {
+		return 0
+	}
+	level, _ := strconv.Atoi(itemID[i+1:])
+	return level
+}
+
+// formatThousands writes n with comma thousands separators.
+func formatThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	if neg {
+		return "-" + s
+	}
+	return s
+}
+
+// viewCell renders one column of a row, dates in loc.
+func viewCell(r viewRow, col string, loc *time.Location) string {
+	switch col {
+	case "quality":
+		return strconv.Itoa(r.Quality)
+	case "enchant":
+		return strconv.Itoa(enchantLevel(r.ItemID))
+	}
+
+	v := reflect.ValueOf(r.APIStatsPricesItem)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonFieldName(t.Field(i)) != col {
+			continue
+		}
+		switch field := v.Field(i).Interface().(type) {
+		case lib.Timestamp:
+			if time.Time(field).IsZero() {
+				return ""
+			}
+			return field.In(loc)
+		case int:
+			return formatThousands(int64(field))
+		case int64:
+			return formatThousands(field)
+		case float64:
+			return strconv.FormatFloat(field, 'f', 2, 64)
+		default:
+			return html.EscapeString(fmt.Sprint(field))
+		}
+	}
+	return ""
+}
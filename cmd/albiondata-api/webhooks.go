@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tikz/albiondata-api/lib"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhook receives the new market_stats buckets of the items matching
+// Items, a comma separated list of ids or path.Match patterns.
+type webhook struct {
+	ID        uint `gorm:"primary_key"`
+	URL       string
+	Items     string
+	Secret    string
+	CreatedAt time.Time
+}
+
+func (webhook) TableName() string {
+	return "webhooks"
+}
+
+func (w webhook) API() lib.APIWebhook {
+	return lib.APIWebhook{
+		ID:        w.ID,
+		URL:       w.URL,
+		Items:     strings.Split(w.Items, ","),
+		CreatedAt: lib.Timestamp(w.CreatedAt),
+	}
+}
+
+func (w webhook) Matches(itemID string) bool {
+	for _, pattern := range strings.Split(w.Items, ",") {
+		if ok, _ := path.Match(pattern, itemID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookRegistry keeps the webhooks table in memory.
+type webhookRegistry struct {
+	sync.RWMutex
+	hooks []webhook
+}
+
+var webhooks = &webhookRegistry{}
+
+// Load creates the webhooks table if needed and reads it.
+func (wr *webhookRegistry) Load() error {
+	if err := db.AutoMigrate(&webhook{}).Error; err != nil {
+		return err
+	}
+	hooks := []webhook{}
+	if err := db.Order("id asc").Find(&hooks).Error; err != nil {
+		return err
+	}
+
+	wr.Lock()
+	defer wr.Unlock()
+	wr.hooks = hooks
+	return nil
+}
+
+func (wr *webhookRegistry) Hooks() []webhook {
+	wr.RLock()
+	defer wr.RUnlock()
+	return append([]webhook{}, wr.hooks...)
+}
+
+// Notify posts the buckets each webhook subscribed to, in the background.
+// The body is signed with the webhook secret in X-Signature (hex HMAC-SHA256).
+func (wr *webhookRegistry) Notify(stats []adslib.ModelMarketStats) {
+	for _, w := range wr.Hooks() {
+		buckets := []lib.APIStatsBucket{}
+		for _, s := range stats {
+			if w.Matches(s.ItemID) {
+				buckets = append(buckets, lib.APIStatsBucket{
+					ItemID:    s.ItemID,
+					Location:  s.Location.String(),
+					Timestamp: lib.Timestamp(s.Timestamp),
+					PriceMin:  int64(s.PriceMin),
+					PriceMax:  int64(s.PriceMax),
+					PriceAvg:  s.PriceAvg,
+					Patch:     patchAt(s.Timestamp),
+				})
+			}
+		}
+		if len(buckets) == 0 {
+			continue
+		}
+		go func(w webhook, buckets []lib.APIStatsBucket) {
+			if err := postWebhook(w, buckets); err != nil {
+				fmt.Printf("Webhook %d: %v\n", w.ID, err)
+			}
+		}(w, buckets)
+	}
+}
+
+func postWebhook(w webhook, buckets []lib.APIStatsBucket) error {
+	body, err := json.Marshal(buckets)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", w.URL, res.Status)
+	}
+	return nil
+}
+
+func adminHandleWebhooks(c echo.Context) error {
+	result := []lib.APIWebhook{}
+	for _, w := range webhooks.Hooks() {
+		result = append(result, w.API())
+	}
+	return renderJSON(c, http.StatusOK, result)
+}
+
+func adminHandleWebhookAdd(c echo.Context) error {
+	var req lib.APIWebhook
+	if err := c.Bind(&req); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+		return c.String(http.StatusBadRequest, "url must be a http(s) URL")
+	}
+	if len(req.Items) == 0 {
+		return c.String(http.StatusBadRequest, "items is required")
+	}
+	for _, pattern := range req.Items {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Invalid item pattern: %s", pattern))
+		}
+	}
+
+	w := webhook{URL: req.URL, Items: strings.Join(req.Items, ","), Secret: req.Secret}
+	if err := db.Create(&w).Error; err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	if err := webhooks.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return renderJSON(c, http.StatusCreated, w.API())
+}
+
+func adminHandleWebhookDelete(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, "id must be a number")
+	}
+	res := db.Where("id = ?", id).Delete(&webhook{})
+	if res.Error != nil {
+		return c.String(http.StatusInternalServerError, res.Error.Error())
+	}
+	if res.RowsAffected == 0 {
+		return c.String(http.StatusNotFound, "No such webhook")
+	}
+	if err := webhooks.Load(); err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	viper.SetDefault("wellKnown.robots", "User-agent: *\nDisallow: /api/\nDisallow: /admin/\nDisallow: /view/\n")
+}
+
+// serveWellKnown adds /robots.txt, /favicon.ico and
+// /.well-known/security.txt, the files every public host gets asked for.
+// Empty settings leave a path to the static files.
+func serveWellKnown(e *echo.Echo) {
+	if robots := viper.GetString("wellKnown.robots"); robots != "" {
+		e.GET("/robots.txt", func(c echo.Context) error {
+			return c.String(http.StatusOK, robots)
+		})
+	}
+
+	// Without an icon answer 204, browsers stop asking and nothing is logged as missing
+	favicon := viper.GetString("wellKnown.favicon")
+	e.GET("/favicon.ico", func(c echo.Context) error {
+		if favicon == "" {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.File(favicon)
+	})
+
+	if contact := viper.GetString("wellKnown.securityContact"); contact != "" {
+		// security.txt has to expire, a year after startup keeps it current
+		lines := []string{
+			"Contact: " + contact,
+			"Expires: " + time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339),
+		}
+		if policy := viper.GetString("wellKnown.securityPolicy"); policy != "" {
+			lines = append(lines, "Policy: "+policy)
+		}
+		body := strings.Join(lines, "\n") + "\n"
+		e.GET("/.well-known/security.txt", func(c echo.Context) error {
+			return c.String(http.StatusOK, body)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/spf13/viper"
+	adslib "github.com/tikz/albiondata-sql/lib"
+)
+
+// How often the database is checked for item ids that are new to cached
+// wildcard expansions
+const wildcardWatchInterval = time.Minute
+
+type wildcardEntry struct {
+	pattern string
+	ids     map[string]bool
+	list    []string
+}
+
+// wildcardCache remembers which item ids a wildcard pattern expanded to, so
+// the DISTINCT scan behind it doesn't run on every request. It keeps at most
+// wildcardCacheMaxEntries expansions.
+type wildcardCache struct {
+	entries *boundedCache
+}
+
+var wildcards = &wildcardCache{entries: newBoundedCache(func() int { return viper.GetInt("wildcardCacheMaxEntries") })}
+
+func wildcardCacheTTL() time.Duration {
+	return time.Duration(viper.GetInt("wildcardCacheTTL")) * time.Second
+}
+
+// Get returns the cached expansion of pattern in scope, a key telling the
+// queried tables and filters apart.
+func (wc *wildcardCache) Get(scope string, pattern string) ([]string, bool) {
+	v, ok := wc.entries.Get(scope + "|" + pattern)
+	if !ok {
+		return nil, false
+	}
+	return v.(*wildcardEntry).list, true
+}
+
+func (wc *wildcardCache) Set(scope string, pattern string, ids []string) {
+	e := &wildcardEntry{pattern: pattern, ids: map[string]bool{}, list: ids}
+	for _, id := range ids {
+		e.ids[id] = true
+	}
+	wc.entries.Set(scope+"|"+pattern, e, wildcardCacheTTL())
+}
+
+// Invalidate drops expansions a new item id would have been part of.
+func (wc *wildcardCache) Invalidate(ids []string) int {
+	return wc.entries.DeleteFunc(func(key string, v interface{}) bool {
+		e := v.(*wildcardEntry)
+		for _, id := range ids {
+			if ok, _ := path.Match(e.pattern, id); ok && !e.ids[id] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// watchNewItems looks for item ids updated since the last check and
+// invalidates the expansions missing them, until ctx is done.
+func (wc *wildcardCache) watchNewItems(ctx context.Context) {
+	if wildcardCacheTTL() <= 0 {
+		return
+	}
+
+	go func() {
+		last := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wildcardWatchInterval):
+			}
+
+			now := time.Now()
+			ids := []string{}
+			if err := db.Table(adslib.NewModelMarketOrder().TableName()).Where("updated_at > ?", last).Group("item_id").Pluck("item_id", &ids).Error; err != nil {
+				fmt.Printf("Wildcard cache: %v\n", err)
+				continue
+			}
+			statIDs := []string{}
+			if err := tableDB("stats").Model(&adslib.ModelMarketStats{}).Where("timestamp > ?", last).Group("item_id").Pluck("item_id", &statIDs).Error; err != nil {
+				fmt.Printf("Wildcard cache: %v\n", err)
+				continue
+			}
+			last = now
+
+			wc.Invalidate(append(ids, statIDs...))
+		}
+	}()
+}
@@ -2,32 +2,549 @@ package lib
 
 import "time"
 
+// Timestamp serializes as an RFC3339 string in UTC, regardless of the
+// timezone the database driver handed back.
+type Timestamp time.Time
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.String() + `"`), nil
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	parsed, err := time.Parse(`"`+time.RFC3339+`"`, string(data))
+	if err != nil {
+		return err
+	}
+	*t = Timestamp(parsed)
+	return nil
+}
+
+func (t Timestamp) String() string {
+	return time.Time(t).UTC().Format(time.RFC3339)
+}
+
+// In formats the timestamp as RFC3339 in the given location.
+func (t Timestamp) In(loc *time.Location) string {
+	return time.Time(t).In(loc).Format(time.RFC3339)
+}
+
 type APIStatsPricesItem struct {
 	ItemID           string    `json:"item_id"`
 	City             string    `json:"city"`
-	SellPriceMin     int       `json:"sell_price_min"`
-	SellPriceMinDate time.Time `json:"sell_price_min_date"`
-	SellPriceMax     int       `json:"sell_price_max"`
-	SellPriceMaxDate time.Time `json:"sell_price_max_date"`
-	BuyPriceMin      int       `json:"buy_price_min"`
-	BuyPriceMinDate  time.Time `json:"buy_price_min_date"`
-	BuyPriceMax      int       `json:"buy_price_max"`
-	BuyPriceMaxDate  time.Time `json:"buy_price_max_date"`
+	SellPriceMin     int64     `json:"sell_price_min"`
+	SellPriceMinDate Timestamp `json:"sell_price_min_date"`
+	SellPriceMax     int64     `json:"sell_price_max"`
+	SellPriceMaxDate Timestamp `json:"sell_price_max_date"`
+	BuyPriceMin      int64     `json:"buy_price_min"`
+	BuyPriceMinDate  Timestamp `json:"buy_price_min_date"`
+	BuyPriceMax      int64     `json:"buy_price_max"`
+	BuyPriceMaxDate  Timestamp `json:"buy_price_max_date"`
+	DataAgeSeconds   int64     `json:"data_age_seconds"`
+	Stale            bool      `json:"stale,omitempty"`
+	OrderCount       int       `json:"order_count"`
+	Confidence       float64   `json:"confidence"`
+}
+
+type APIStatsPricesBulkRequest struct {
+	Items     []string `json:"items"`
+	Locations []string `json:"locations"`
 }
 
 type APIStatsChartsResponse struct {
 	Location string                         `json:"location"`
+	Quality  int                            `json:"quality,omitempty"`
 	Data     APIStatsChartsLocationResponse `json:"data"`
 }
 
 type APIStatsChartsLocationResponse struct {
 	Timestamps []int64   `json:"timestamps"`
-	PricesMin  []int     `json:"prices_min"`
-	PricesMax  []int     `json:"prices_max"`
+	PricesMin  []int64   `json:"prices_min"`
+	PricesMax  []int64   `json:"prices_max"`
 	PricesAvg  []float64 `json:"prices_avg"`
+
+	Indicators    map[string][]*float64 `json:"indicators,omitempty"`
+	ChangePercent *float64              `json:"change_percent,omitempty"`
 }
 
 type APIStatesChartsResponse struct {
-	Timestamps []int64	`json:"timestamps"`
-	Prices	   []int	`json:"prices"`
-}
\ No newline at end of file
+	Timestamps []int64 `json:"timestamps"`
+	Prices     []int64 `json:"prices"`
+}
+
+type APIAdminCacheStats struct {
+	Entries    int    `json:"entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+type APIAdminCachePurge struct {
+	Purged int `json:"purged"`
+}
+
+type APISlowQuery struct {
+	SQL        string    `json:"sql"`
+	Params     []string  `json:"params"`
+	DurationMs float64   `json:"duration_ms"`
+	Route      string    `json:"route"`
+	Timestamp  Timestamp `json:"timestamp"`
+}
+
+type APISlowQueries struct {
+	ThresholdMs int            `json:"threshold_ms"`
+	Queries     []APISlowQuery `json:"queries"`
+}
+
+type APIWebhook struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Items     []string  `json:"items"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type APIStatsBucket struct {
+	ItemID    string    `json:"item_id"`
+	Location  string    `json:"location"`
+	Timestamp Timestamp `json:"timestamp"`
+	PriceMin  int64     `json:"price_min"`
+	PriceMax  int64     `json:"price_max"`
+	PriceAvg  float64   `json:"price_avg"`
+	Patch     string    `json:"patch,omitempty"`
+}
+
+type APIQueryEvent struct {
+	Timestamp  Timestamp         `json:"timestamp"`
+	Route      string            `json:"route"`
+	Params     map[string]string `json:"params"`
+	Query      string            `json:"query"`
+	Status     int               `json:"status"`
+	DurationMs float64           `json:"duration_ms"`
+}
+
+type APIGoldTick struct {
+	Price     int64     `json:"price"`
+	Timestamp Timestamp `json:"timestamp"`
+}
+
+type APIContribution struct {
+	Date     string `json:"date"`
+	Location string `json:"location"`
+	Orders   int    `json:"orders"`
+}
+
+type APIBlacklistEntry struct {
+	ID        uint      `json:"id"`
+	Kind      string    `json:"kind"`
+	AlbionID  uint      `json:"albion_id,omitempty"`
+	ItemID    string    `json:"item_id,omitempty"`
+	PriceMin  int64     `json:"price_min,omitempty"`
+	PriceMax  int64     `json:"price_max,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type APIChange struct {
+	ItemID string `json:"item_id"`
+	City   string `json:"city"`
+}
+
+type APIChangesResponse struct {
+	Since   Timestamp   `json:"since"`
+	Until   Timestamp   `json:"until"`
+	Changes []APIChange `json:"changes"`
+}
+
+type APIJobStatus struct {
+	Name           string    `json:"name"`
+	Enabled        bool      `json:"enabled"`
+	Schedule       string    `json:"schedule"`
+	Runs           int       `json:"runs"`
+	Skipped        int       `json:"skipped"`
+	LastRun        Timestamp `json:"last_run"`
+	LastDurationMs int64     `json:"last_duration_ms"`
+	LastError      string    `json:"last_error"`
+	NextRun        Timestamp `json:"next_run"`
+}
+
+type APIMeta struct {
+	LocationsResolved []string          `json:"locations_resolved"`
+	ItemsResolved     []string          `json:"items_resolved"`
+	AgeApplied        int               `json:"age_applied,omitempty"`
+	GeneratedAt       Timestamp         `json:"generated_at"`
+	Cache             string            `json:"cache"`
+	Warning           string            `json:"warning,omitempty"`
+	Aliases           map[string]string `json:"aliases,omitempty"`
+	Debug             *APIDebug         `json:"debug,omitempty"`
+}
+
+type APIDebug struct {
+	Queries        int     `json:"queries"`
+	DBTimeMs       float64 `json:"db_time_ms"`
+	Cache          string  `json:"cache"`
+	WildcardHits   int     `json:"wildcard_hits"`
+	WildcardMisses int     `json:"wildcard_misses"`
+}
+
+type APIEnvelope struct {
+	Data interface{} `json:"data"`
+	Meta *APIMeta    `json:"meta"`
+}
+
+type APIStatsChartsItemResponse struct {
+	ItemID string                   `json:"item_id"`
+	Data   []APIStatsChartsResponse `json:"data"`
+}
+
+type APIGoldSummary struct {
+	Price            int64     `json:"price"`
+	Timestamp        Timestamp `json:"timestamp"`
+	Change24h        int64     `json:"change_24h"`
+	Change24hPercent float64   `json:"change_24h_percent"`
+	Change7d         int64     `json:"change_7d"`
+	Change7dPercent  float64   `json:"change_7d_percent"`
+	Window           string    `json:"window"`
+	WindowMin        int64     `json:"window_min"`
+	WindowMax        int64     `json:"window_max"`
+	Sparkline        []int64   `json:"sparkline"`
+}
+
+type APIItemCandidate struct {
+	ItemID string `json:"item_id"`
+	Name   string `json:"name"`
+}
+
+type APIAmbiguousName struct {
+	Error      string             `json:"error"`
+	Candidates []APIItemCandidate `json:"candidates"`
+}
+
+type APIV2PricesItem struct {
+	ItemID           string     `json:"item_id"`
+	City             string     `json:"city"`
+	Quality          int        `json:"quality"`
+	SellPriceMin     *int64     `json:"sell_price_min"`
+	SellPriceMinDate *Timestamp `json:"sell_price_min_date"`
+	SellPriceMax     *int64     `json:"sell_price_max"`
+	SellPriceMaxDate *Timestamp `json:"sell_price_max_date"`
+	BuyPriceMin      *int64     `json:"buy_price_min"`
+	BuyPriceMinDate  *Timestamp `json:"buy_price_min_date"`
+	BuyPriceMax      *int64     `json:"buy_price_max"`
+	BuyPriceMaxDate  *Timestamp `json:"buy_price_max_date"`
+	DataAgeSeconds   int64      `json:"data_age_seconds"`
+	Stale            bool       `json:"stale"`
+	OrderCount       int        `json:"order_count"`
+	Confidence       float64    `json:"confidence"`
+}
+
+type APIV2Pagination struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+type APIV2Page struct {
+	Data       interface{}     `json:"data"`
+	Pagination APIV2Pagination `json:"pagination"`
+}
+
+type APIV2ChartsSeries struct {
+	Timestamps []Timestamp `json:"timestamps"`
+	PricesMin  []int64     `json:"prices_min"`
+	PricesMax  []int64     `json:"prices_max"`
+	PricesAvg  []float64   `json:"prices_avg"`
+
+	Indicators    map[string][]*float64 `json:"indicators,omitempty"`
+	ChangePercent *float64              `json:"change_percent,omitempty"`
+}
+
+type APIV2Charts struct {
+	Location string            `json:"location"`
+	Quality  int               `json:"quality,omitempty"`
+	Data     APIV2ChartsSeries `json:"data"`
+}
+
+type APIV2ChartsItem struct {
+	ItemID string        `json:"item_id"`
+	Data   []APIV2Charts `json:"data"`
+}
+
+type APIGrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+type APIGrafanaQueryRequest struct {
+	Range struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type APIGrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type APIHistorySummary struct {
+	Location      string    `json:"location"`
+	From          Timestamp `json:"from"`
+	To            Timestamp `json:"to"`
+	PriceMin      int64     `json:"price_min"`
+	PriceMax      int64     `json:"price_max"`
+	PriceAvg      float64   `json:"price_avg"`
+	LastPriceAvg  float64   `json:"last_price_avg"`
+	ChangePercent *float64  `json:"change_percent"`
+}
+
+type APIMarketItem struct {
+	ItemID  string               `json:"item_id"`
+	Name    string               `json:"name,omitempty"`
+	Tier    int                  `json:"tier,omitempty"`
+	IconURL string               `json:"icon_url"`
+	Prices  []APIStatsPricesItem `json:"prices"`
+	History []APIHistorySummary  `json:"history"`
+}
+
+type APIPriceChange struct {
+	ItemID        string    `json:"item_id"`
+	City          string    `json:"city"`
+	From          Timestamp `json:"from"`
+	To            Timestamp `json:"to"`
+	PriceFrom     float64   `json:"price_from"`
+	PriceTo       float64   `json:"price_to"`
+	Change        float64   `json:"change"`
+	ChangePercent *float64  `json:"change_percent"`
+}
+
+type APIItemMetrics struct {
+	ItemID            string  `json:"item_id"`
+	City              string  `json:"city"`
+	Days              int     `json:"days"`
+	DailyAvg          float64 `json:"daily_avg"`
+	Volatility        float64 `json:"volatility"`
+	VolatilityPercent float64 `json:"volatility_percent"`
+	OrdersPerDay      float64 `json:"orders_per_day"`
+	AvgAmount         float64 `json:"avg_amount"`
+}
+
+type APICorrelation struct {
+	A           string    `json:"a"`
+	B           string    `json:"b"`
+	Window      string    `json:"window"`
+	Coefficient *float64  `json:"coefficient"`
+	Timestamps  []int64   `json:"timestamps"`
+	SeriesA     []float64 `json:"series_a"`
+	SeriesB     []float64 `json:"series_b"`
+}
+
+type APIMaterialCost struct {
+	ItemID string `json:"item_id"`
+	Count  int    `json:"count"`
+	Price  int64  `json:"price"`
+}
+
+type APIRefiningResult struct {
+	ItemID        string            `json:"item_id"`
+	City          string            `json:"city"`
+	ReturnRate    float64           `json:"return_rate"`
+	Materials     []APIMaterialCost `json:"materials"`
+	Cost          float64           `json:"cost"`
+	SellPrice     int64             `json:"sell_price"`
+	Profit        float64           `json:"profit"`
+	ProfitPercent float64           `json:"profit_percent"`
+}
+
+type APITransportItem struct {
+	ItemID          string  `json:"item_id"`
+	BuyPrice        int64   `json:"buy_price"`
+	SellPrice       int64   `json:"sell_price"`
+	Profit          int64   `json:"profit"`
+	Weight          float64 `json:"weight"`
+	ProfitPerWeight float64 `json:"profit_per_weight"`
+}
+
+type APIImplausibleOrder struct {
+	AlbionID  uint      `json:"albion_id"`
+	ItemID    string    `json:"item_id"`
+	City      string    `json:"city"`
+	Price     int64     `json:"price"`
+	UpdatedAt Timestamp `json:"updated_at"`
+}
+
+type APIQueryPlan struct {
+	SQL     string     `json:"sql"`
+	Params  []string   `json:"params"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type APIExplain struct {
+	Status  int            `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Queries []APIQueryPlan `json:"queries"`
+}
+
+type APIItemAlias struct {
+	OldID     string    `json:"old_id"`
+	NewID     string    `json:"new_id"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type APIGamePatch struct {
+	Name     string     `json:"name"`
+	Released Timestamp  `json:"released"`
+	Until    *Timestamp `json:"until"`
+}
+
+type APIMaintenanceWindow struct {
+	From Timestamp `json:"from"`
+	To   Timestamp `json:"to"`
+	Note string    `json:"note,omitempty"`
+}
+
+type APIStatus struct {
+	Message      string                 `json:"message"`
+	Flags        []string               `json:"flags"`
+	Maintenance  []APIMaintenanceWindow `json:"maintenance"`
+	CurrentPatch string                 `json:"current_patch,omitempty"`
+	UpdatedAt    Timestamp              `json:"updated_at"`
+}
+
+type APIFeatureFlag struct {
+	Name       string    `json:"name"`
+	Enabled    bool      `json:"enabled"`
+	Configured bool      `json:"configured"`
+	Overridden bool      `json:"overridden"`
+	UpdatedAt  Timestamp `json:"updated_at,omitempty"`
+}
+
+type APIFeedOrder struct {
+	ID               uint      `json:"id"`
+	AlbionID         uint      `json:"albion_id"`
+	ItemID           string    `json:"item_id"`
+	QualityLevel     int8      `json:"quality_level"`
+	EnchantmentLevel int8      `json:"enchantment_level"`
+	Price            int64     `json:"price"`
+	InitialAmount    int64     `json:"initial_amount"`
+	Amount           int64     `json:"amount"`
+	AuctionType      string    `json:"auction_type"`
+	Expires          time.Time `json:"expires"`
+	Location         int       `json:"location"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type APIOrderFeed struct {
+	Orders    []APIFeedOrder `json:"orders"`
+	NextSince time.Time      `json:"next_since"`
+	NextAfter uint           `json:"next_after_id"`
+	More      bool           `json:"more"`
+}
+
+type APIRateLimitStatus struct {
+	Limit         int       `json:"limit"`
+	WindowSeconds int       `json:"window_seconds"`
+	Used          int       `json:"used"`
+	Remaining     int       `json:"remaining"`
+	Reset         Timestamp `json:"reset"`
+	Limited       bool      `json:"limited"`
+}
+
+type APIUsage struct {
+	Key         string              `json:"key,omitempty"`
+	Tier        string              `json:"tier"`
+	Since       Timestamp           `json:"since"`
+	Requests    int                 `json:"requests"`
+	Rejected    int                 `json:"rejected"`
+	LastRequest Timestamp           `json:"last_request"`
+	Routes      map[string]int      `json:"routes"`
+	RateLimit   *APIRateLimitStatus `json:"rate_limit,omitempty"`
+}
+
+type APIAuditEntry struct {
+	ID        uint      `json:"id"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	URI       string    `json:"uri"`
+	Payload   string    `json:"payload,omitempty"`
+	Status    int       `json:"status"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+type APITelemetryReport struct {
+	Instance      string         `json:"instance"`
+	Version       string         `json:"version"`
+	DBType        string         `json:"db_type"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Routes        map[string]int `json:"routes"`
+	Endpoints     []string       `json:"endpoints"`
+	Features      []string       `json:"features"`
+	Jobs          []string       `json:"jobs"`
+}
+
+type APISpreadSeries struct {
+	ItemID        string    `json:"item_id"`
+	City          string    `json:"city"`
+	Timestamps    []int64   `json:"timestamps"`
+	SellPriceMin  []int64   `json:"sell_price_min"`
+	BuyPriceMax   []int64   `json:"buy_price_max"`
+	Spread        []int64   `json:"spread"`
+	SpreadPercent []float64 `json:"spread_percent"`
+}
+
+type APIPriceRecord struct {
+	ItemID   string `json:"item_id"`
+	City     string `json:"city"`
+	Price    int64  `json:"price"`
+	Previous int64  `json:"previous"`
+}
+
+type APIMarketActivity struct {
+	City   string `json:"city"`
+	Orders int    `json:"orders"`
+	Items  int    `json:"items"`
+}
+
+type APIItemActivity struct {
+	ItemID string `json:"item_id"`
+	Orders int    `json:"orders"`
+}
+
+type APIMarketReport struct {
+	Period    string              `json:"period"`
+	From      Timestamp           `json:"from"`
+	To        Timestamp           `json:"to"`
+	Movers    []APIPriceChange    `json:"movers"`
+	Highs     []APIPriceRecord    `json:"highs"`
+	Lows      []APIPriceRecord    `json:"lows"`
+	Markets   []APIMarketActivity `json:"markets"`
+	TopItems  []APIItemActivity   `json:"top_items"`
+	Generated Timestamp           `json:"generated"`
+}
+
+type APISparkline struct {
+	ItemID string  `json:"item_id"`
+	Prices []int64 `json:"prices"`
+}
+
+type APIAbuseFlag struct {
+	Reason   string    `json:"reason"`
+	Detected Timestamp `json:"detected"`
+}
+
+type APIAbuseClient struct {
+	Client           string         `json:"client"`
+	Requests         int            `json:"requests"`
+	CurrentMinute    int            `json:"current_minute"`
+	Baseline         float64        `json:"baseline"`
+	WildcardPatterns int            `json:"wildcard_patterns"`
+	Flags            []APIAbuseFlag `json:"flags"`
+	ThrottledUntil   *Timestamp     `json:"throttled_until,omitempty"`
+}